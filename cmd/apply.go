@@ -0,0 +1,332 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/deis/pkg/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/client"
+	"github.com/genevera/corectl/components/host/session"
+	"github.com/genevera/corectl/components/target"
+)
+
+// plan actions
+const (
+	planCreate  = "create"
+	planKeep    = "keep"
+	planReplace = "replace"
+	planPrune   = "prune"
+)
+
+type (
+	// planStep is what apply is about to do to a given VM, and why
+	planStep struct {
+		Action, Name string
+		Why          []string
+	}
+	plan []*planStep
+)
+
+var (
+	applyCmd = &cobra.Command{
+		Use:   "apply path/to/yourProfile",
+		Short: "Makes the running VMs match the ones defined in a profile",
+		Long: "Compares the VMs defined in an instrumentation file (see " +
+			"'load') with the running ones, and then boots the missing " +
+			"ones, replaces those whose settings changed meanwhile and, " +
+			"with '--prune', halts the ones the profile doesn't define.",
+		PreRunE: profileArgs,
+		RunE:    applyCommand,
+		Example: `  corectl apply profiles/demo.toml
+  corectl apply --dry-run --prune profiles/demo.toml`,
+	}
+	downCmd = &cobra.Command{
+		Use:     "down path/to/yourProfile",
+		Short:   "Halts the running VMs defined in a profile",
+		PreRunE: profileArgs,
+		RunE:    downCommand,
+		Example: `  corectl down profiles/demo.toml`,
+	}
+)
+
+func profileArgs(cmd *cobra.Command, args []string) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("Incorrect usage: " +
+			"This command requires one argument (a file path)")
+	}
+	session.Caller.CmdLine.BindPFlags(cmd.Flags())
+	return
+}
+
+func applyCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c        *client.Client
+		running  api.VMmap
+		vmDefs   map[string]*viper.Viper
+		ordered  []string
+		deps     map[string][]string
+		boot     []string
+		halt     []string
+		ctx      = context.Background()
+		cli      = session.Caller.CmdLine
+		parallel = cli.GetInt("parallel")
+	)
+
+	if parallel < 1 {
+		return fmt.Errorf("'--parallel' must be at least 1")
+	}
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if vmDefs, ordered, err = readProfile(args[0]); err != nil {
+		return
+	}
+	if deps, err = dependencies(vmDefs, ordered); err != nil {
+		return
+	}
+	if running, err = c.ListVMs(ctx); err != nil {
+		return
+	}
+
+	p := planFor(vmDefs, ordered, running, cli.GetBool("prune"))
+	p.print()
+	if cli.GetBool("dry-run") {
+		return
+	}
+	for _, s := range p {
+		switch s.Action {
+		case planCreate:
+			boot = append(boot, s.Name)
+		case planReplace:
+			boot = append(boot, s.Name)
+			halt = append(halt, s.Name)
+		case planPrune:
+			halt = append(halt, s.Name)
+		}
+	}
+	if len(halt) > 0 {
+		if err = stop(c, halt); err != nil {
+			return
+		}
+	}
+	sort.Strings(boot)
+	return bootAll(c, vmDefs, boot, deps, parallel)
+}
+
+func downCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c       *client.Client
+		running api.VMmap
+		ordered []string
+		halt    []string
+		ctx     = context.Background()
+	)
+
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if _, ordered, err = readProfile(args[0]); err != nil {
+		return
+	}
+	if running, err = c.ListVMs(ctx); err != nil {
+		return
+	}
+	for _, name := range ordered {
+		if runningAs(running, name) != nil {
+			halt = append(halt, name)
+		} else {
+			fmt.Printf("> %s isn't running\n", name)
+		}
+	}
+	if len(halt) == 0 {
+		return
+	}
+	return stop(c, halt)
+}
+
+// stop halts the given VMs, waiting for them to be gone
+func stop(c *client.Client, names []string) (err error) {
+	var killed []string
+
+	for _, name := range names {
+		fmt.Printf("> halting %s\n", name)
+	}
+	if _, killed, err = c.Stop(context.Background(), names...); err != nil {
+		return
+	}
+	if len(killed) > 0 {
+		log.Warn("%v didn't power off in time, so had to be hard killed",
+			killed)
+	}
+	return
+}
+
+func runningAs(running api.VMmap, name string) *api.VMInfo {
+	for _, vm := range running {
+		if vm.Name == name {
+			return vm
+		}
+	}
+	return nil
+}
+
+// planFor works out what it takes for the running VMs to match the profile
+func planFor(vmDefs map[string]*viper.Viper, ordered []string,
+	running api.VMmap, prune bool) (p plan) {
+	for _, name := range ordered {
+		vm := runningAs(running, name)
+		if vm == nil {
+			p = append(p, &planStep{Action: planCreate, Name: name})
+			continue
+		}
+		if why := drift(vmDefs[name], vm); len(why) > 0 {
+			p = append(p, &planStep{planReplace, name, why})
+		} else {
+			p = append(p, &planStep{Action: planKeep, Name: name})
+		}
+	}
+	if !prune {
+		return
+	}
+	var extra []string
+	for _, vm := range running {
+		if _, defined := vmDefs[vm.Name]; !defined {
+			extra = append(extra, vm.Name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		p = append(p, &planStep{Action: planPrune, Name: name})
+	}
+	return
+}
+
+func (p plan) print() {
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 5, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "action\tname\twhy\n")
+	for _, s := range p {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", s.Action, s.Name,
+			strings.Join(s.Why, ", "))
+	}
+	w.Flush()
+}
+
+// drift lists the settings in which a running VM differs from its
+// definition. Settings left to their defaults, or that get resolved only at
+// boot time (such as a 'latest' version), never count as such.
+func drift(spec *viper.Viper, vm *api.VMInfo) (why []string) {
+	differs := func(what string, want, got interface{}) {
+		if fmt.Sprint(want) != fmt.Sprint(got) {
+			why = append(why, fmt.Sprintf("%s: %v -> %v", what, got, want))
+		}
+	}
+
+	wanted, _ := target.Get(spec.GetString("target"))
+	if running, _ := target.Get(vm.Target); wanted != nil && running != nil {
+		differs("target", wanted.Name(), running.Name())
+		differs("channel",
+			target.Channel(wanted, spec.GetString("channel")), vm.Channel)
+	}
+	if v := target.Version(spec.GetString("version")); v != "latest" {
+		differs("version", v, vm.Version)
+	}
+	if id := spec.GetString("uuid"); id != "random" {
+		differs("uuid", strings.ToUpper(id), vm.UUID)
+	}
+	differs("cpus", spec.GetInt("cpus"), vm.Cpus)
+	differs("memory", spec.GetInt("memory"), vm.Memory)
+	if h := spec.GetString("hypervisor"); h != "" {
+		differs("hypervisor", h, vm.Hypervisor)
+	}
+	differs("sshkey", spec.GetString("sshkey"), vm.SSHkey)
+	differs("extra", spec.GetString("extra"), vm.AddToHypervisor)
+	differs("boot", spec.GetString("boot"), vm.AddToKernel)
+	overlay := spec.GetString("initrd-overlay")
+	if abs, err := filepath.Abs(overlay); err == nil && overlay != "" {
+		overlay = abs
+	}
+	differs("initrd-overlay", overlay, vm.InitrdOverlay)
+	differs("shared-homedir", spec.GetBool("shared-homedir"),
+		vm.SharedHomedir)
+	restart := vm.Restart
+	if restart == "" {
+		restart = api.RestartNo
+	}
+	differs("restart", spec.GetString("restart"), restart)
+	differs("max-restarts", spec.GetInt("max-restarts"), vm.MaxRestarts)
+	differs("stop-timeout", spec.GetInt("stop-timeout"), vm.StopTimeout)
+	differs("cloud_config", cloudConfigOf(spec.GetString("cloud_config")),
+		vm.CloudConfig)
+
+	var want, got []string
+	for _, v := range append([]string{spec.GetString("root")},
+		viperStringSliceBugWorkaround(spec.GetStringSlice("volume"))...) {
+		if v == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(v); err == nil {
+			v = abs
+		}
+		want = append(want, v)
+	}
+	for _, v := range vm.Storage.HardDrives {
+		got = append(got, v.Path)
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+	differs("volumes", want, got)
+	return
+}
+
+// cloudConfigOf mimics how the VM's cloud-config location gets recorded
+func cloudConfigOf(config string) string {
+	if config == "" {
+		return ""
+	}
+	if u, err := url.Parse(config); err == nil && u.Scheme != "" {
+		return config
+	}
+	if abs, err := filepath.Abs(config); err == nil {
+		return abs
+	}
+	return config
+}
+
+func init() {
+	applyCmd.Flags().IntP("parallel", "P", 1,
+		"how many VMs may be booting at the same time")
+	applyCmd.Flags().Bool("prune", false,
+		"halts the running VMs not defined in the profile")
+	applyCmd.Flags().Bool("dry-run", false,
+		"only prints the plan, without acting upon it")
+	if session.AppName() != "corectld" {
+		rootCmd.AddCommand(applyCmd)
+		rootCmd.AddCommand(downCmd)
+	}
+}
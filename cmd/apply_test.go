@@ -0,0 +1,169 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/genevera/corectl/components/api"
+	"github.com/spf13/viper"
+)
+
+// loadProfile reads the given (toml) profile through readProfile, as apply
+// does
+func loadProfile(t *testing.T, toml string) (map[string]*viper.Viper,
+	[]string) {
+	dir, err := ioutil.TempDir("", "corectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	def := filepath.Join(dir, "profile.toml")
+	if err = ioutil.WriteFile(def, []byte(toml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vmDefs, ordered, err := readProfile(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vmDefs, ordered
+}
+
+// booted is how a VM booted off runFlagsDefaults looks like once running
+func booted(name string) *api.VMInfo {
+	return &api.VMInfo{
+		Name:       name,
+		UUID:       "0C1E5C8D-8F9A-4C7B-9C1B-2B2B0C1B6B7A",
+		Target:     "coreos",
+		Channel:    "alpha",
+		Version:    "1745.3.1",
+		Cpus:       1,
+		Memory:     api.MinMemory,
+		Hypervisor: "hyperkit",
+	}
+}
+
+func TestDrift(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		profile string
+		vm      func(*api.VMInfo)
+		why     []string
+	}{
+		{
+			name:    "defaults",
+			profile: "[a]\ncpus = 1\n",
+		},
+		{
+			name:    "latest",
+			profile: "[a]\nversion = \"latest\"\n",
+		},
+		{
+			name:    "explicit defaults",
+			profile: "[a]\nchannel = \"alpha\"\nrestart = \"no\"\n",
+		},
+		{
+			name:    "restart policy left unset by the VM",
+			profile: "[a]\nrestart = \"no\"\n",
+			vm:      func(vm *api.VMInfo) { vm.Restart = "" },
+		},
+		{
+			name:    "top level settings",
+			profile: "memory = 2048\n[a]\ncpus = 1\n",
+			vm:      func(vm *api.VMInfo) { vm.Memory = 2048 },
+		},
+		{
+			name:    "pinned version",
+			profile: "[a]\nversion = \"1800.0.0\"\n",
+			why:     []string{"version: 1745.3.1 -> 1800.0.0"},
+		},
+		{
+			name:    "resized",
+			profile: "[a]\ncpus = 2\nmemory = 2048\n",
+			why:     []string{"cpus: 1 -> 2", "memory: 1024 -> 2048"},
+		},
+		{
+			name:    "channel",
+			profile: "[a]\nchannel = \"beta\"\n",
+			why:     []string{"channel: alpha -> beta"},
+		},
+		{
+			name:    "uuid",
+			profile: "[a]\nuuid = \"0c1e5c8d-8f9a-4c7b-9c1b-2b2b0c1b6b7b\"\n",
+			why: []string{"uuid: 0C1E5C8D-8F9A-4C7B-9C1B-2B2B0C1B6B7A -> " +
+				"0C1E5C8D-8F9A-4C7B-9C1B-2B2B0C1B6B7B"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			vmDefs, _ := loadProfile(t, tc.profile)
+			vm := booted("a")
+			if tc.vm != nil {
+				tc.vm(vm)
+			}
+			if why := drift(vmDefs["a"], vm); !reflect.DeepEqual(why,
+				tc.why) {
+				t.Errorf("got %q, want %q", why, tc.why)
+			}
+		})
+	}
+}
+
+func TestPlanFor(t *testing.T) {
+	vmDefs, ordered := loadProfile(t,
+		"[kept]\ncpus = 1\n[new]\ncpus = 1\n[resized]\ncpus = 2\n")
+	running := api.VMmap{}
+	for _, name := range []string{"kept", "resized", "extra"} {
+		vm := booted(name)
+		vm.UUID = name
+		running[name] = vm
+	}
+
+	for _, tc := range []struct {
+		name  string
+		prune bool
+		plan  plan
+	}{
+		{
+			name: "without pruning",
+			plan: plan{
+				{Action: planKeep, Name: "kept"},
+				{Action: planCreate, Name: "new"},
+				{planReplace, "resized", []string{"cpus: 1 -> 2"}},
+			},
+		},
+		{
+			name:  "pruning",
+			prune: true,
+			plan: plan{
+				{Action: planKeep, Name: "kept"},
+				{Action: planCreate, Name: "new"},
+				{planReplace, "resized", []string{"cpus: 1 -> 2"}},
+				{Action: planPrune, Name: "extra"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if p := planFor(vmDefs, ordered, running, tc.prune); !reflect.
+				DeepEqual(p, tc.plan) {
+				t.Errorf("got %v, want %v", p, tc.plan)
+			}
+		})
+	}
+}
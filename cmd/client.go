@@ -0,0 +1,53 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/client"
+	"github.com/genevera/corectl/components/host/session"
+	"github.com/genevera/corectl/release"
+)
+
+// corectld returns a client of the corectld we're set to talk to, having
+// made sure that it is up and speaking our API version
+func corectld() (c *client.Client, srv *release.Info, err error) {
+	opts := client.Options{
+		Socket: session.Caller.ControlSocket(),
+		Meta:   session.Caller.Meta,
+		ImageSources: viperStringSliceBugWorkaround(
+			session.Caller.CmdLine.GetStringSlice("image-source")),
+	}
+	if session.Caller.RemoteServer() {
+		host, _, _ := net.SplitHostPort(session.Caller.ServerAddress)
+		opts.Address = session.Caller.ServerAddress
+		if opts.TLS, err = client.TLSConfig(session.Caller.ClientTLSDir(),
+			host); err != nil {
+			return
+		}
+	}
+	if c, err = client.New(opts); err != nil {
+		return
+	}
+	if srv, err = c.Ping(context.Background()); err != nil &&
+		api.ErrorCode(err) == "" {
+		err = session.ErrServerUnreachable
+	}
+	return
+}
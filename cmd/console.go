@@ -0,0 +1,127 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/client"
+	"github.com/genevera/corectl/components/host/session"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// consoleEscape (Ctrl-]) detaches from the console
+	consoleEscape = 0x1d
+	ctrlC         = 0x03
+)
+
+var (
+	consoleCmd = &cobra.Command{
+		Use:   "console VMid",
+		Short: "Attaches to a running CoreOS instance's serial console",
+		Long: "Attaches to a running CoreOS instance's serial console, " +
+			"through corectld (so that it also works remotely).\n" +
+			"Any number of clients may be attached at once. Ctrl-] detaches.",
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) != 1 {
+				return fmt.Errorf("Incorrect usage: " +
+					"This command requires one argument (a VM)")
+			}
+			session.Caller.CmdLine.BindPFlags(cmd.Flags())
+			return
+		},
+		RunE: consoleCommand,
+		Example: `  corectl console VMid
+  corectl console --read-only VMid`,
+	}
+)
+
+func consoleCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c        *client.Client
+		vm       *api.VMInfo
+		conn     io.ReadWriteCloser
+		state    *terminal.State
+		ctx      = context.Background()
+		readOnly = session.Caller.CmdLine.GetBool("read-only")
+		fd       = int(os.Stdin.Fd())
+		gone     = make(chan struct{})
+	)
+
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if vm, err = c.VM(ctx, args[0]); err != nil {
+		return
+	}
+	if conn, err = c.Console(ctx, vm, readOnly); err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if terminal.IsTerminal(fd) {
+		if state, err = terminal.MakeRaw(fd); err != nil {
+			return
+		}
+		defer terminal.Restore(fd, state)
+	}
+	fmt.Fprintf(os.Stderr, "attached to '%v' console (Ctrl-] detaches)\r\n",
+		vm.Name)
+
+	go func() {
+		io.Copy(os.Stdout, conn)
+		close(gone)
+	}()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			in := buf[:n]
+			if i := bytes.IndexByte(in, consoleEscape); i >= 0 {
+				in, err = in[:i], io.EOF
+			} else if readOnly && bytes.IndexByte(in, ctrlC) >= 0 {
+				err = io.EOF
+			}
+			if len(in) > 0 && !readOnly {
+				if _, werr := conn.Write(in); werr != nil {
+					err = werr
+				}
+			}
+			if err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+	<-gone
+	fmt.Fprintf(os.Stderr, "\r\ndetached from '%v' console\r\n", vm.Name)
+	return nil
+}
+
+func init() {
+	consoleCmd.Flags().BoolP("read-only", "r", false,
+		"only watches the console, ignoring whatever gets typed")
+	if session.AppName() != "corectld" {
+		rootCmd.AddCommand(consoleCmd)
+	}
+}
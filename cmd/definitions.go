@@ -0,0 +1,172 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/client"
+	"github.com/genevera/corectl/components/host/session"
+	"github.com/genevera/corectl/components/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	createCmd = &cobra.Command{
+		Use:   "create VMname",
+		Short: "Stores the definition of a CoreOS instance for later use",
+		Long: "Stores the definition of a CoreOS instance for later use.\n" +
+			"The VM can then be booted, as many times as needed, with " +
+			"'start', always with\nthe same UUID, MAC address and volumes.",
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) != 1 {
+				return fmt.Errorf("Incorrect usage: " +
+					"This command requires one argument (the VM's name)")
+			}
+			session.Caller.CmdLine.BindPFlags(cmd.Flags())
+			session.Caller.CmdLine.Set("name", args[0])
+			return
+		},
+		RunE: createCommand,
+		Example: `  corectl create foo --channel stable --memory 2048 ` +
+			`--volume foo.img.qcow2
+  corectl start foo`,
+	}
+	startCmd = &cobra.Command{
+		Use:   "start VMname [VMname...]",
+		Short: "Boots previously created CoreOS instance(s)",
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) < 1 {
+				return fmt.Errorf("Incorrect usage: This command requires " +
+					"at least one argument (a VM's name)")
+			}
+			return
+		},
+		RunE: startCommand,
+	}
+	rmVMCmd = &cobra.Command{
+		Use:   "rm VMname [VMname...]",
+		Short: "Removes the definition(s) of previously created CoreOS instance(s)",
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) < 1 {
+				return fmt.Errorf("Incorrect usage: This command requires " +
+					"at least one argument (a VM's name)")
+			}
+			session.Caller.CmdLine.BindPFlags(cmd.Flags())
+			return
+		},
+		RunE: rmVMCommand,
+	}
+)
+
+func createCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		vm      *server.VMInfo
+		created *api.VMInfo
+		c       *client.Client
+		cli     = session.Caller.CmdLine
+	)
+
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if vm, err = vmBootstrap(c, cli); err != nil {
+		return
+	}
+	if created, err = c.CreateVM(context.Background(), &vm.VMInfo,
+		cli.GetBool("force")); err != nil {
+		return
+	}
+	log.Info("'%v' (%v) created", created.Name, created.UUID)
+	return
+}
+
+func startCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c   *client.Client
+		ctx = context.Background()
+	)
+
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+
+	for _, name := range args {
+		var (
+			vm      *server.VMInfo
+			defined api.VMmap
+		)
+		if defined, err = c.DefinedVMs(ctx, name); err != nil {
+			return
+		}
+		vm = &server.VMInfo{VMInfo: *defined[name]}
+
+		// the image we were created with may have been removed meanwhile
+		if vm.Version, err = c.Pull(ctx, vm.Target, vm.Channel, vm.Version,
+			false, true); err != nil {
+			return
+		}
+		if err = vm.SSHkeyGen(); err != nil {
+			return fmt.Errorf("Aborting: unable to generate internal SSH "+
+				"key pair (!) (%v)", err)
+		}
+		if err = bootIt(c, vm); err != nil {
+			return
+		}
+		// root volume is only to be formatted on the very first boot
+		if vm.FormatRoot {
+			vm.FormatRoot = false
+			if _, err = c.CreateVM(ctx, &vm.VMInfo, true); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func rmVMCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c       *client.Client
+		removed []string
+	)
+
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if removed, err = c.RemoveVM(context.Background(),
+		session.Caller.CmdLine.GetBool("force"), args...); err != nil {
+		return
+	}
+	for _, name := range removed {
+		log.Info("'%v' removed", name)
+	}
+	return
+}
+
+func init() {
+	runFlagsDefaults(createCmd.Flags())
+	createCmd.Flags().MarkHidden("name")
+	createCmd.Flags().BoolP("force", "f", false,
+		"overwrites an already existing definition with the same name")
+	rmVMCmd.Flags().BoolP("force", "f", false,
+		"removes the definition even if the VM is still running")
+	if session.AppName() != "corectld" {
+		rootCmd.AddCommand(createCmd, startCmd, rmVMCmd)
+	}
+}
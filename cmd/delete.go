@@ -16,19 +16,21 @@
 package main
 
 import (
+	"context"
+
+	"github.com/blang/semver"
 	"github.com/deis/pkg/log"
 
+	"github.com/genevera/corectl/components/client"
 	"github.com/genevera/corectl/components/host/session"
-	"github.com/genevera/corectl/components/server"
-	"github.com/genevera/corectl/components/target/coreos"
+	"github.com/genevera/corectl/components/target"
 	"github.com/spf13/cobra"
 )
 
 var (
 	rmCmd = &cobra.Command{
-		Use:     "rm",
-		Aliases: []string{"rmi"},
-		Short:   "Remove(s) CoreOS image(s) from the local filesystem",
+		Use:     "rmi",
+		Short:   "Remove(s) image(s) from the local filesystem",
 		PreRunE: defaultPreRunE,
 		RunE:    rmCommand,
 	}
@@ -37,29 +39,42 @@ var (
 func rmCommand(cmd *cobra.Command, args []string) (err error) {
 	var (
 		cli     = session.Caller.CmdLine
-		channel = coreos.Channel(cli.GetString("channel"))
-		version = coreos.Version(cli.GetString("version"))
-		reply   = &server.RPCreply{}
+		version = target.Version(cli.GetString("version"))
+		channel string
+		t       target.Target
+		c       *client.Client
+		local   map[string]semver.Versions
+		force   = cli.GetBool("force")
+		ctx     = context.Background()
 	)
-	if _, err = server.Daemon.Running(); err != nil {
-		return session.ErrServerUnreachable
+	if t, err = target.Get(cli.GetString("target")); err != nil {
+		return
+	}
+	channel = target.Channel(t, cli.GetString("channel"))
+	if c, _, err = corectld(); err != nil {
+		return
 	}
 
-	if reply, err = server.RPCQuery("AvailableImages", &server.RPCquery{}); err != nil {
+	if local, err = c.Images(ctx, t.Name()); err != nil {
 		return
 	}
-	local := reply.Images
 
 	l := local[channel]
-	if cli.GetBool("old") {
+	if cli.GetBool("purge") {
+		if l.Len() < 2 {
+			log.Warn("nothing to purge")
+			return
+		}
 		for _, v := range l[0 : l.Len()-1] {
-			if _, err = server.RPCQuery("RemoveImage", &server.RPCquery{
-				Input: []string{channel, v.String()}}); err != nil {
-				return
+			if _, err = c.RemoveImage(ctx, t.Name(), channel,
+				v.String(), force); err != nil {
+				// pinned or in use
+				log.Warn("%s/%s kept: %v", channel, v.String(), err)
+				continue
 			}
 			log.Info("removed %s/%s", channel, v.String())
 		}
-		return
+		return nil
 	}
 
 	if version == "latest" {
@@ -71,8 +86,8 @@ func rmCommand(cmd *cobra.Command, args []string) (err error) {
 		}
 	}
 
-	if _, err = server.RPCQuery("RemoveImage", &server.RPCquery{
-		Input: []string{channel, version}}); err != nil {
+	if _, err = c.RemoveImage(ctx, t.Name(), channel, version,
+		force); err != nil {
 		return
 	}
 
@@ -82,9 +97,12 @@ func rmCommand(cmd *cobra.Command, args []string) (err error) {
 }
 
 func init() {
-	rmCmd.Flags().StringP("channel", "c", "alpha", "CoreOS channel")
-	rmCmd.Flags().StringP("version", "v", "latest", "CoreOS version")
+	targetFlag(rmCmd)
+	rmCmd.Flags().StringP("channel", "c", "alpha", "release channel")
+	rmCmd.Flags().StringP("version", "v", "latest", "image version")
 	rmCmd.Flags().BoolP("purge", "p", false, "purges outdated images")
+	rmCmd.Flags().BoolP("force", "f", false,
+		"removes images even if pinned, or if running VMs booted from them")
 	if session.AppName() != "corectld" {
 		rootCmd.AddCommand(rmCmd)
 	}
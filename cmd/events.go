@@ -0,0 +1,84 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/client"
+	"github.com/genevera/corectl/components/host/session"
+	"github.com/spf13/cobra"
+)
+
+var (
+	eventsCmd = &cobra.Command{
+		Use:   "events",
+		Short: "Streams, as they happen, the lifecycle events of CoreOS instances",
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) != 0 {
+				return fmt.Errorf("Incorrect usage: " +
+					"This command accepts no arguments")
+			}
+			session.Caller.CmdLine.BindPFlags(cmd.Flags())
+			return
+		},
+		RunE: eventsCommand,
+		Example: `  corectl events
+  corectl events --vm foo --vm bar --since 1h
+  corectl events --since 2016-10-01T12:00:00Z --json`,
+	}
+)
+
+func eventsCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c   *client.Client
+		cli = session.Caller.CmdLine
+	)
+
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	return c.Events(context.Background(), cli.GetString("since"),
+		cli.GetStringSlice("vm"), func(e *api.Event) (err error) {
+			var buf []byte
+			if cli.GetBool("json") {
+				if buf, err = json.Marshal(e); err == nil {
+					fmt.Println(string(buf))
+				}
+				return
+			}
+			fmt.Printf("%s %-16s %-16s %s %s\n",
+				e.Time.Format(time.RFC3339), e.Type, e.VM, e.UUID, e.Detail)
+			return
+		})
+}
+
+func init() {
+	eventsCmd.Flags().StringSlice("vm", nil,
+		"only show events of the given VM (name or UUID), may be repeated")
+	eventsCmd.Flags().String("since", "",
+		"also show past events, since the given timestamp (RFC3339) "+
+			"or for the given duration (i.e. 10m)")
+	eventsCmd.Flags().BoolP("json", "j", false,
+		"outputs each event as a JSON object (one per line)")
+	if session.AppName() != "corectld" {
+		rootCmd.AddCommand(eventsCmd)
+	}
+}
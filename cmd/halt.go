@@ -16,10 +16,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/client"
 	"github.com/genevera/corectl/components/host/session"
-	"github.com/genevera/corectl/components/server"
 	"github.com/spf13/cobra"
 )
 
@@ -46,16 +48,25 @@ var (
 )
 
 func killCommand(cmd *cobra.Command, args []string) (err error) {
-	var in server.RPCquery
+	var (
+		c      *client.Client
+		killed []string
+	)
 
-	if _, err = server.Daemon.Running(); err != nil {
-		return session.ErrServerUnreachable
+	if c, _, err = corectld(); err != nil {
+		return
 	}
 
-	if !session.Caller.CmdLine.GetBool("all") {
-		in.Input = args
+	if session.Caller.CmdLine.GetBool("all") {
+		args = nil
+	}
+	if _, killed, err = c.Stop(context.Background(), args...); err != nil {
+		return
+	}
+	if len(killed) > 0 {
+		log.Warn("%v didn't power off in time, so had to be hard killed",
+			killed)
 	}
-	_, err = server.RPCQuery("StopVMs", &in)
 	return
 }
 
@@ -0,0 +1,515 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/deis/pkg/log"
+	"github.com/dustin/go-humanize"
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/client"
+	"github.com/genevera/corectl/components/host/session"
+	"github.com/genevera/corectl/components/target"
+	"github.com/spf13/cobra"
+)
+
+var (
+	imageCmd = &cobra.Command{
+		Use:   "image",
+		Short: "Manages the images available locally",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.UsageFunc()(cmd)
+		},
+	}
+	imageExportCmd = &cobra.Command{
+		Use:   "export channel/version",
+		Short: "Exports an image, as a self-contained bundle",
+		Long: "Exports an image, as a self-contained bundle (a tarball), " +
+			"that can be imported into hosts without network access.",
+		PreRunE: oneImageArg,
+		RunE:    imageExportCommand,
+		Example: `  corectl image export stable/1235.9.0 -o stable.tar`,
+	}
+	imageImportCmd = &cobra.Command{
+		Use:   "import [bundle.tar]",
+		Short: "Imports an image, without network access",
+		Long: "Imports an image bundle, as made by 'corectl image export', " +
+			"checking it against its signed DIGESTS. Alternatively, with " +
+			"--kernel and --initrd, imports a locally built image into a " +
+			"custom channel, where it is marked as unverified.",
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			cli := session.Caller.CmdLine
+			local := cli.GetString("kernel") != "" ||
+				cli.GetString("initrd") != ""
+			if local && len(args) != 0 || !local && len(args) != 1 {
+				return fmt.Errorf("Incorrect usage: " +
+					"This command requires either one argument (a " +
+					"bundle) or both --kernel and --initrd")
+			}
+			return
+		},
+		RunE: imageImportCommand,
+		Example: `  corectl image import stable.tar
+  corectl image import --kernel vmlinuz --initrd initrd.cpio.gz -c dev`,
+	}
+	imagePruneCmd = &cobra.Command{
+		Use:   "prune",
+		Short: "Removes the images a retention policy doesn't keep",
+		Long: "Removes the images a retention policy (corectld's own, " +
+			"unless one is given) doesn't keep. Whatever the policy, the " +
+			"latest image of each channel, pinned images and the ones " +
+			"running VMs booted from are kept.",
+		PreRunE: defaultPreRunE,
+		RunE:    imagePruneCommand,
+		Example: `  corectl image prune --keep 2 --max-age 720h
+  corectl image prune --max-disk 4096 --dry-run`,
+	}
+	imageInspectCmd = &cobra.Command{
+		Use:   "inspect channel/version",
+		Short: "Shows an image's manifest",
+		Long: "Shows an image's manifest: where it came from, who signed " +
+			"it, its artifacts' hashes and upstream release info.",
+		PreRunE: oneImageArg,
+		RunE:    imageInspectCommand,
+	}
+	imageVerifyCmd = &cobra.Command{
+		Use:   "verify [channel[/version]]",
+		Short: "Checks, offline, that images weren't corrupted",
+		Long: "Re-hashes, offline, the artifacts of all images (or just " +
+			"the given channel's, or image) checking them against their " +
+			"manifests and signed DIGESTS.",
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) > 1 {
+				return fmt.Errorf("Incorrect usage: " +
+					"This command accepts at most one argument")
+			}
+			return
+		},
+		RunE: imageVerifyCommand,
+	}
+	imagePinCmd = &cobra.Command{
+		Use:     "pin channel/version",
+		Short:   "Keeps an image from being pruned or removed",
+		PreRunE: oneImageArg,
+		RunE:    imagePinCommand,
+	}
+	imageUnpinCmd = &cobra.Command{
+		Use:     "unpin channel/version",
+		Short:   "Lets a pinned image be pruned or removed again",
+		PreRunE: oneImageArg,
+		RunE:    imagePinCommand,
+	}
+)
+
+func oneImageArg(cmd *cobra.Command, args []string) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("Incorrect usage: " +
+			"This command requires one argument (an image)")
+	}
+	return
+}
+
+// resolveImage splits channel/version, the version being resolved against
+// the local images if 'latest'
+func resolveImage(ctx context.Context, c *client.Client, t target.Target,
+	arg string) (channel, version string, err error) {
+	var local map[string]semver.Versions
+
+	parts := strings.Split(arg, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		err = fmt.Errorf("'%s' isn't a channel/version", arg)
+		return
+	}
+	if channel, version = parts[0], parts[1]; version != "latest" {
+		return
+	}
+	if local, err = c.Images(ctx, t.Name()); err != nil {
+		return
+	}
+	if local[channel].Len() == 0 {
+		err = fmt.Errorf("no %s images available locally", channel)
+		return
+	}
+	version = local[channel][local[channel].Len()-1].String()
+	return
+}
+
+func imageExportCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c                *client.Client
+		t                target.Target
+		f                *os.File
+		channel, version string
+		cli              = session.Caller.CmdLine
+		ctx              = context.Background()
+	)
+	if t, err = target.Get(cli.GetString("target")); err != nil {
+		return
+	}
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if channel, version, err = resolveImage(ctx, c, t,
+		args[0]); err != nil {
+		return
+	}
+	out := cli.GetString("output")
+	if out == "" {
+		out = fmt.Sprintf("%s-%s-%s.tar", t.Name(), channel, version)
+	}
+	if out == "-" {
+		return c.Export(ctx, t.Name(), channel, version, os.Stdout)
+	}
+	if f, err = os.Create(out); err != nil {
+		return
+	}
+	if err = c.Export(ctx, t.Name(), channel, version, f); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(out)
+		return
+	}
+	log.Info("%s/%s exported into %s", channel, version, out)
+	return
+}
+
+func imageImportCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c      *client.Client
+		m      *api.ImageManifest
+		bundle io.ReadCloser
+		cli    = session.Caller.CmdLine
+	)
+	if len(args) == 1 {
+		if bundle, err = os.Open(args[0]); err != nil {
+			return
+		}
+	} else if bundle, err = localBundle(cli.GetString("target"),
+		cli.GetString("channel"), cli.GetString("version"),
+		cli.GetString("kernel"), cli.GetString("initrd")); err != nil {
+		return
+	}
+	defer bundle.Close()
+
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if m, err = c.Import(context.Background(), bundle,
+		cli.GetBool("force")); err != nil {
+		return
+	}
+	if m.Verified {
+		log.Info("%s %s/%s imported", m.Target, m.Channel, m.Version)
+	} else {
+		log.Warn("%s %s/%s imported, unverified", m.Target, m.Channel,
+			m.Version)
+	}
+	return
+}
+
+func imageInspectCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c                *client.Client
+		t                target.Target
+		reply            *api.InspectImageReply
+		pp               []byte
+		channel, version string
+		cli              = session.Caller.CmdLine
+		ctx              = context.Background()
+	)
+	if t, err = target.Get(cli.GetString("target")); err != nil {
+		return
+	}
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if channel, version, err = resolveImage(ctx, c, t,
+		args[0]); err != nil {
+		return
+	}
+	if reply, err = c.InspectImage(ctx, t.Name(), channel,
+		version); err != nil {
+		return
+	}
+	if reply.Manifest == nil {
+		log.Warn("%s/%s predates image manifests. Please re-pull it "+
+			"(with --force) to get one", channel, version)
+	}
+	if pp, err = json.MarshalIndent(reply, "", "    "); err != nil {
+		return
+	}
+	fmt.Println(string(pp))
+	return
+}
+
+func imageVerifyCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c                *client.Client
+		t                target.Target
+		reply            *api.VerifyImagesReply
+		channel, version string
+		cli              = session.Caller.CmdLine
+		ctx              = context.Background()
+	)
+	if t, err = target.Get(cli.GetString("target")); err != nil {
+		return
+	}
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if len(args) == 1 {
+		if !strings.Contains(args[0], "/") {
+			channel = args[0]
+		} else if channel, version, err = resolveImage(ctx, c, t,
+			args[0]); err != nil {
+			return
+		}
+	}
+	if reply, err = c.VerifyImages(ctx, t.Name(), channel,
+		version); err != nil {
+		return
+	}
+	for _, i := range reply.Checked {
+		if len(reply.Problems[i]) == 0 {
+			fmt.Printf("%s: OK\n", i)
+			continue
+		}
+		fmt.Printf("%s: FAILED\n", i)
+		for _, p := range reply.Problems[i] {
+			fmt.Println("  -", p)
+		}
+	}
+	if len(reply.Problems) > 0 {
+		return fmt.Errorf("%d of %d images failed verification",
+			len(reply.Problems), len(reply.Checked))
+	}
+	return
+}
+
+func imagePruneCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c      *client.Client
+		t      target.Target
+		reply  *api.PruneImagesReply
+		policy *api.RetentionPolicy
+		cli    = session.Caller.CmdLine
+	)
+	if t, err = target.Get(cli.GetString("target")); err != nil {
+		return
+	}
+	for _, f := range []string{"keep", "max-age", "max-disk"} {
+		if cmd.Flags().Changed(f) {
+			policy = &api.RetentionPolicy{
+				Keep:    cli.GetInt("keep"),
+				MaxAge:  cli.GetDuration("max-age"),
+				MaxDisk: cli.GetInt64("max-disk"),
+			}
+			break
+		}
+	}
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if reply, err = c.PruneImages(context.Background(), t.Name(), policy,
+		cli.GetBool("dry-run")); err != nil {
+		return
+	}
+	if len(reply.Removed) == 0 {
+		log.Info("nothing to prune")
+		return
+	}
+	verb := "removed"
+	if cli.GetBool("dry-run") {
+		verb = "would remove"
+	}
+	for _, i := range reply.Removed {
+		fmt.Println(verb, t.Name(), i)
+	}
+	fmt.Printf("(%s freed)\n", humanize.Bytes(uint64(reply.Freed)))
+	return
+}
+
+func imagePinCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c                *client.Client
+		t                target.Target
+		channel, version string
+		cli              = session.Caller.CmdLine
+		ctx              = context.Background()
+	)
+	if t, err = target.Get(cli.GetString("target")); err != nil {
+		return
+	}
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if channel, version, err = resolveImage(ctx, c, t,
+		args[0]); err != nil {
+		return
+	}
+	return c.PinImage(ctx, t.Name(), channel, version, cmd.Name() == "pin")
+}
+
+// localBundle streams an image bundle made out of a locally built kernel
+// and initrd, destined to a custom channel
+func localBundle(name, channel, version,
+	kernel, initrd string) (bundle io.ReadCloser, err error) {
+	var (
+		t     target.Target
+		sum   string
+		files = []string{kernel, initrd}
+		m     = &api.ImageManifest{Channel: channel, Version: version,
+			Files: make(map[string]string), Created: time.Now()}
+	)
+	if kernel == "" || initrd == "" {
+		return nil, fmt.Errorf("both --kernel and --initrd are needed")
+	}
+	if t, err = target.Get(name); err != nil {
+		return
+	}
+	if target.HasChannel(t, channel) {
+		return nil, fmt.Errorf("locally built images can't go into %s's "+
+			"%s channel. Please pick a custom one", t.Name(), channel)
+	}
+	if m.Version == "" {
+		m.Version = "0.0.0-" + m.Created.UTC().Format("20060102150405")
+	}
+	m.Target = t.Name()
+	names := make([]string, 2)
+	names[0], names[1], _ = t.Artifacts()
+	for i, f := range files {
+		if sum, err = sha512file(f); err != nil {
+			return
+		}
+		m.Files[names[i]] = sum
+	}
+	if err = m.Validate(); err != nil {
+		return
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		var err error
+		tw := tar.NewWriter(w)
+		defer func() {
+			if err == nil {
+				err = tw.Close()
+			}
+			w.CloseWithError(err)
+		}()
+		raw, _ := json.MarshalIndent(m, "", "  ")
+		if err = tw.WriteHeader(&tar.Header{Name: api.ManifestFile,
+			Mode: 0644, Size: int64(len(raw)), ModTime: m.Created,
+			Typeflag: tar.TypeReg}); err != nil {
+			return
+		}
+		if _, err = tw.Write(raw); err != nil {
+			return
+		}
+		for i, f := range files {
+			if err = tarAs(tw, f, names[i]); err != nil {
+				return
+			}
+		}
+	}()
+	return r, nil
+}
+
+func tarAs(tw *tar.Writer, file, name string) (err error) {
+	var (
+		f  *os.File
+		fi os.FileInfo
+	)
+	if f, err = os.Open(file); err != nil {
+		return
+	}
+	defer f.Close()
+	if fi, err = f.Stat(); err != nil {
+		return
+	}
+	if err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644,
+		Size: fi.Size(), ModTime: fi.ModTime(),
+		Typeflag: tar.TypeReg}); err != nil {
+		return
+	}
+	_, err = io.Copy(tw, f)
+	return
+}
+
+func sha512file(file string) (sum string, err error) {
+	var f *os.File
+
+	if f, err = os.Open(file); err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha512.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func init() {
+	targetFlag(imageExportCmd)
+	imageExportCmd.Flags().StringP("output", "o", "",
+		"where to write the bundle ('-' for stdout), by default "+
+			"<target>-<channel>-<version>.tar")
+	targetFlag(imageImportCmd)
+	imageImportCmd.Flags().String("kernel", "",
+		"locally built kernel to import")
+	imageImportCmd.Flags().String("initrd", "",
+		"locally built initrd to import")
+	imageImportCmd.Flags().StringP("channel", "c", "dev",
+		"custom channel to import locally built images into")
+	imageImportCmd.Flags().StringP("version", "v", "",
+		"version of the locally built image, by default 0.0.0-<timestamp>")
+	imageImportCmd.Flags().BoolP("force", "f", false,
+		"replaces the image, if already local")
+	targetFlag(imagePruneCmd)
+	imagePruneCmd.Flags().Int("keep", 0,
+		"how many images to keep per channel (0 means no limit)")
+	imagePruneCmd.Flags().Duration("max-age", 0,
+		"how old images may get (0 means no limit)")
+	imagePruneCmd.Flags().Int64("max-disk", 0,
+		"how much disk (in MB) the target's images may add up to (0 "+
+			"means no limit)")
+	imagePruneCmd.Flags().Bool("dry-run", false,
+		"only tells which images would be removed")
+	targetFlag(imageInspectCmd)
+	targetFlag(imageVerifyCmd)
+	targetFlag(imagePinCmd)
+	targetFlag(imageUnpinCmd)
+	imageCmd.AddCommand(imageExportCmd, imageImportCmd, imageInspectCmd,
+		imageVerifyCmd, imagePruneCmd, imagePinCmd, imageUnpinCmd)
+	if session.AppName() != "corectld" {
+		rootCmd.AddCommand(imageCmd)
+	}
+}
@@ -16,12 +16,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 
+	"github.com/blang/semver"
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/client"
 	"github.com/genevera/corectl/components/host/session"
-	"github.com/genevera/corectl/components/server"
-	"github.com/genevera/corectl/components/target/coreos"
+	"github.com/genevera/corectl/components/target"
 	"github.com/spf13/cobra"
 )
 
@@ -29,32 +33,48 @@ var (
 	lsCmd = &cobra.Command{
 		Use:     "ls",
 		Aliases: []string{"list"},
-		Short:   "Lists the CoreOS images available locally",
+		Short:   "Lists the images available locally",
 		PreRunE: defaultPreRunE,
 		RunE:    lsCommand,
 	}
 )
 
 func lsCommand(cmd *cobra.Command, args []string) (err error) {
-	if _, err = server.Daemon.Running(); err != nil {
-		return session.ErrServerUnreachable
+	var (
+		c       *client.Client
+		t       target.Target
+		local   map[string]semver.Versions
+		details *api.ImagesReply
+		cli     = session.Caller.CmdLine
+	)
+	if t, err = target.Get(cli.GetString("target")); err != nil {
+		return
+	}
+	if c, _, err = corectld(); err != nil {
+		return
 	}
 
-	reply := &server.RPCreply{}
-	if reply, err = server.RPCQuery("AvailableImages", &server.RPCquery{}); err != nil {
+	if details, err = c.ImageDetails(context.Background(),
+		t.Name()); err != nil {
 		return
 	}
-	local := reply.Images
-	cli := session.Caller.CmdLine
-	channels := []string{coreos.Channel(cli.GetString("channel"))}
+	local = details.Images
+	channels := []string{target.Channel(t, cli.GetString("channel"))}
 	if cli.GetBool("all") {
-		channels = coreos.Channels
+		channels = t.Channels()
+		custom := []string{}
+		for channel := range local {
+			if !target.HasChannel(t, channel) {
+				custom = append(custom, channel)
+			}
+		}
+		sort.Strings(custom)
+		channels = append(channels, custom...)
 	}
 	if cli.GetBool("json") {
 		var pp []byte
 		if len(channels) == 1 {
-			if pp, err = json.MarshalIndent(
-				local[coreos.Channel(cli.GetString("channel"))],
+			if pp, err = json.MarshalIndent(local[channels[0]],
 				"", "    "); err != nil {
 				return
 			}
@@ -66,7 +86,7 @@ func lsCommand(cmd *cobra.Command, args []string) (err error) {
 		fmt.Println(string(pp))
 		return
 	}
-	fmt.Println("locally available images")
+	fmt.Printf("locally available %s images\n", t.Name())
 	for _, i := range channels {
 		var header bool
 		for _, d := range local[i] {
@@ -74,14 +94,26 @@ func lsCommand(cmd *cobra.Command, args []string) (err error) {
 				fmt.Printf("  - %s channel \n", i)
 				header = true
 			}
-			fmt.Println("    -", d.String())
+			fmt.Print("    - ", d.String())
+			for _, u := range details.Unverified {
+				if u == i+"/"+d.String() {
+					fmt.Print(" (unverified)")
+				}
+			}
+			for _, p := range details.Pinned {
+				if p == i+"/"+d.String() {
+					fmt.Print(" (pinned)")
+				}
+			}
+			fmt.Println()
 		}
 	}
 	return
 }
 
 func init() {
-	lsCmd.Flags().StringP("channel", "c", "alpha", "CoreOS channel")
+	targetFlag(lsCmd)
+	lsCmd.Flags().StringP("channel", "c", "alpha", "release channel")
 	lsCmd.Flags().BoolP("all", "a", false, "browses all channels")
 	lsCmd.Flags().BoolP("json", "j", false,
 		"outputs in JSON for easy 3rd party integration")
@@ -23,10 +23,12 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/deis/pkg/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
+	"github.com/genevera/corectl/components/client"
 	"github.com/genevera/corectl/components/host/session"
 	"github.com/genevera/corectl/components/server"
 )
@@ -36,8 +38,10 @@ var (
 		Use:   "load path/to/yourProfile",
 		Short: "Loads CoreOS instances defined in an instrumentation file.",
 		Long: "Loads CoreOS instances defined in an instrumentation file " +
-			"(either in TOML, JSON or YAML format).\n" + "VMs are always launched " +
-			"by alphabetical order relative to their names.",
+			"(either in TOML, JSON or YAML format).\n" + "VMs are launched " +
+			"by alphabetical order relative to their names, up to '--parallel' " +
+			"at a time, except for those that list others in 'depends_on', " +
+			"which only get launched after these have phoned home.",
 		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
 			if len(args) != 1 {
 				return fmt.Errorf("Incorrect usage: " +
@@ -46,23 +50,45 @@ var (
 			session.Caller.CmdLine.BindPFlags(cmd.Flags())
 			return
 		},
-		RunE:    loadCommand,
-		Example: `  corectl load profiles/demo.toml`,
+		RunE: loadCommand,
+		Example: `  corectl load profiles/demo.toml
+  corectl load --parallel 3 profiles/demo.toml`,
 	}
 )
 
 func loadCommand(cmd *cobra.Command, args []string) (err error) {
 	var (
-		vmDefs  = make(map[string]*viper.Viper)
-		ordered []string
-		f       []byte
-		def     = args[0]
-		setup   = viper.New()
+		vmDefs   map[string]*viper.Viper
+		ordered  []string
+		c        *client.Client
+		deps     map[string][]string
+		parallel = session.Caller.CmdLine.GetInt("parallel")
 	)
 
-	if _, err = server.Daemon.Running(); err != nil {
-		return session.ErrServerUnreachable
+	if parallel < 1 {
+		return fmt.Errorf("'--parallel' must be at least 1")
 	}
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if vmDefs, ordered, err = readProfile(args[0]); err != nil {
+		return
+	}
+	if deps, err = dependencies(vmDefs, ordered); err != nil {
+		return
+	}
+	return bootAll(c, vmDefs, ordered, deps, parallel)
+}
+
+// readProfile parses the given instrumentation file, returning each VM's
+// settings (the file's top level ones plus its own) along with all VMs'
+// names, alphabeticaly ordered
+func readProfile(def string) (vmDefs map[string]*viper.Viper,
+	ordered []string, err error) {
+	var (
+		f     []byte
+		setup = viper.New()
+	)
 
 	if f, err = ioutil.ReadFile(def); err != nil {
 		return
@@ -76,13 +102,15 @@ func loadCommand(cmd *cobra.Command, args []string) (err error) {
 		strings.HasSuffix(def, ".yml") {
 		setup.SetConfigType("yaml")
 	} else {
-		return fmt.Errorf("%s unable to guess format via suffix", def)
+		err = fmt.Errorf("%s unable to guess format via suffix", def)
+		return
 	}
 
 	if err = setup.ReadConfig(bytes.NewBuffer(f)); err != nil {
 		return
 	}
 
+	vmDefs = make(map[string]*viper.Viper)
 	for name, def := range setup.AllSettings() {
 		if reflect.ValueOf(def).Kind() == reflect.Map {
 			lf := pflag.NewFlagSet(name, 0)
@@ -91,7 +119,7 @@ func loadCommand(cmd *cobra.Command, args []string) (err error) {
 			vmDefs[name].BindPFlags(lf)
 
 			for x, xx := range setup.AllSettings() {
-				if reflect.ValueOf(x).Kind() != reflect.Map {
+				if reflect.ValueOf(xx).Kind() != reflect.Map {
 					vmDefs[name].Set(x, xx)
 				}
 			}
@@ -106,22 +134,133 @@ func loadCommand(cmd *cobra.Command, args []string) (err error) {
 		ordered = append(ordered, name)
 	}
 	sort.Strings(ordered)
-	for slot, name := range ordered {
-		var vm *server.VMInfo
+	return
+}
 
-		fmt.Printf("> booting %s (%v/%v)\n", name, slot+1, len(ordered))
-		if vm, err = vmBootstrap(vmDefs[name]); err != nil {
+// dependencies gathers, out of each VM's 'depends_on', which VMs need to be
+// up before it, refusing unknown VMs and dependency cycles
+func dependencies(vmDefs map[string]*viper.Viper,
+	ordered []string) (deps map[string][]string, err error) {
+	var (
+		visit func(name string, path []string) error
+		state = make(map[string]int)
+	)
+	deps = make(map[string][]string)
+	for _, name := range ordered {
+		for _, d := range viperStringSliceBugWorkaround(
+			vmDefs[name].GetStringSlice("depends_on")) {
+			if d = strings.ToLower(strings.TrimSpace(d)); d == "" {
+				continue
+			}
+			if _, ok := vmDefs[d]; !ok {
+				return nil, fmt.Errorf("'%s' depends on '%s', which isn't "+
+					"defined in the profile", name, d)
+			}
+			deps[name] = append(deps[name], d)
+		}
+	}
+	// 0 unvisited, 1 being visited, 2 done
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("dependency cycle (%s)",
+				strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, d := range deps[name] {
+			if err := visit(d, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		return nil
+	}
+	for _, name := range ordered {
+		if err = visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// bootAll boots, by alphabetical order and up to parallel at a time, the
+// given VMs whose dependencies are already up (dependencies not among them
+// are assumed to be). On failure nothing else gets launched, but the boots
+// already ongoing are waited for.
+func bootAll(c *client.Client, vmDefs map[string]*viper.Viper,
+	ordered []string, deps map[string][]string, parallel int) (err error) {
+	return bootInOrder(ordered, deps, parallel, func(name string) (err error) {
+		var vm *server.VMInfo
+		if vm, err = vmBootstrap(c, vmDefs[name]); err != nil {
 			return
 		}
-		if err = bootIt(vm); err != nil {
+		return bootIt(c, vm)
+	})
+}
+
+// bootInOrder is bootAll's scheduling, booting each VM through boot
+func bootInOrder(ordered []string, deps map[string][]string, parallel int,
+	boot func(name string) error) (err error) {
+	type booted struct {
+		name string
+		err  error
+	}
+	var (
+		ongoing int
+		up      = make(map[string]bool)
+		started = make(map[string]bool)
+		results = make(chan booted)
+	)
+	for _, name := range ordered {
+		up[name] = false
+	}
+	ready := func(name string) bool {
+		for _, d := range deps[name] {
+			if isUp, ok := up[d]; ok && !isUp {
+				return false
+			}
+		}
+		return true
+	}
+	for {
+		for _, name := range ordered {
+			if err != nil || ongoing >= parallel {
+				break
+			}
+			if started[name] || !ready(name) {
+				continue
+			}
+			started[name] = true
+			ongoing++
+			fmt.Printf("> booting %s (%v/%v)\n", name, len(started),
+				len(ordered))
+			go func(name string) {
+				results <- booted{name, boot(name)}
+			}(name)
+		}
+		if ongoing == 0 {
 			return
 		}
+		r := <-results
+		ongoing--
+		if r.err != nil {
+			if err == nil {
+				err = fmt.Errorf("unable to boot '%s' (%v)", r.name, r.err)
+			} else {
+				log.Err("unable to boot '%s' (%v)", r.name, r.err)
+			}
+			continue
+		}
+		up[r.name] = true
 	}
-	return
 }
 
 func init() {
 	if session.AppName() != "corectld" {
+		loadFCmd.Flags().IntP("parallel", "P", 1,
+			"how many VMs may be booting at the same time")
 		rootCmd.AddCommand(loadFCmd)
 	}
 }
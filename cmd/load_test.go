@@ -0,0 +1,173 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// profile builds the VM definitions readProfile would, each VM given by
+// its name and what it depends on
+func profile(vms map[string][]string) (vmDefs map[string]*viper.Viper,
+	ordered []string) {
+	vmDefs = make(map[string]*viper.Viper)
+	for name, deps := range vms {
+		vmDefs[name] = viper.New()
+		vmDefs[name].Set("depends_on", deps)
+	}
+	return vmDefs, sortedKeys(vms)
+}
+
+func sortedKeys(vms map[string][]string) (keys []string) {
+	for k := range vms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+func TestDependencies(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		vms  map[string][]string
+		deps map[string][]string
+		err  string
+	}{
+		{
+			name: "none",
+			vms:  map[string][]string{"a": nil, "b": nil},
+			deps: map[string][]string{},
+		},
+		{
+			name: "chain",
+			vms:  map[string][]string{"a": nil, "b": {"a"}, "c": {"B "}},
+			deps: map[string][]string{"b": {"a"}, "c": {"b"}},
+		},
+		{
+			name: "unknown",
+			vms:  map[string][]string{"a": {"nope"}},
+			err:  "'a' depends on 'nope', which isn't defined",
+		},
+		{
+			name: "cycle",
+			vms:  map[string][]string{"a": {"c"}, "b": {"a"}, "c": {"b"}},
+			err:  "dependency cycle (a -> c -> b -> a)",
+		},
+		{
+			name: "self",
+			vms:  map[string][]string{"a": {"a"}},
+			err:  "dependency cycle (a -> a)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			vmDefs, ordered := profile(tc.vms)
+			deps, err := dependencies(vmDefs, ordered)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got %v, want an error with %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(deps, tc.deps) {
+				t.Errorf("got %v, want %v", deps, tc.deps)
+			}
+		})
+	}
+}
+
+func TestBootInOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		vms      map[string][]string
+		parallel int
+		failing  string
+		booted   []string
+		err      string
+	}{
+		{
+			name:     "dependencies first",
+			vms:      map[string][]string{"a": {"c"}, "b": nil, "c": {"b"}},
+			parallel: 1,
+			booted:   []string{"b", "c", "a"},
+		},
+		{
+			name:     "failed dependency stops dependents",
+			vms:      map[string][]string{"a": nil, "b": {"a"}, "c": {"b"}},
+			parallel: 1,
+			failing:  "a",
+			booted:   []string{"a"},
+			err:      "unable to boot 'a'",
+		},
+		{
+			name: "failed dependency stops dependents, in parallel",
+			vms: map[string][]string{"a": nil, "b": {"a"},
+				"c": {"b"}, "d": {"a"}},
+			parallel: 4,
+			failing:  "a",
+			booted:   []string{"a"},
+			err:      "unable to boot 'a'",
+		},
+		{
+			name:     "dependencies outside the profile are assumed up",
+			vms:      map[string][]string{"a": {"elsewhere"}},
+			parallel: 1,
+			booted:   []string{"a"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				booted []string
+				deps   = make(map[string][]string)
+			)
+			for name, d := range tc.vms {
+				if len(d) > 0 {
+					deps[name] = d
+				}
+			}
+			err := bootInOrder(sortedKeys(tc.vms), deps, tc.parallel,
+				func(name string) error {
+					mu.Lock()
+					booted = append(booted, name)
+					mu.Unlock()
+					if name == tc.failing {
+						return fmt.Errorf("oops")
+					}
+					return nil
+				})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got %v, want an error with %q", err, tc.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(booted, tc.booted) {
+				t.Errorf("booted %v, want %v", booted, tc.booted)
+			}
+		})
+	}
+}
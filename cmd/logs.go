@@ -0,0 +1,73 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/genevera/corectl/components/client"
+	"github.com/genevera/corectl/components/host/session"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsCmd = &cobra.Command{
+		Use:   "logs VMid",
+		Short: "Shows the serial log of a CoreOS instance",
+		Long: "Shows the serial log of a CoreOS instance, running or gone " +
+			"(corectld keeps the logs of the later for a while).",
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) != 1 {
+				return fmt.Errorf("Incorrect usage: " +
+					"This command requires one argument (a VM)")
+			}
+			session.Caller.CmdLine.BindPFlags(cmd.Flags())
+			return
+		},
+		RunE: logsCommand,
+		Example: `  corectl logs VMid
+  corectl logs -f --tail 20 VMid
+  corectl logs --since 10m VMid`,
+	}
+)
+
+func logsCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c   *client.Client
+		cli = session.Caller.CmdLine
+	)
+
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	return c.Logs(context.Background(), args[0], cli.GetBool("follow"),
+		cli.GetInt("tail"), cli.GetString("since"), os.Stdout)
+}
+
+func init() {
+	logsCmd.Flags().BoolP("follow", "f", false,
+		"keeps streaming the log, as it grows, until the VM is gone")
+	logsCmd.Flags().Int("tail", 0,
+		"only shows the last given lines (all if 0)")
+	logsCmd.Flags().String("since", "",
+		"only shows what got logged since the given timestamp (RFC3339) "+
+			"or for the given duration (i.e. 10m)")
+	if session.AppName() != "corectld" {
+		rootCmd.AddCommand(logsCmd)
+	}
+}
@@ -17,11 +17,13 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"os/user"
 	"strings"
 
 	"github.com/genevera/corectl/components/host/session"
+	"github.com/genevera/corectl/components/server"
 	"github.com/genevera/corectl/release"
 	"github.com/deis/pkg/log"
 	"github.com/spf13/cobra"
@@ -51,8 +53,14 @@ var (
 func init() {
 	if session.AppName() != "corectld" {
 		rootCmd.PersistentFlags().StringP("server", "s", "127.0.0.1",
-			"corectld location")
-		rootCmd.PersistentFlags().MarkHidden("server")
+			"corectld location (remote ones are reached over mutual TLS)")
+		rootCmd.PersistentFlags().String("tls-dir", "",
+			"where to find the client certificate, key and CA with which "+
+				"to reach a remote corectld (defaults to ~/.coreos/tls)")
+		rootCmd.PersistentFlags().StringSlice("image-source", nil,
+			"where to pull images from, as '[target/][channel=]URL' "+
+				"(http(s):// or file://), tried in order before corectld's "+
+				"own sources")
 	}
 	rootCmd.PersistentPreRunE =
 		func(cmd *cobra.Command, args []string) (err error) {
@@ -122,8 +130,8 @@ func main() {
 	}
 	session.Caller.CmdLine.BindPFlags(rootCmd.PersistentFlags())
 	if session.AppName() != "corectld" {
-		session.Caller.ServerAddress =
-			session.Caller.CmdLine.GetString("server") + ":2511"
+		session.Caller.ServerAddress = net.JoinHostPort(
+			session.Caller.CmdLine.GetString("server"), server.RemotePort)
 	}
 	if err = rootCmd.Execute(); err != nil {
 		log.Err(err.Error())
@@ -16,10 +16,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/genevera/corectl/components/client"
 	"github.com/genevera/corectl/components/host/session"
-	"github.com/genevera/corectl/components/server"
 	"github.com/deis/pkg/log"
 	"github.com/spf13/cobra"
 )
@@ -51,24 +52,23 @@ var (
 
 func panicCommand(cmd *cobra.Command, args []string) (err error) {
 	var (
-		in     server.RPCquery
-		out    *server.RPCreply
-		random = session.Caller.CmdLine.GetBool("random")
+		c       *client.Client
+		stopped []string
+		random  = session.Caller.CmdLine.GetBool("random")
 	)
 
-	if _, err = server.Daemon.Running(); err != nil {
-		return session.ErrServerUnreachable
+	if c, _, err = corectld(); err != nil {
+		return
 	}
 
-	if !random {
-		in.Input = args
+	if random {
+		args = nil
 	}
-	in.Forced = true
-	if out, err = server.RPCQuery("StopVMs", &in); err != nil {
+	if stopped, err = c.Kill(context.Background(), args...); err != nil {
 		return
 	}
 	if random {
-		log.Info("'%v' gone", out.Output[0])
+		log.Info("'%v' gone", stopped[0])
 	}
 	return
 }
@@ -0,0 +1,82 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/client"
+	"github.com/genevera/corectl/components/host/session"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pauseCmd = &cobra.Command{
+		Use:   "pause VMids",
+		Short: "Suspends one or more running CoreOS instances",
+		Long: "Suspends one or more running CoreOS instances, freeing the " +
+			"host's CPU until they get resumed. Meanwhile they're kept out " +
+			"of the DNS.",
+		PreRunE: vmArgs,
+		RunE:    pauseCommand,
+	}
+	resumeCmd = &cobra.Command{
+		Use:     "resume VMids",
+		Short:   "Resumes one or more paused CoreOS instances",
+		PreRunE: vmArgs,
+		RunE:    pauseCommand,
+	}
+)
+
+func vmArgs(cmd *cobra.Command, args []string) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("Incorrect usage: " +
+			"This command requires at least one argument (a VM)")
+	}
+	session.Caller.CmdLine.BindPFlags(cmd.Flags())
+	return
+}
+
+func pauseCommand(cmd *cobra.Command, args []string) (err error) {
+	var (
+		c    *client.Client
+		done []string
+		ctx  = context.Background()
+	)
+
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	what := "paused"
+	if cmd.Name() == "resume" {
+		what = "resumed"
+		done, err = c.Resume(ctx, args...)
+	} else {
+		done, err = c.Pause(ctx, args...)
+	}
+	for _, name := range done {
+		log.Info("%v '%v'", what, name)
+	}
+	return
+}
+
+func init() {
+	if session.AppName() != "corectld" {
+		rootCmd.AddCommand(pauseCmd, resumeCmd)
+	}
+}
@@ -16,11 +16,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"text/tabwriter"
 
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/client"
 	"github.com/genevera/corectl/components/host/session"
 	"github.com/genevera/corectl/components/server"
 	"github.com/genevera/corectl/release"
@@ -58,18 +61,18 @@ var (
 
 func psCommand(cmd *cobra.Command, args []string) (err error) {
 	var (
-		cli   = session.Caller.CmdLine
-		reply = &server.RPCreply{}
-		srv   *release.Info
-		pp    []byte
+		cli     = session.Caller.CmdLine
+		c       *client.Client
+		running api.VMmap
+		srv     *release.Info
+		pp      []byte
 	)
-	if srv, err = server.Daemon.Running(); err != nil {
+	if c, srv, err = corectld(); err != nil {
 		return
 	}
-	if reply, err = server.RPCQuery("ActiveVMs", &server.RPCquery{}); err != nil {
+	if running, err = c.ListVMs(context.Background()); err != nil {
 		return
 	}
-	running := reply.Running
 	if cli.GetBool("json") {
 		if pp, err = json.MarshalIndent(running, "", "    "); err == nil {
 			fmt.Println(string(pp))
@@ -96,37 +99,43 @@ func psCommand(cmd *cobra.Command, args []string) (err error) {
 func queryCommand(cmd *cobra.Command, args []string) (err error) {
 	var (
 		pp       []byte
-		reply    = &server.RPCreply{}
+		c        *client.Client
+		running  api.VMmap
+		ctx      = context.Background()
 		cli      = session.Caller.CmdLine
-		selected map[string]*server.VMInfo
-		vm       *server.VMInfo
-		tabP     = func(selected map[string]*server.VMInfo) {
+		selected api.VMmap
+		vm       *api.VMInfo
+		tabP     = func(selected api.VMmap) {
 			w := new(tabwriter.Writer)
 			w.Init(os.Stdout, 5, 0, 1, ' ', 0)
-			fmt.Fprintf(w, "name\tchannel/version\tip\tonline\tcpu(s)\tram\t"+
-				"uuid\tpid\tuptime\tvols\n")
+			fmt.Fprintf(w, "name\tchannel/version\tip\tonline\tpaused\t"+
+				"cpu(s)\tram\tuuid\tpid\tuptime\tvols\trestarts\tlast exit\n")
 			for _, vm := range selected {
-				fmt.Fprintf(w, "%v\t%v/%v\t%v\t%t\t%v\t%v\t%v\t%v\t%v\t%v\n",
+				fmt.Fprintf(w, "%v\t%v/%v\t%v\t%t\t%t\t%v\t%v\t%v\t%v\t%v"+
+					"\t%v\t%v\t%v\n",
 					vm.Name, vm.Channel, vm.Version, vm.PublicIP,
-					vm.NotIsolated, vm.Cpus, vm.Memory, vm.UUID, vm.Pid,
-					humanize.Time(vm.CreationTime), len(vm.Storage.HardDrives))
+					vm.NotIsolated, vm.Paused, vm.Cpus, vm.Memory, vm.UUID, vm.Pid,
+					humanize.Time(vm.CreationTime), len(vm.Storage.HardDrives),
+					vm.Restarts, vm.LastExit)
 			}
 			w.Flush()
 		}
 	)
 
-	if _, err = server.Daemon.Running(); err != nil {
-		return session.ErrServerUnreachable
+	if c, _, err = corectld(); err != nil {
+		return
 	}
 
-	if reply, err =
-		server.RPCQuery("ActiveVMs", &server.RPCquery{}); err != nil {
+	if running, err = c.ListVMs(ctx); err != nil {
 		return
 	}
-	running := reply.Running
+
+	if cli.GetBool("defined") {
+		return definedP(c, running, args)
+	}
 
 	if len(args) == 1 {
-		if vm, err = vmInfo(args[0]); err != nil {
+		if vm, err = c.VM(ctx, args[0]); err != nil {
 			if cli.GetBool("up") {
 				fmt.Println(false)
 				return nil
@@ -140,10 +149,10 @@ func queryCommand(cmd *cobra.Command, args []string) (err error) {
 			fmt.Println(vm.UUID)
 			return
 		} else if cli.GetBool("tty") {
-			fmt.Println(vm.TTY())
+			fmt.Println((&server.VMInfo{VMInfo: *vm}).TTY())
 			return
 		} else if cli.GetBool("log") {
-			fmt.Println(vm.Log())
+			fmt.Println((&server.VMInfo{VMInfo: *vm}).Log())
 			return
 		} else if cli.GetBool("online") {
 			fmt.Println(vm.NotIsolated)
@@ -157,9 +166,9 @@ func queryCommand(cmd *cobra.Command, args []string) (err error) {
 	if len(args) == 0 {
 		selected = running
 	} else {
-		selected = make(map[string]*server.VMInfo)
+		selected = make(api.VMmap)
 		for _, target := range args {
-			if vm, err = vmInfo(target); err != nil {
+			if vm, err = c.VM(ctx, target); err != nil {
 				return
 			}
 			selected[vm.UUID] = vm
@@ -180,6 +189,36 @@ func queryCommand(cmd *cobra.Command, args []string) (err error) {
 	return
 }
 
+// definedP displays the stored VM definitions, and whether they are running
+func definedP(c *client.Client, running api.VMmap,
+	names []string) (err error) {
+	var (
+		pp      []byte
+		defined api.VMmap
+	)
+	if defined, err = c.DefinedVMs(context.Background(),
+		names...); err != nil {
+		return
+	}
+	if session.Caller.CmdLine.GetBool("json") {
+		if pp, err = json.MarshalIndent(defined, "", "    "); err == nil {
+			fmt.Println(string(pp))
+		}
+		return
+	}
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 5, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "name\tchannel/version\tcpu(s)\tram\tuuid\tvols\trunning\n")
+	for _, vm := range defined {
+		_, up := running[vm.UUID]
+		fmt.Fprintf(w, "%v\t%v/%v\t%v\t%v\t%v\t%v\t%t\n",
+			vm.Name, vm.Channel, vm.Version, vm.Cpus, vm.Memory, vm.UUID,
+			len(vm.Storage.HardDrives), up)
+	}
+	w.Flush()
+	return
+}
+
 func init() {
 	psCmd.Flags().BoolP("json", "j", false,
 		"outputs in JSON for easy 3rd party integration")
@@ -201,6 +240,8 @@ func init() {
 		"tells if a given VM is up or not")
 	queryCmd.Flags().BoolP("uuid", "U", false,
 		"returns VM's UUID")
+	queryCmd.Flags().BoolP("defined", "D", false,
+		"display the stored VM definitions (see 'create')")
 	if session.AppName() != "corectld" {
 		rootCmd.AddCommand(psCmd, queryCmd)
 	}
@@ -16,9 +16,13 @@
 package main
 
 import (
+	"context"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/genevera/corectl/components/client"
 	"github.com/genevera/corectl/components/host/session"
-	"github.com/genevera/corectl/components/server"
-	"github.com/genevera/corectl/components/target/coreos"
+	"github.com/genevera/corectl/components/target"
 	"github.com/spf13/cobra"
 )
 
@@ -26,7 +30,7 @@ var (
 	pullCmd = &cobra.Command{
 		Use:     "pull",
 		Aliases: []string{"get", "fetch"},
-		Short:   "Pulls a CoreOS image from upstream",
+		Short:   "Pulls an image from upstream",
 		PreRunE: defaultPreRunE,
 		RunE:    pullCommand,
 	}
@@ -34,46 +38,76 @@ var (
 
 func pullCommand(cmd *cobra.Command, args []string) (err error) {
 	var (
-		reply = &server.RPCreply{}
-		cli   = session.Caller.CmdLine
-		force = cli.GetBool("force")
+		c       *client.Client
+		t       target.Target
+		local   map[string]semver.Versions
+		channel string
+		cli     = session.Caller.CmdLine
+		force   = cli.GetBool("force")
+		ctx     = context.Background()
 	)
 
-	if _, err = server.Daemon.Running(); err != nil {
-		return session.ErrServerUnreachable
+	if t, err = target.Get(cli.GetString("target")); err != nil {
+		return
+	}
+	if c, _, err = corectld(); err != nil {
+		return
 	}
 
 	if cli.GetBool("warmup") {
-		reply, err = server.RPCQuery("AvailableImages", &server.RPCquery{})
-		if err != nil {
+		if local, err = c.Images(ctx, t.Name()); err != nil {
 			return
 		}
-		local := reply.Images
-		for _, channel := range coreos.Channels {
+		for _, channel := range t.Channels() {
 			if local[channel].Len() > 0 {
-				if _, err =
-					server.PullImage(channel, coreos.Version("latest"),
-						force, false); err != nil {
+				if _, err = c.Pull(ctx, t.Name(), channel, "latest",
+					force, false); err != nil {
 					return
 				}
 			}
 		}
-		_, err = server.RPCQuery("AvailableImages", &server.RPCquery{})
 		return
 	}
-	if _, err =
-		server.PullImage(coreos.Channel(cli.GetString("channel")),
-			coreos.Version(cli.GetString("version")),
-			force, false); err != nil {
+	if channel, err = upstreamChannel(ctx, c, t,
+		cli.GetString("channel")); err != nil {
 		return
 	}
-	_, err = server.RPCQuery("AvailableImages", &server.RPCquery{})
+	_, err = c.Pull(ctx, t.Name(), channel,
+		target.Version(cli.GetString("version")), force, false)
 	return
 }
 
+// upstreamChannel resolves the channel of an image that may have to be
+// fetched from upstream, only taking custom channels corectld already has
+// images in
+func upstreamChannel(ctx context.Context, c *client.Client,
+	t target.Target, name string) (channel string, err error) {
+	var (
+		local    map[string]semver.Versions
+		existing []string
+	)
+	if !target.HasChannel(t, name) {
+		if local, err = c.Images(ctx, t.Name()); err != nil {
+			return
+		}
+		for ch := range local {
+			existing = append(existing, ch)
+		}
+	}
+	return target.ExistingChannel(t, name, existing), nil
+}
+
+// targetFlag adds to the given command the flag selecting which OS
+// distribution it acts upon
+func targetFlag(cmd *cobra.Command) {
+	cmd.Flags().String("target", target.Default, "OS distribution, one of "+
+		strings.Join(target.Names(), ", "))
+}
+
 func init() {
-	pullCmd.Flags().StringP("channel", "c", "alpha", "CoreOS channel")
-	pullCmd.Flags().StringP("version", "v", "latest", "CoreOS version")
+	targetFlag(pullCmd)
+	pullCmd.Flags().StringP("channel", "c", "alpha", "release channel")
+	pullCmd.Flags().StringP("version", "v", "latest", "image version")
 	pullCmd.Flags().BoolP("force", "f", false,
 		"forces the rebuild of an image, if already local")
 	pullCmd.Flags().BoolP("warmup", "w", false,
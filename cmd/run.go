@@ -16,14 +16,17 @@
 package main
 
 import (
-	"encoding/binary"
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
-	"syscall"
 
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/client"
 	"github.com/genevera/corectl/components/host/session"
 	"github.com/genevera/corectl/components/server"
-	"github.com/genevera/corectl/components/target/coreos"
+	"github.com/genevera/corectl/components/target"
 	"github.com/deis/pkg/log"
 	"github.com/satori/go.uuid"
 	"github.com/spf13/cobra"
@@ -33,53 +36,48 @@ import (
 
 var (
 	runCmd = &cobra.Command{
-		Use:     "run",
-		Aliases: []string{"start"},
-		Short:   "Boots a new CoreOS instance",
-		RunE:    runCommand,
+		Use:   "run",
+		Short: "Boots a new CoreOS instance",
+		RunE:  runCommand,
 	}
 )
 
 func runCommand(cmd *cobra.Command, args []string) (err error) {
 	var (
 		vm  *server.VMInfo
+		c   *client.Client
 		cli = session.Caller.CmdLine
 	)
 
-	if _, err = server.Daemon.Running(); err != nil {
-		return session.ErrServerUnreachable
+	if c, _, err = corectld(); err != nil {
+		return
 	}
-	if vm, err = vmBootstrap(cli); err != nil {
+	if vm, err = vmBootstrap(c, cli); err != nil {
 		return
 	}
-	return bootIt(vm)
+	return bootIt(c, vm)
 }
 
-func bootIt(vm *server.VMInfo) (err error) {
-	var reply = &server.RPCreply{}
-	log.Info("'%v' boot logs can be found at '%v'", vm.Name, vm.Log())
-	if reply, err =
-		server.RPCQuery("Run", &server.RPCquery{VM: vm}); err != nil {
+func bootIt(c *client.Client, vm *server.VMInfo) (err error) {
+	var booted *api.VMInfo
+	log.Info("follow '%v' boot logs with 'corectl logs -f %v'", vm.Name,
+		vm.Name)
+	if booted, err = c.Run(context.Background(), &vm.VMInfo); err != nil {
 		return
 	}
 	log.Info("'%v' started successfuly with address %v and PID %v",
-		reply.VM.Name, reply.VM.PublicIP, reply.VM.Pid)
-	log.Info("'%v' console can be found at '%v'", reply.VM.Name, reply.VM.TTY())
+		booted.Name, booted.PublicIP, booted.Pid)
+	log.Info("attach to '%v' console with 'corectl console %v'",
+		booted.Name, booted.Name)
 	return
 }
 
-func vmBootstrap(args *viper.Viper) (vm *server.VMInfo, err error) {
+func vmBootstrap(c *client.Client,
+	args *viper.Viper) (vm *server.VMInfo, err error) {
 	var (
-		reply           = &server.RPCreply{}
-		HostPhysicalMem uint64
-		// only thing we need from github.com shirou/gopsutil
-		getHwMemsize = func() (v uint64, err error) {
-			var tStr string
-			if tStr, err = syscall.Sysctl("hw.memsize"); err == nil {
-				v = uint64(binary.LittleEndian.Uint64([]byte(tStr + "\x00")))
-			}
-			return
-		}
+		nfs bool
+		t   target.Target
+		ctx = context.Background()
 	)
 	vm = new(server.VMInfo)
 
@@ -87,6 +85,16 @@ func vmBootstrap(args *viper.Viper) (vm *server.VMInfo, err error) {
 	vm.Cpus = args.GetInt("cpus")
 	vm.AddToHypervisor = args.GetString("extra")
 	vm.AddToKernel = args.GetString("boot")
+	vm.Hypervisor = args.GetString("hypervisor")
+	vm.Restart = args.GetString("restart")
+	vm.MaxRestarts = args.GetInt("max-restarts")
+	vm.StopTimeout = args.GetInt("stop-timeout")
+	for _, d := range viperStringSliceBugWorkaround(
+		args.GetStringSlice("depends_on")) {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			vm.DependsOn = append(vm.DependsOn, d)
+		}
+	}
 	vm.SSHkey = args.GetString("sshkey")
 	vm.Pid = -1
 
@@ -94,37 +102,6 @@ func vmBootstrap(args *viper.Viper) (vm *server.VMInfo, err error) {
 	vm.UUID = args.GetString("uuid")
 
 	vm.Memory = args.GetInt("memory")
-	if vm.Memory < 1024 {
-		log.Warn("'%v' not a reasonable memory value. %s\n", vm.Memory,
-			"Using '1024', the default")
-		vm.Memory = 1024
-	} else if vm.Memory > 8192 {
-		log.Warn("'%v' not a reasonable memory value, as presently "+
-			"we only support VMs with up to 8GB of RAM. setting "+
-			"it to '8192'", vm.Memory)
-		vm.Memory = 8192
-	}
-
-	if reply, err =
-		server.RPCQuery("ActiveVMs", &server.RPCquery{}); err != nil {
-		return
-	}
-
-	totalM := 0
-	for _, v := range reply.Running {
-		totalM = totalM + v.Memory
-	}
-
-	if HostPhysicalMem, err = getHwMemsize(); err != nil {
-		return
-	}
-
-	if uint64(totalM+vm.Memory) > HostPhysicalMem/1024/1024*2/3 {
-		err = fmt.Errorf("Aborted. Aggregated VMs memory usage would " +
-			"become higher than 66pc of total host physical memory which " +
-			"would lead to system unstability")
-		return
-	}
 
 	if vm.UUID == "random" {
 		vm.UUID = uuid.NewV4().String()
@@ -136,20 +113,17 @@ func vmBootstrap(args *viper.Viper) (vm *server.VMInfo, err error) {
 	}
 	vm.UUID = strings.ToUpper(vm.UUID)
 
-	reply, err = server.RPCQuery("UUIDtoMACaddr",
-		&server.RPCquery{Input: []string{vm.UUID, args.GetString("uuid")}})
-	if err != nil {
+	if vm.MacAddress, vm.UUID, err = c.UUIDtoMAC(ctx, vm.UUID,
+		args.GetString("uuid")); err != nil {
 		return
 	}
-	vm.MacAddress, vm.UUID = reply.Output[0], reply.Output[1]
 
 	vm.SharedHomedir = args.GetBool("shared-homedir")
 	if vm.SharedHomedir == true {
-		if reply, err = server.RPCQuery("HandlesNFS",
-			&server.RPCquery{}); err != nil {
+		if nfs, err = c.HandlesNFS(ctx); err != nil {
 			return
 		}
-		if reply.WorkingNFS == false {
+		if nfs == false {
 			log.Warn("NFS is not supported by the running 'corectld'")
 			vm.SharedHomedir = false
 		}
@@ -159,11 +133,29 @@ func vmBootstrap(args *viper.Viper) (vm *server.VMInfo, err error) {
 		vm.Name = vm.UUID
 	}
 
-	vm.Channel = coreos.Channel(args.GetString("channel"))
+	if t, err = target.Get(args.GetString("target")); err != nil {
+		return
+	}
+	vm.Target = t.Name()
+	if vm.Channel, err = upstreamChannel(ctx, c, t,
+		args.GetString("channel")); err != nil {
+		return
+	}
+
+	if overlay := args.GetString("initrd-overlay"); overlay != "" {
+		var fi os.FileInfo
+		if vm.InitrdOverlay, err = filepath.Abs(overlay); err != nil {
+			return
+		}
+		if fi, err = os.Stat(vm.InitrdOverlay); err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("initrd overlay %s isn't a directory",
+				overlay)
+		}
+	}
 
-	vm.Version = coreos.Version(args.GetString("version"))
-	vm.Version, err =
-		server.PullImage(vm.Channel, vm.Version, false, vm.OfflineMode)
+	vm.Version = target.Version(args.GetString("version"))
+	vm.Version, err = c.Pull(ctx, vm.Target, vm.Channel, vm.Version,
+		false, vm.OfflineMode)
 	if err != nil {
 		return
 	}
@@ -187,7 +179,7 @@ func vmBootstrap(args *viper.Viper) (vm *server.VMInfo, err error) {
 	}
 
 	vm.Ethernet =
-		append(vm.Ethernet, server.NetworkInterface{Type: server.Raw})
+		append(vm.Ethernet, api.NetworkInterface{Type: api.Raw})
 
 	err = vm.ValidateCloudConfig(args.GetString("cloud_config"))
 	if err != nil {
@@ -203,11 +195,14 @@ func vmBootstrap(args *viper.Viper) (vm *server.VMInfo, err error) {
 }
 
 func runFlagsDefaults(setFlag *pflag.FlagSet) {
-	setFlag.StringP("channel", "c", "alpha", "CoreOS channel stream")
-	setFlag.StringP("version", "v", "latest", "CoreOS version")
+	setFlag.String("target", target.Default, "OS distribution to run, one "+
+		"of "+strings.Join(target.Names(), ", "))
+	setFlag.StringP("channel", "c", "alpha", "release channel stream")
+	setFlag.StringP("version", "v", "latest", "image version")
 	setFlag.StringP("uuid", "u", "random", "VM's UUID")
-	setFlag.IntP("memory", "m", 1024,
-		"VM's RAM, in MB, per instance (1024 < memory < 8192)")
+	setFlag.IntP("memory", "m", api.MinMemory,
+		fmt.Sprintf("VM's RAM, in MB, per instance (at least %v)",
+			api.MinMemory))
 	setFlag.IntP("cpus", "N", 1, "VM number of virtual CPUs")
 	setFlag.StringP("cloud_config", "L", "",
 		"cloud-config file location (either an URL or a local path)")
@@ -223,8 +218,21 @@ func runFlagsDefaults(setFlag *pflag.FlagSet) {
 		"names the VM (default is VM's UUID)")
 	setFlag.BoolP("shared-homedir", "H", false,
 		"mounts (via NFS) host's homedir inside VM")
+	setFlag.String("hypervisor", "",
+		"hypervisor backend to run VM on (default is corectld's one)")
+	setFlag.String("restart", api.RestartNo,
+		fmt.Sprintf("what to do when VM's runner exits %v",
+			api.RestartPolicies))
+	setFlag.Int("max-restarts", 0,
+		"how many times in a row VM may be restarted (0 means no limit)")
+	setFlag.Int("stop-timeout", 0,
+		"seconds VM is given to power off before being hard killed "+
+			"(default is corectld's one)")
 	setFlag.StringP("extra", "x", "", "additional arguments to the hypervisor")
 	setFlag.StringP("boot", "b", "", "additional arguments to the kernel boot")
+	setFlag.String("initrd-overlay", "",
+		"directory whose files are added to the initrd the VM boots from "+
+			"(i.e. CA certificates, OEM files or debug tools)")
 	// available but hidden...
 	setFlag.StringP("tap", "t", "", "append tap interface to VM")
 	setFlag.MarkHidden("tap")
@@ -16,12 +16,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
-	"github.com/genevera/corectl/components/host/darwin/misc/uuid2ip"
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/client"
+	"github.com/genevera/corectl/components/host/platform"
 	"github.com/genevera/corectl/components/host/session"
 	"github.com/genevera/corectl/components/server"
+	"github.com/genevera/corectl/components/target"
 	"github.com/genevera/corectl/release"
 	"github.com/deis/pkg/log"
 	"github.com/satori/go.uuid"
@@ -47,6 +52,24 @@ var (
 		Short: "Shows corectld status",
 		RunE:  psCommand,
 	}
+	clientCertCmd = &cobra.Command{
+		Use:   "client-cert name",
+		Short: "Issues a client certificate with which to remotely manage corectld",
+		Long: "Issues a client certificate, signed by corectld's own CA, " +
+			"with which to remotely\nmanage corectld. The resulting bundle " +
+			"is to be used with corectl's '--tls-dir'.",
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if len(args) != 1 {
+				return fmt.Errorf("Incorrect usage: " +
+					"This command requires one argument (the client's name)")
+			}
+			session.Caller.CmdLine.BindPFlags(cmd.Flags())
+			return
+		},
+		RunE: clientCertCommand,
+		Example: `  corectld client-cert alice -o alice.tls
+  corectl --server macmini.local --tls-dir alice.tls ps`,
+	}
 	uuidToMacCmd = &cobra.Command{
 		Use: "uuid2mac",
 		Short: "returns the MAC address that will assembled from the " +
@@ -67,20 +90,34 @@ func uuidToMacCommand(cmd *cobra.Command, args []string) (err error) {
 			"generate a MAC address from the provided UUID (%s). Please fill "+
 			"a bug at https://github.com/genevera/corectl/issues with "+
 			"this error and wait there for our feedback...", args[0])
-	} else if macAddr, err = uuid2ip.GuestMACfromUUID(args[0]); err == nil {
+	} else if macAddr, err = platform.Host.GuestMACfromUUID(args[0]); err == nil {
 		fmt.Println(macAddr)
 	}
 	return
 }
 
-func shutdownCommand(cmd *cobra.Command, args []string) (err error) {
-	if _, err = server.Daemon.Running(); err != nil {
+func clientCertCommand(cmd *cobra.Command, args []string) (err error) {
+	var dir = session.Caller.CmdLine.GetString("output")
+
+	if dir == "" {
+		dir = args[0] + ".tls"
+	}
+	if err = server.IssueClientCert(args[0], dir); err != nil {
 		return
 	}
-	_, err = server.RPCQuery("Stop", &server.RPCquery{})
+	log.Info("client certificate for '%v' stored at '%v'", args[0], dir)
 	return
 }
 
+func shutdownCommand(cmd *cobra.Command, args []string) (err error) {
+	var c *client.Client
+
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	return c.Shutdown(context.Background())
+}
+
 func serverStartCommand(cmd *cobra.Command, args []string) (err error) {
 	var (
 		srv    *release.Info
@@ -88,24 +125,43 @@ func serverStartCommand(cmd *cobra.Command, args []string) (err error) {
 		bugfix = viperStringSliceBugWorkaround
 	)
 
-	if srv, err = server.Daemon.Running(); err == nil {
+	if _, srv, err = corectld(); err == nil {
 		return fmt.Errorf("corectld already started (with pid %v)",
 			srv.Pid)
 	}
 
 	if !session.Caller.Privileged {
+		if platform.Host.Name() != "darwin" {
+			return fmt.Errorf("not enough previleges to start server. " +
+				"please use 'sudo'")
+		}
 		if err = mack.Tell("System Events",
 			"do shell script \""+session.Executable()+" start "+
 				" -u "+session.Caller.Username+
 				" -D "+cli.GetString("domain")+
 				" --dns-port "+cli.GetString("dns-port")+
+				" --hypervisor "+cli.GetString("hypervisor")+
+				" --remote="+strconv.FormatBool(cli.GetBool("remote"))+
+				" --remote-port "+cli.GetString("remote-port")+
+				" --memory-overcommit "+cli.GetString("memory-overcommit")+
+				" --cpu-overcommit "+cli.GetString("cpu-overcommit")+
+				" --quota-memory "+cli.GetString("quota-memory")+
+				" --quota-cpus "+cli.GetString("quota-cpus")+
+				" --stop-timeout "+cli.GetString("stop-timeout")+
+				" --log-retention "+cli.GetString("log-retention")+
+				" --image-keep "+cli.GetString("image-keep")+
+				" --image-max-age "+cli.GetString("image-max-age")+
+				" --image-max-disk "+cli.GetString("image-max-disk")+
+				" --image-prune="+strconv.FormatBool(cli.GetBool("image-prune"))+
+				" --image-source='"+strings.Join(bugfix(
+				cli.GetStringSlice("image-source")), ",")+"'"+
 				" -r "+strings.Join(bugfix(
 				cli.GetStringSlice("recursive-nameservers")), ",")+
 				" > /dev/null 2>&1 & \" with administrator privileges",
 			"delay 3"); err != nil {
 			return
 		}
-		if srv, err = server.Daemon.Running(); err != nil {
+		if _, srv, err = corectld(); err != nil {
 			return err
 		}
 		fmt.Println("Started corectld:")
@@ -114,8 +170,33 @@ func serverStartCommand(cmd *cobra.Command, args []string) (err error) {
 	}
 	server.LocalDomainName = cli.GetString("domain")
 	server.EmbeddedDNSport = cli.GetString("dns-port")
+	server.DefaultHypervisor = cli.GetString("hypervisor")
+	server.RemoteAccess = cli.GetBool("remote")
+	server.RemotePort = cli.GetString("remote-port")
+	server.MemoryOvercommit = cli.GetFloat64("memory-overcommit")
+	server.CPUOvercommit = cli.GetFloat64("cpu-overcommit")
+	server.QuotaMemory = cli.GetInt("quota-memory")
+	server.QuotaCPUs = cli.GetInt("quota-cpus")
+	server.DefaultStopTimeout = cli.GetDuration("stop-timeout")
+	server.LogRetention = cli.GetDuration("log-retention")
+	server.ImageRetention = api.RetentionPolicy{
+		Keep:    cli.GetInt("image-keep"),
+		MaxAge:  cli.GetDuration("image-max-age"),
+		MaxDisk: cli.GetInt64("image-max-disk"),
+	}
+	if err = server.ImageRetention.Validate(); err != nil {
+		return
+	}
+	server.PruneAfterPull = cli.GetBool("image-prune")
+	if server.MemoryOvercommit <= 0 || server.CPUOvercommit <= 0 {
+		return fmt.Errorf("overcommit ratios must be positive")
+	}
 	server.RecursiveNameServers =
 		bugfix(cli.GetStringSlice("recursive-nameservers"))
+	if server.ImageSources, err = target.ParseSources(
+		bugfix(cli.GetStringSlice("image-source"))); err != nil {
+		return
+	}
 	server.Daemon = server.New()
 
 	return server.Start()
@@ -132,7 +213,52 @@ func init() {
 				"nameservers to be used by the embedded dns server")
 		serverStartCmd.Flags().String("dns-port", "15353",
 			"embedded dns server port")
+		serverStartCmd.Flags().String("hypervisor", server.DefaultHypervisor,
+			"default hypervisor backend for the VMs, one of "+
+				strings.Join(server.Hypervisors(), ", "))
+		serverStartCmd.Flags().Bool("remote", false,
+			"also accepts remote clients, authenticated with certificates "+
+				"issued by corectld's own CA (see 'client-cert')")
+		serverStartCmd.Flags().String("remote-port", server.RemotePort,
+			"port where remote clients are served")
+		serverStartCmd.Flags().Float64("memory-overcommit",
+			server.MemoryOvercommit, "share of host's physical memory that "+
+				"all VMs, together, may be given")
+		serverStartCmd.Flags().Float64("cpu-overcommit",
+			server.CPUOvercommit, "how many vCPUs, all VMs together, may be "+
+				"given per host CPU")
+		serverStartCmd.Flags().Int("quota-memory", server.QuotaMemory,
+			"how much RAM (in MB) each client's VMs may add up to (0 means "+
+				"no limit)")
+		serverStartCmd.Flags().Int("quota-cpus", server.QuotaCPUs,
+			"how many vCPUs each client's VMs may add up to (0 means no "+
+				"limit)")
+		serverStartCmd.Flags().Duration("stop-timeout",
+			server.DefaultStopTimeout, "how long VMs are given to power off "+
+				"before being hard killed, unless they set their own")
+		serverStartCmd.Flags().Duration("log-retention", server.LogRetention,
+			"how long the serial logs of VMs that are gone are kept around "+
+				"(0 keeps none)")
+		serverStartCmd.Flags().Int("image-keep", 0,
+			"how many images 'corectl image prune' keeps per channel (0 "+
+				"means no limit)")
+		serverStartCmd.Flags().Duration("image-max-age", 0,
+			"how old images may get before 'corectl image prune' removes "+
+				"them (0 means no limit)")
+		serverStartCmd.Flags().Int64("image-max-disk", 0,
+			"how much disk (in MB) each target's images may add up to "+
+				"before 'corectl image prune' removes the oldest (0 means "+
+				"no limit)")
+		serverStartCmd.Flags().Bool("image-prune", false,
+			"also applies the image retention policy after each pull")
+		serverStartCmd.Flags().StringSlice("image-source", nil,
+			"where to pull images from, in fallback order and before the "+
+				"targets' own release servers, as '[target/][channel=]URL' "+
+				"(http(s):// or file://), channel specific ones overriding "+
+				"the others")
+		clientCertCmd.Flags().StringP("output", "o", "",
+			"where to store the client certificate bundle")
 		rootCmd.AddCommand(shutdownCmd, statusCmd,
-			serverStartCmd, uuidToMacCmd)
+			serverStartCmd, uuidToMacCmd, clientCertCmd)
 	}
 }
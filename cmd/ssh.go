@@ -16,11 +16,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"strings"
 
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/client"
 	"github.com/genevera/corectl/components/host/session"
-	"github.com/genevera/corectl/components/server"
 	"github.com/genevera/corectl/components/server/connector"
 	"github.com/spf13/cobra"
 )
@@ -63,15 +66,27 @@ var (
 func sshCommand(cmd *cobra.Command, args []string) (err error) {
 	var (
 		sshSession = &connector.SSHclient{}
-		vm         *server.VMInfo
+		c          *client.Client
+		vm         *api.VMInfo
+		key        string
+		conn       net.Conn
+		ctx        = context.Background()
 	)
 
-	if vm, err = vmInfo(args[0]); err != nil {
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if vm, err = c.VM(ctx, args[0]); err != nil {
+		return
+	}
+	if key, err = c.SSHkey(ctx, vm); err != nil {
+		return
+	}
+	if conn, err = c.DialVM(ctx, vm); err != nil {
 		return
 	}
 
-	sshSession, err =
-		connector.StartSSHsession(vm.PublicIP, vm.InternalSSHprivate)
+	sshSession, err = connector.StartSSHsession(conn, key)
 	if err != nil {
 		return
 	}
@@ -83,36 +98,30 @@ func sshCommand(cmd *cobra.Command, args []string) (err error) {
 	return sshSession.RemoteShell()
 }
 
-func vmInfo(id string) (vm *server.VMInfo, err error) {
-	var reply = &server.RPCreply{}
-	if _, err = server.Daemon.Running(); err != nil {
-		err = session.ErrServerUnreachable
-		return
-	}
-
-	if reply, err =
-		server.RPCQuery("ActiveVMs", &server.RPCquery{}); err != nil {
-		return
-	}
-	running := reply.Running
-	for _, v := range running {
-		if v.Name == id || v.UUID == id {
-			return v, err
-		}
-	}
-	return vm, fmt.Errorf("'%s' not found, or dead", id)
-}
-
 func scpCommand(cmd *cobra.Command, args []string) (err error) {
 	var (
-		session, vm                 = &connector.SSHclient{}, &server.VMInfo{}
+		session                     = &connector.SSHclient{}
+		c                           *client.Client
+		vm                          *api.VMInfo
 		split                       = strings.Split(args[1], ":")
 		source, destination, target = args[0], split[1], split[0]
+		key                         string
+		conn                        net.Conn
+		ctx                         = context.Background()
 	)
-	if vm, err = vmInfo(target); err != nil {
+	if c, _, err = corectld(); err != nil {
+		return
+	}
+	if vm, err = c.VM(ctx, target); err != nil {
+		return
+	}
+	if key, err = c.SSHkey(ctx, vm); err != nil {
+		return
+	}
+	if conn, err = c.DialVM(ctx, vm); err != nil {
 		return
 	}
-	if session, err = connector.StartSSHsession(vm.PublicIP, vm.InternalSSHprivate); err != nil {
+	if session, err = connector.StartSSHsession(conn, key); err != nil {
 		return
 	}
 	defer session.Close()
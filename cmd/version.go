@@ -20,7 +20,6 @@ import (
 	"strings"
 
 	"github.com/genevera/corectl/components/host/session"
-	"github.com/genevera/corectl/components/server"
 	"github.com/genevera/corectl/release"
 	"github.com/blang/semver"
 	"github.com/deis/pkg/log"
@@ -40,7 +39,7 @@ func versionCommand(cmd *cobra.Command, args []string) {
 		err             error
 	)
 	if session.AppName() != "corectld" {
-		if srv, err := server.Daemon.Running(); err == nil {
+		if _, srv, err := corectld(); err == nil {
 			fmt.Println("\nServer:")
 			srv.PrettyPrint(false)
 			fmt.Println("\nClient:")
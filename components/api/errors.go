@@ -0,0 +1,75 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RPC error codes, as seen by clients
+const (
+	ErrCodeInternal        = "INTERNAL"
+	ErrCodeInvalidRequest  = "INVALID_REQUEST"
+	ErrCodeShuttingDown    = "SHUTTING_DOWN"
+	ErrCodeNotFound        = "NOT_FOUND"
+	ErrCodeConflict        = "CONFLICT"
+	ErrCodeVersionMismatch = "VERSION_MISMATCH"
+	ErrCodeNoCapacity      = "INSUFFICIENT_RESOURCES"
+	ErrCodeForbidden       = "FORBIDDEN"
+)
+
+var errorCodes = []string{ErrCodeInternal, ErrCodeInvalidRequest,
+	ErrCodeShuttingDown, ErrCodeNotFound, ErrCodeConflict,
+	ErrCodeVersionMismatch, ErrCodeNoCapacity, ErrCodeForbidden}
+
+// RPCError is how every error returned by corectld's RPC services reaches
+// the client, i.e. as 'CODE: message'
+type RPCError struct {
+	Code, Message string
+}
+
+func (e *RPCError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Errorf returns a new RPCError with the given code
+func Errorf(code string, format string, a ...interface{}) error {
+	return &RPCError{code, fmt.Sprintf(format, a...)}
+}
+
+// Invalid is the error returned on malformed requests
+func Invalid(format string, a ...interface{}) error {
+	return Errorf(ErrCodeInvalidRequest, format, a...)
+}
+
+// ErrorCode returns the RPC error code of the given error, if any
+func ErrorCode(err error) string {
+	if e, ok := err.(*RPCError); ok {
+		return e.Code
+	}
+	return ""
+}
+
+// ParseError rebuilds, client side, the RPCError sent by the server
+func ParseError(msg string) error {
+	for _, c := range errorCodes {
+		if strings.HasPrefix(msg, c+": ") {
+			return &RPCError{c, strings.TrimPrefix(msg, c+": ")}
+		}
+	}
+	return fmt.Errorf("%s", msg)
+}
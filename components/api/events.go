@@ -0,0 +1,45 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package api
+
+import "time"
+
+// VM lifecycle events
+const (
+	EventRegistered     = "registered"
+	EventStarted        = "started"
+	EventIgnitionServed = "ignition-served"
+	EventPhoneHome      = "phone-home"
+	EventNotIsolated    = "not-isolated"
+	EventReattached     = "reattached"
+	EventPaused         = "paused"
+	EventResumed        = "resumed"
+	EventStopping       = "stopping"
+	EventKilled         = "killed"
+	EventExited         = "exited"
+	EventRestarting     = "restarting"
+	EventDeregistered   = "deregistered"
+)
+
+// Event ...
+type Event struct {
+	Time     time.Time
+	Type     string
+	VM, UUID string
+	Detail   string `json:",omitempty"`
+	// Owner is whom the VM belongs to
+	Owner string `json:",omitempty"`
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package api
+
+import "time"
+
+// ManifestFile is where, within an image's directory (and bundles), its
+// manifest is kept
+const ManifestFile = "manifest.json"
+
+// ImageManifest describes a locally available image
+type ImageManifest struct {
+	Target, Channel, Version string
+	// Files maps each of the image's artifacts into its SHA512 hash
+	Files map[string]string
+	// Verified tells whether the image was checked against its target's
+	// signed DIGESTS, which unverified ones (i.e. locally built) lack
+	Verified bool
+	// KeyID is the one of the key the DIGESTS were signed with
+	KeyID string `json:",omitempty"`
+	// Source is where the image was fetched from
+	Source string `json:",omitempty"`
+	// Release holds the fields of the upstream version.txt
+	Release map[string]string `json:",omitempty"`
+	// Created is when the image got (first) fetched, or built
+	Created time.Time
+}
+
+// Validate ...
+func (m *ImageManifest) Validate() error {
+	if err := ValidImage(m.Target, m.Channel, m.Version); err != nil {
+		return err
+	}
+	if len(m.Files) == 0 {
+		return Invalid("image manifest lists no files")
+	}
+	return nil
+}
+
+// RetentionPolicy bounds which images are kept around. Whatever it says, the
+// latest image of each channel, pinned images and the ones running VMs
+// booted from are never removed.
+type RetentionPolicy struct {
+	// Keep is how many images are kept per channel (0 meaning no limit)
+	Keep int
+	// MaxAge is how old images may get (0 meaning no limit)
+	MaxAge time.Duration
+	// MaxDisk is how much disk (in MB) each target's images may add up to
+	// (0 meaning no limit), the oldest ones being removed first
+	MaxDisk int64
+}
+
+// IsZero tells whether the policy keeps everything
+func (p *RetentionPolicy) IsZero() bool {
+	return p.Keep == 0 && p.MaxAge == 0 && p.MaxDisk == 0
+}
+
+// Validate ...
+func (p *RetentionPolicy) Validate() error {
+	if p.Keep < 0 || p.MaxAge < 0 || p.MaxDisk < 0 {
+		return Invalid("image retention limits can't be negative")
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package api
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/genevera/corectl/components/target"
+	"github.com/genevera/corectl/release"
+	"github.com/satori/go.uuid"
+)
+
+// APIVersion is the revision of the RPC contract spoken between corectl and
+// corectld. It is to be bumped on every incompatible change to the types
+// bellow.
+const APIVersion = 1
+
+type (
+	// Args is implemented by all RPC arguments, which corectld validates
+	// before acting upon
+	Args interface {
+		Validate() error
+	}
+
+	// NoArgs is what's sent to methods that take no input
+	NoArgs struct{}
+
+	// EchoArgs ...
+	EchoArgs struct {
+		APIVersion int
+		Client     *release.Info
+	}
+	// EchoReply ...
+	EchoReply struct {
+		APIVersion int
+		Meta       *release.Info
+	}
+
+	// HandlesNFSReply ...
+	HandlesNFSReply struct {
+		WorkingNFS bool
+	}
+
+	// ImagesArgs selects whose target's images are listed (the default
+	// one's, if unset)
+	ImagesArgs struct {
+		Target string `json:",omitempty"`
+	}
+	// ImagesReply ...
+	ImagesReply struct {
+		Images map[string]semver.Versions
+		// Unverified lists, as channel/version, the images that weren't
+		// checked against their target's signed DIGESTS
+		Unverified []string `json:",omitempty"`
+		// Pinned lists, as channel/version, the images kept from pruning
+		Pinned []string `json:",omitempty"`
+	}
+	// RemoveImageArgs asks corectld to remove the given image. Force does
+	// so even if pinned, or if running VMs booted from it.
+	RemoveImageArgs struct {
+		Target           string `json:",omitempty"`
+		Channel, Version string
+		Force            bool
+	}
+	// ImageArgs names a locally available image
+	ImageArgs struct {
+		Target           string `json:",omitempty"`
+		Channel, Version string
+	}
+	// InspectImageReply ...
+	InspectImageReply struct {
+		// Manifest is nil for images predating manifests
+		Manifest *ImageManifest
+		Pinned   bool
+		// InUse lists the running VMs that booted from the image
+		InUse []string
+		// Size is how much disk (in bytes) the image takes
+		Size int64
+	}
+	// VerifyImagesArgs selects which of the target's images are checked:
+	// all of them, unless given a Channel (and a Version)
+	VerifyImagesArgs struct {
+		Target           string `json:",omitempty"`
+		Channel, Version string
+	}
+	// VerifyImagesReply lists, as channel/version, the images checked and,
+	// for those that failed, why
+	VerifyImagesReply struct {
+		Checked  []string
+		Problems map[string][]string
+	}
+	// PinImageArgs pins (or, unless Pin, unpins) the given image
+	PinImageArgs struct {
+		Target           string `json:",omitempty"`
+		Channel, Version string
+		Pin              bool
+	}
+	// PruneImagesArgs asks corectld to apply the given retention policy
+	// (its own, if unset) to the target's images. DryRun only tells what
+	// would be removed.
+	PruneImagesArgs struct {
+		Target string `json:",omitempty"`
+		Policy *RetentionPolicy
+		DryRun bool
+	}
+	// PruneImagesReply lists, as channel/version, the images removed and
+	// how much disk (in bytes) that freed
+	PruneImagesReply struct {
+		Removed []string
+		Freed   int64
+	}
+	// PullImageArgs asks corectld to fetch the given image from upstream.
+	// Override refetches it even if already local, PreferLocal resolves
+	// 'latest' against the local images instead of upstream's. Sources
+	// ('[target/][channel=]URL') are tried before corectld's own.
+	PullImageArgs struct {
+		Target                string `json:",omitempty"`
+		Channel, Version      string
+		Override, PreferLocal bool
+		Sources               []string
+	}
+	// PullImageReply ...
+	PullImageReply struct {
+		Version string
+	}
+
+	// UUIDtoMACaddrArgs ...
+	UUIDtoMACaddrArgs struct {
+		// UUID is the one to map, Requested what the user originally asked
+		// for (may be 'random')
+		UUID, Requested string
+	}
+	// UUIDtoMACaddrReply ...
+	UUIDtoMACaddrReply struct {
+		MacAddress, UUID string
+	}
+
+	// RunArgs ...
+	RunArgs struct {
+		VM *VMInfo
+	}
+	// VMReply ...
+	VMReply struct {
+		VM *VMInfo
+	}
+
+	// ActiveVMsReply ...
+	ActiveVMsReply struct {
+		Running VMmap
+	}
+
+	// SSHkeyArgs names the running VM whose internal ssh key is asked for
+	SSHkeyArgs struct {
+		VM string
+	}
+	// SSHkeyReply holds the private key with which corectl logs into the
+	// VM. It's kept out of every other reply.
+	SSHkeyReply struct {
+		Private string
+	}
+
+	// StopVMsArgs targets either the named VMs or, if none, all of them.
+	// When Forced a single (random if unnamed) VM gets hard killed.
+	StopVMsArgs struct {
+		Targets []string
+		Forced  bool
+	}
+	// StopVMsReply lists the VMs stopped and, among these, the ones that
+	// had to be hard killed as they didn't power off in time
+	StopVMsReply struct {
+		Stopped, Killed []string
+	}
+
+	// PauseVMsArgs targets the VMs to either pause or resume
+	PauseVMsArgs struct {
+		Targets []string
+	}
+	// PauseVMsReply lists the VMs paused (or resumed) and, for those that
+	// couldn't be, why
+	PauseVMsReply struct {
+		VMs    []string
+		Failed map[string]string `json:",omitempty"`
+	}
+
+	// CreateVMArgs ...
+	CreateVMArgs struct {
+		VM        *VMInfo
+		Overwrite bool
+	}
+
+	// DefinedVMsArgs selects which definitions to get, all if none
+	DefinedVMsArgs struct {
+		Names []string
+	}
+	// DefinedVMsReply ...
+	DefinedVMsReply struct {
+		Defined VMmap
+	}
+
+	// RemoveVMArgs ...
+	RemoveVMArgs struct {
+		Names  []string
+		Forced bool
+	}
+	// RemoveVMReply ...
+	RemoveVMReply struct {
+		Removed []string
+	}
+)
+
+func validNames(what string, names []string) error {
+	for _, n := range names {
+		if strings.TrimSpace(n) == "" {
+			return Invalid("empty %s", what)
+		}
+	}
+	return nil
+}
+
+func validVM(vm *VMInfo) error {
+	if vm == nil {
+		return Invalid("no VM was provided")
+	}
+	if vm.Name == "" {
+		return Invalid("VMs must be named")
+	}
+	if _, err := uuid.FromString(vm.UUID); err != nil {
+		return Invalid("'%v' is not a valid UUID", vm.UUID)
+	}
+	if vm.Memory < MinMemory {
+		return Invalid("VMs need at least %vMB of RAM (%v asked for %vMB)",
+			MinMemory, vm.Name, vm.Memory)
+	}
+	if vm.Cpus < 1 {
+		return Invalid("VMs need at least one vCPU")
+	}
+	if err := validChannel(vm.Target, vm.Channel); err != nil {
+		return err
+	}
+	if vm.InitrdOverlay != "" && !filepath.IsAbs(vm.InitrdOverlay) {
+		return Invalid("initrd overlays must be given as absolute paths")
+	}
+	if vm.StopTimeout < 0 {
+		return Invalid("stop timeout can't be negative")
+	}
+	if vm.MaxRestarts < 0 {
+		return Invalid("max restarts can't be negative")
+	}
+	if vm.Restart == "" {
+		return nil
+	}
+	for _, p := range RestartPolicies {
+		if vm.Restart == p {
+			return nil
+		}
+	}
+	return Invalid("'%v' is not a known restart policy (%v)", vm.Restart,
+		strings.Join(RestartPolicies, ", "))
+}
+
+func validChannel(name, channel string) error {
+	t, err := target.Get(name)
+	if err != nil {
+		return Invalid("%v", err)
+	}
+	if err = target.ValidChannel(t, channel); err != nil {
+		return Invalid("%v", err)
+	}
+	return nil
+}
+
+// ValidImage checks that the given target, channel and version name an
+// image corectld could hold
+func ValidImage(name, channel, version string) error {
+	if err := validChannel(name, channel); err != nil {
+		return err
+	}
+	if _, err := semver.Parse(version); err != nil {
+		return Invalid("'%v' is not a valid version", version)
+	}
+	return nil
+}
+
+func (a *NoArgs) Validate() error { return nil }
+
+func (a *EchoArgs) Validate() error {
+	if a.APIVersion != APIVersion {
+		return Errorf(ErrCodeVersionMismatch, "corectl speaks API v%v "+
+			"while corectld speaks v%v. Please use matching versions of "+
+			"both", a.APIVersion, APIVersion)
+	}
+	return nil
+}
+
+func (a *ImagesArgs) Validate() error {
+	if _, err := target.Get(a.Target); err != nil {
+		return Invalid("%v", err)
+	}
+	return nil
+}
+
+func (a *RemoveImageArgs) Validate() error {
+	return ValidImage(a.Target, a.Channel, a.Version)
+}
+
+func (a *ImageArgs) Validate() error {
+	return ValidImage(a.Target, a.Channel, a.Version)
+}
+
+func (a *VerifyImagesArgs) Validate() error {
+	switch {
+	case a.Version != "":
+		return ValidImage(a.Target, a.Channel, a.Version)
+	case a.Channel != "":
+		return validChannel(a.Target, a.Channel)
+	}
+	if _, err := target.Get(a.Target); err != nil {
+		return Invalid("%v", err)
+	}
+	return nil
+}
+
+func (a *PinImageArgs) Validate() error {
+	return ValidImage(a.Target, a.Channel, a.Version)
+}
+
+func (a *PruneImagesArgs) Validate() error {
+	if _, err := target.Get(a.Target); err != nil {
+		return Invalid("%v", err)
+	}
+	if a.Policy != nil {
+		return a.Policy.Validate()
+	}
+	return nil
+}
+
+func (a *PullImageArgs) Validate() error {
+	if _, err := target.ParseSources(a.Sources); err != nil {
+		return Invalid("%v", err)
+	}
+	if a.Version == "latest" {
+		return validChannel(a.Target, a.Channel)
+	}
+	return ValidImage(a.Target, a.Channel, a.Version)
+}
+
+func (a *UUIDtoMACaddrArgs) Validate() error {
+	if _, err := uuid.FromString(a.UUID); err != nil {
+		return Invalid("'%v' is not a valid UUID", a.UUID)
+	}
+	return nil
+}
+
+func (a *RunArgs) Validate() error { return validVM(a.VM) }
+
+func (a *SSHkeyArgs) Validate() error {
+	if a.VM == "" {
+		return Invalid("no VM was named")
+	}
+	return validNames("VM name or UUID", []string{a.VM})
+}
+
+func (a *StopVMsArgs) Validate() error {
+	return validNames("VM name or UUID", a.Targets)
+}
+
+func (a *PauseVMsArgs) Validate() error {
+	if len(a.Targets) == 0 {
+		return Invalid("no VM was named")
+	}
+	return validNames("VM name or UUID", a.Targets)
+}
+
+func (a *CreateVMArgs) Validate() error { return validVM(a.VM) }
+
+func (a *DefinedVMsArgs) Validate() error {
+	return validNames("VM name", a.Names)
+}
+
+func (a *RemoveVMArgs) Validate() error {
+	if len(a.Names) == 0 {
+		return Invalid("no VM names were provided")
+	}
+	return validNames("VM name", a.Names)
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package api
+
+// on disk layout of corectld's PKI. Clients only need the CA certificate and
+// a client key pair, as handed by 'corectld client-cert'.
+const (
+	CAcert     = "ca.pem"
+	CAkey      = "ca-key.pem"
+	ServerCert = "server.pem"
+	ServerKey  = "server-key.pem"
+	ClientCert = "client.pem"
+	ClientKey  = "client-key.pem"
+)
@@ -0,0 +1,186 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package api holds the types through which corectl, and any other Go tool,
+// talks to corectld. It is kept free of any server side dependency so that
+// clients can import it cheaply.
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+type (
+	// VMInfo - per VM settings
+	VMInfo struct {
+		Name, Channel, Version, UUID            string
+		MacAddress, PublicIP                    string
+		InternalSSHkey, InternalSSHprivate      string
+		Cpus, Memory, Pid                       int
+		SSHkey, CloudConfig, CClocation         string `json:",omitempty"`
+		AddToHypervisor, AddToKernel            string `json:",omitempty"`
+		Hypervisor                              string `json:",omitempty"`
+		Ethernet                                []NetworkInterface
+		Storage                                 StorageAssets `json:",omitempty"`
+		SharedHomedir, OfflineMode, NotIsolated bool
+		FormatRoot, PersistentRoot              bool
+		CreationTime                            time.Time
+		// Target is the OS distribution the VM runs (the default one if
+		// unset)
+		Target string `json:",omitempty"`
+		// Paused tells whether the VM's runner is suspended
+		Paused bool
+		// Owner is who booted the VM, as seen by corectld
+		Owner string `json:",omitempty"`
+		// StopTimeout is how many seconds the VM is given to power itself
+		// off before being hard killed (0 means corectld's default)
+		StopTimeout int `json:",omitempty"`
+		// DependsOn lists the VMs this one needs, which are therefore
+		// only halted once it's gone
+		DependsOn []string `json:",omitempty"`
+		// Restart is the VM's restart policy, MaxRestarts how many
+		// consecutive times it may be restarted (0 means no limit)
+		Restart     string `json:",omitempty"`
+		MaxRestarts int    `json:",omitempty"`
+		// Restarts counts how many times the VM was restarted, LastExit
+		// tells why its runner last went away
+		Restarts int
+		LastExit string `json:",omitempty"`
+		// InitrdOverlay is a directory (on corectld's host) whose files
+		// are added to the initrd the VM boots from
+		InitrdOverlay string `json:",omitempty"`
+	}
+	// VMmap indexes VMs by UUID
+	VMmap map[string]*VMInfo
+	// NetworkInterface ...
+	NetworkInterface struct {
+		Type int
+		// if/when tap...
+		Path string `json:",omitempty"`
+	}
+	// StorageDevice ...
+	StorageDevice struct {
+		Slot, Format int
+		Type, Path   string
+	}
+	// StorageAssets ...
+	StorageAssets struct {
+		CDDrives, HardDrives map[string]StorageDevice `json:",omitempty"`
+	}
+)
+
+const (
+	_ = iota
+	Raw
+	Qcow2
+	Tap
+	HDD    = "HDD"
+	CDROM  = "CDROM"
+	Local  = "localfs"
+	Remote = "URL"
+)
+
+// MinMemory is the least RAM, in MB, a VM may be given
+const MinMemory = 1024
+
+// restart policies
+const (
+	RestartNo        = "no"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// RestartPolicies ...
+var RestartPolicies = []string{RestartNo, RestartOnFailure, RestartAlways}
+
+// VolumesInUse checks that none of the VM's volumes is already being used by
+// one of the given running VMs
+func (vm *VMInfo) VolumesInUse(running VMmap) error {
+	for _, d := range running {
+		if d.UUID == vm.UUID {
+			continue
+		}
+		for _, vv := range d.Storage.HardDrives {
+			for _, v := range vm.Storage.HardDrives {
+				if v.Path == vv.Path {
+					return fmt.Errorf("Aborting: %s %s (%s)", v.Path,
+						"already being used as a volume by another VM.",
+						d.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (vm *VMInfo) PrettyPrint() {
+	fmt.Printf("\n UUID:\t\t%v\n  Name:\t\t%v\n  Version:\t%v\n  "+
+		"Channel:\t%v\n  vCPUs:\t%v\n  Memory (MB):\t%v\n",
+		vm.UUID, vm.Name, vm.Version, vm.Channel, vm.Cpus, vm.Memory)
+	if vm.Target != "" {
+		fmt.Printf("  Target:\t%v\n", vm.Target)
+	}
+	fmt.Printf("  Hypervisor:\t%v\n  Pid:\t\t%v\n  Uptime:\t%v\n",
+		vm.Hypervisor, vm.Pid, humanize.Time(vm.CreationTime))
+	fmt.Printf("  Sees World:\t%v\n", vm.NotIsolated)
+	if vm.Paused {
+		fmt.Printf("  Paused:\t%v\n", vm.Paused)
+	}
+	if vm.Owner != "" {
+		fmt.Printf("  Owner:\t%v\n", vm.Owner)
+	}
+	if vm.Restart != "" && vm.Restart != RestartNo {
+		fmt.Printf("  Restarts:\t%v (%v)\n", vm.Restarts, vm.Restart)
+	}
+	if vm.LastExit != "" {
+		fmt.Printf("  Last exit:\t%v\n", vm.LastExit)
+	}
+	if vm.CloudConfig != "" {
+		fmt.Printf("  cloud-config:\t%v\n", vm.CloudConfig)
+	}
+	if vm.InitrdOverlay != "" {
+		fmt.Printf("  initrd overlay:\t%v\n", vm.InitrdOverlay)
+	}
+	fmt.Println("  Network:")
+	fmt.Printf("    eth0:\t%v\n", vm.PublicIP)
+	vm.Storage.PrettyPrint(vm.PersistentRoot)
+}
+
+func (volumes *StorageAssets) PrettyPrint(root bool) {
+	if len(volumes.CDDrives)+len(volumes.HardDrives) > 0 {
+		fmt.Println("  Volumes:")
+		for a, b := range volumes.CDDrives {
+			fmt.Printf("   /dev/cdrom%v\t%s\n", a, b.Path)
+		}
+		for a, b := range volumes.HardDrives {
+			format := "raw"
+			i, _ := strconv.Atoi(a)
+			if b.Format == Qcow2 {
+				format = "qcow2"
+			}
+			if root && i == 0 {
+				fmt.Printf("   /,/dev/vd%v\t%s,format=%s\n", string(rune(i+'a')),
+					b.Path, format)
+			} else {
+				fmt.Printf("   /dev/vd%v\t%s,format=%s\n", string(rune(i+'a')),
+					b.Path, format)
+			}
+		}
+	}
+}
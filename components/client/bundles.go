@@ -0,0 +1,88 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/genevera/corectl/components/api"
+)
+
+// Export copies into w, as a tarball that Import takes, the given locally
+// available image along with what is needed to verify it elsewhere
+func (c *Client) Export(ctx context.Context, target, channel, version string,
+	w io.Writer) (err error) {
+	var (
+		req  *http.Request
+		resp *http.Response
+	)
+	if req, err = http.NewRequest("GET", c.base+"/bundles/"+
+		url.PathEscape(target)+"/"+url.PathEscape(channel)+"/"+
+		url.PathEscape(version), nil); err != nil {
+		return
+	}
+	if resp, err = c.http.Do(req.WithContext(ctx)); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return bundleError(resp, "export %s/%s", channel, version)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return
+}
+
+// Import hands over to corectld the image bundle read from r, which it
+// verifies before making it locally available, unless one with the same
+// version already is (and not told to replace it)
+func (c *Client) Import(ctx context.Context, r io.Reader,
+	force bool) (m *api.ImageManifest, err error) {
+	var (
+		req  *http.Request
+		resp *http.Response
+		u    = c.base + "/bundles"
+	)
+	if force {
+		u += "?force=true"
+	}
+	if req, err = http.NewRequest("POST", u, r); err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+	if resp, err = c.http.Do(req.WithContext(ctx)); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, bundleError(resp, "import image")
+	}
+	m = &api.ImageManifest{}
+	err = json.NewDecoder(resp.Body).Decode(m)
+	return
+}
+
+func bundleError(resp *http.Response, format string,
+	a ...interface{}) error {
+	msg := make([]byte, 512)
+	n, _ := io.ReadFull(resp.Body, msg)
+	return fmt.Errorf("unable to %s: %s (%s)", fmt.Sprintf(format, a...),
+		resp.Status, msg[:n])
+}
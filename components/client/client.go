@@ -0,0 +1,177 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package client talks to corectld's control API. It is what corectl itself
+// uses, and is meant to be usable as well by any other Go tool wanting to
+// drive corectld, without dragging along any of the server's dependencies.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/release"
+	"github.com/gorilla/rpc/json"
+)
+
+var (
+	// DefaultTimeout bounds every call whose context has no deadline of its
+	// own, except the ones that may legitimately take long (i.e. Pull)
+	DefaultTimeout = 60 * time.Second
+	// retryDelay is how long to wait, times the attempt number, before
+	// retrying to reach corectld
+	retryDelay = 500 * time.Millisecond
+)
+
+// Options ...
+type Options struct {
+	// Socket is the control socket of a local corectld
+	Socket string
+	// Address (host:port) of a remote corectld. When set corectld is reached
+	// over mutual TLS, as configured by TLS (see TLSConfig), instead of
+	// via Socket
+	Address string
+	TLS     *tls.Config
+	// Timeout replaces DefaultTimeout, if set
+	Timeout time.Duration
+	// Retries is how many more times a call gets attempted while corectld
+	// can't be reached
+	Retries int
+	// Meta identifies the client to corectld
+	Meta *release.Info
+	// ImageSources ('[channel=]URL') are handed over on every pull, to be
+	// tried before corectld's own
+	ImageSources []string
+}
+
+// Client ...
+type Client struct {
+	opts Options
+	http *http.Client
+	base string
+}
+
+// New returns a client for the corectld described by opts
+func New(opts Options) (c *Client, err error) {
+	var (
+		dialer    = &net.Dialer{Timeout: 10 * time.Second}
+		transport = &http.Transport{}
+	)
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	c = &Client{opts: opts, http: &http.Client{Transport: transport}}
+	switch {
+	case opts.Address != "":
+		if opts.TLS == nil {
+			return nil, fmt.Errorf("remote corectld (%v) can only be "+
+				"reached over mutual TLS, but no TLS settings were given",
+				opts.Address)
+		}
+		transport.TLSClientConfig = opts.TLS
+		transport.DialContext = func(ctx context.Context,
+			_, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", opts.Address)
+		}
+		c.base = "https://" + opts.Address
+	case opts.Socket != "":
+		transport.DialContext = func(ctx context.Context,
+			_, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", opts.Socket)
+		}
+		c.base = "http://corectld"
+	default:
+		return nil, fmt.Errorf("either a control socket or the address " +
+			"of a remote corectld is needed")
+	}
+	return
+}
+
+// Remote tells whether corectld is reached over the network
+func (c *Client) Remote() bool {
+	return c.opts.Address != ""
+}
+
+// Call invokes the given corectld RPC method, decoding its outcome into
+// reply. Errors returned by corectld come back as *api.RPCError.
+func (c *Client) Call(ctx context.Context, method string,
+	args, reply interface{}) error {
+	return c.call(ctx, c.opts.Timeout, method, args, reply)
+}
+
+func (c *Client) call(ctx context.Context, timeout time.Duration,
+	method string, args, reply interface{}) (err error) {
+	var (
+		message []byte
+		resp    *http.Response
+		cancel  context.CancelFunc
+	)
+	if _, bounded := ctx.Deadline(); !bounded && timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if message, err =
+		json.EncodeClientRequest("RPCservice."+method, args); err != nil {
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		if resp, err = c.post(ctx, message); err == nil {
+			break
+		}
+		if !dialFailed(err) || attempt > c.opts.Retries {
+			return fmt.Errorf("Error in sending request to %s. %s", c.base,
+				err)
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * retryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	defer resp.Body.Close()
+	if err = json.DecodeClientResponse(resp.Body, reply); err != nil {
+		err = api.ParseError(err.Error())
+	}
+	return
+}
+
+func (c *Client) post(ctx context.Context,
+	message []byte) (resp *http.Response, err error) {
+	var req *http.Request
+
+	if req, err = http.NewRequest("POST", c.base+"/rpc",
+		bytes.NewReader(message)); err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.http.Do(req.WithContext(ctx))
+}
+
+// dialFailed tells whether the request never reached corectld, and so is
+// safe to retry
+func dialFailed(err error) bool {
+	if e, ok := err.(*url.Error); ok {
+		err = e.Err
+	}
+	e, ok := err.(*net.OpError)
+	return ok && e.Op == "dial"
+}
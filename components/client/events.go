@@ -0,0 +1,71 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/genevera/corectl/components/api"
+)
+
+// Events subscribes to corectld's VM lifecycle events, calling fn on every
+// one received until either it returns an error, ctx is done or corectld
+// goes away. since is either a timestamp (RFC3339) or a duration (i.e 10m),
+// vms restricts which VMs' events are of interest (all if none).
+func (c *Client) Events(ctx context.Context, since string, vms []string,
+	fn func(*api.Event) error) (err error) {
+	var (
+		req   *http.Request
+		resp  *http.Response
+		query = url.Values{"vm": vms}
+		dec   *json.Decoder
+	)
+	if since != "" {
+		query.Set("since", since)
+	}
+	if req, err = http.NewRequest("GET",
+		c.base+"/events?"+query.Encode(), nil); err != nil {
+		return
+	}
+	if resp, err = c.http.Do(req.WithContext(ctx)); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg := make([]byte, 512)
+		n, _ := io.ReadFull(resp.Body, msg)
+		return fmt.Errorf("unable to subscribe to events: %s (%s)",
+			resp.Status, msg[:n])
+	}
+	dec = json.NewDecoder(resp.Body)
+	for {
+		e := &api.Event{}
+		if err = dec.Decode(e); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		if err = fn(e); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Logs copies into w the serial log of the given VM (name or UUID), which
+// may be gone already as long as corectld still keeps its log. tail, when
+// positive, limits it to its last lines, since (either a timestamp, RFC3339,
+// or a duration, i.e. 10m) to what got logged meanwhile. When following,
+// it only returns once ctx is done or the VM is gone.
+func (c *Client) Logs(ctx context.Context, id string, follow bool, tail int,
+	since string, w io.Writer) (err error) {
+	var (
+		req   *http.Request
+		resp  *http.Response
+		query = url.Values{}
+	)
+	if follow {
+		query.Set("follow", "true")
+	}
+	if tail > 0 {
+		query.Set("tail", strconv.Itoa(tail))
+	}
+	if since != "" {
+		query.Set("since", since)
+	}
+	if req, err = http.NewRequest("GET", c.base+"/logs/"+
+		url.PathEscape(id)+"?"+query.Encode(), nil); err != nil {
+		return
+	}
+	if resp, err = c.http.Do(req.WithContext(ctx)); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg := make([]byte, 512)
+		n, _ := io.ReadFull(resp.Body, msg)
+		return fmt.Errorf("unable to get %v's log: %s (%s)", id,
+			resp.Status, msg[:n])
+	}
+	_, err = io.Copy(w, resp.Body)
+	return
+}
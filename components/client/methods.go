@@ -0,0 +1,266 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/release"
+)
+
+// Ping checks that corectld is up, and speaking our API version, returning
+// its release information
+func (c *Client) Ping(ctx context.Context) (meta *release.Info, err error) {
+	reply := &api.EchoReply{}
+	if err = c.Call(ctx, "Echo", &api.EchoArgs{APIVersion: api.APIVersion,
+		Client: c.opts.Meta}, reply); err != nil {
+		return
+	}
+	if reply.APIVersion != api.APIVersion {
+		return nil, api.Errorf(api.ErrCodeVersionMismatch, "client speaks "+
+			"API v%v while corectld (%v) speaks v%v. Please use matching "+
+			"versions of both", api.APIVersion, reply.Meta.Version,
+			reply.APIVersion)
+	}
+	return reply.Meta, nil
+}
+
+// Shutdown stops corectld, along with all running VMs. As it waits for
+// these to halt, it's only bound by ctx.
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.call(ctx, 0, "Stop", &api.NoArgs{}, &api.NoArgs{})
+}
+
+// HandlesNFS tells whether corectld is able to share the host's homedir
+// with the VMs
+func (c *Client) HandlesNFS(ctx context.Context) (bool, error) {
+	reply := &api.HandlesNFSReply{}
+	err := c.Call(ctx, "HandlesNFS", &api.NoArgs{}, reply)
+	return reply.WorkingNFS, err
+}
+
+// Images returns the given target's locally available images, indexed by
+// channel (an empty target meaning the default one)
+func (c *Client) Images(ctx context.Context,
+	target string) (map[string]semver.Versions, error) {
+	reply := &api.ImagesReply{}
+	err := c.Call(ctx, "AvailableImages", &api.ImagesArgs{Target: target},
+		reply)
+	return reply.Images, err
+}
+
+// ImageDetails returns, along with the given target's locally available
+// images, which of them are unverified or pinned
+func (c *Client) ImageDetails(ctx context.Context,
+	target string) (*api.ImagesReply, error) {
+	reply := &api.ImagesReply{}
+	err := c.Call(ctx, "AvailableImages", &api.ImagesArgs{Target: target},
+		reply)
+	return reply, err
+}
+
+// RemoveImage removes a locally available image, returning the ones left.
+// Unless forced, pinned images and the ones running VMs booted from are
+// kept.
+func (c *Client) RemoveImage(ctx context.Context, target, channel,
+	version string, force bool) (map[string]semver.Versions, error) {
+	reply := &api.ImagesReply{}
+	err := c.Call(ctx, "RemoveImage", &api.RemoveImageArgs{
+		Target: target, Channel: channel, Version: version,
+		Force: force}, reply)
+	return reply.Images, err
+}
+
+// InspectImage returns the given image's manifest, along with whether it
+// is pinned or in use
+func (c *Client) InspectImage(ctx context.Context, target, channel,
+	version string) (*api.InspectImageReply, error) {
+	reply := &api.InspectImageReply{}
+	err := c.Call(ctx, "InspectImage", &api.ImageArgs{
+		Target: target, Channel: channel, Version: version}, reply)
+	return reply, err
+}
+
+// VerifyImages re-hashes, offline, the given target's images (only the
+// channel's, or a single one, if set), returning what is wrong with the
+// ones that failed. As that may take a while, it's only bound by ctx.
+func (c *Client) VerifyImages(ctx context.Context, target, channel,
+	version string) (*api.VerifyImagesReply, error) {
+	reply := &api.VerifyImagesReply{}
+	err := c.call(ctx, 0, "VerifyImages", &api.VerifyImagesArgs{
+		Target: target, Channel: channel, Version: version}, reply)
+	return reply, err
+}
+
+// PinImage pins (or, unless pin, unpins) a locally available image, which
+// keeps it from being pruned or removed
+func (c *Client) PinImage(ctx context.Context, target, channel,
+	version string, pin bool) error {
+	return c.Call(ctx, "PinImage", &api.PinImageArgs{
+		Target: target, Channel: channel, Version: version, Pin: pin},
+		&api.ImagesReply{})
+}
+
+// PruneImages applies the given retention policy (corectld's own, if nil)
+// to the target's images, returning what got (or, on a dry run, would be)
+// removed
+func (c *Client) PruneImages(ctx context.Context, target string,
+	policy *api.RetentionPolicy,
+	dryRun bool) (*api.PruneImagesReply, error) {
+	reply := &api.PruneImagesReply{}
+	err := c.Call(ctx, "PruneImages", &api.PruneImagesArgs{
+		Target: target, Policy: policy, DryRun: dryRun}, reply)
+	return reply, err
+}
+
+// Pull makes the given image (version may be 'latest') locally available,
+// returning the actual version pulled. As downloads may take a while, it's
+// only bound by ctx.
+func (c *Client) Pull(ctx context.Context, target, channel, version string,
+	override, preferLocal bool) (string, error) {
+	reply := &api.PullImageReply{}
+	err := c.call(ctx, 0, "PullImage", &api.PullImageArgs{
+		Target: target, Channel: channel, Version: version,
+		Override: override, PreferLocal: preferLocal,
+		Sources: c.opts.ImageSources}, reply)
+	return reply.Version, err
+}
+
+// UUIDtoMAC returns the MAC address the VM with the given UUID will get,
+// along with the UUID actually used, as it may have had to be replaced
+func (c *Client) UUIDtoMAC(ctx context.Context,
+	uuid, requested string) (mac, actual string, err error) {
+	reply := &api.UUIDtoMACaddrReply{}
+	err = c.Call(ctx, "UUIDtoMACaddr", &api.UUIDtoMACaddrArgs{
+		UUID: uuid, Requested: requested}, reply)
+	return reply.MacAddress, reply.UUID, err
+}
+
+// Run boots the given, fully specified, VM returning it as running
+func (c *Client) Run(ctx context.Context,
+	vm *api.VMInfo) (*api.VMInfo, error) {
+	reply := &api.VMReply{}
+	err := c.Call(ctx, "Run", &api.RunArgs{VM: vm}, reply)
+	return reply.VM, err
+}
+
+// ListVMs returns all running VMs, indexed by UUID
+func (c *Client) ListVMs(ctx context.Context) (api.VMmap, error) {
+	reply := &api.ActiveVMsReply{}
+	err := c.Call(ctx, "ActiveVMs", &api.NoArgs{}, reply)
+	return reply.Running, err
+}
+
+// SSHkey returns the private key with which to log into the given VM
+func (c *Client) SSHkey(ctx context.Context, vm *api.VMInfo) (string, error) {
+	reply := &api.SSHkeyReply{}
+	err := c.Call(ctx, "SSHkey", &api.SSHkeyArgs{VM: vm.UUID}, reply)
+	return reply.Private, err
+}
+
+// VM returns the running VM with the given name or UUID
+func (c *Client) VM(ctx context.Context, id string) (*api.VMInfo, error) {
+	running, err := c.ListVMs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, vm := range running {
+		if vm.Name == id || vm.UUID == id {
+			return vm, nil
+		}
+	}
+	return nil, api.Errorf(api.ErrCodeNotFound, "'%s' not found, or dead",
+		id)
+}
+
+// Stop gracefully shuts down the given VMs, all if none, returning the
+// names of the ones stopped and of those, among these, that had to be hard
+// killed. As it waits for the VMs to be gone, it's only bound by ctx.
+func (c *Client) Stop(ctx context.Context,
+	ids ...string) (stopped, killed []string, err error) {
+	reply := &api.StopVMsReply{}
+	err = c.call(ctx, 0, "StopVMs", &api.StopVMsArgs{Targets: ids}, reply)
+	return reply.Stopped, reply.Killed, err
+}
+
+// Pause suspends the given VMs, returning the names of the ones paused,
+// even if others failed to
+func (c *Client) Pause(ctx context.Context, ids ...string) ([]string, error) {
+	reply := &api.PauseVMsReply{}
+	err := c.Call(ctx, "PauseVMs", &api.PauseVMsArgs{Targets: ids}, reply)
+	return reply.VMs, pauseFailures(reply, err)
+}
+
+// Resume brings back the given paused VMs, returning the names of the ones
+// resumed, even if others failed to
+func (c *Client) Resume(ctx context.Context, ids ...string) ([]string, error) {
+	reply := &api.PauseVMsReply{}
+	err := c.Call(ctx, "ResumeVMs", &api.PauseVMsArgs{Targets: ids}, reply)
+	return reply.VMs, pauseFailures(reply, err)
+}
+
+func pauseFailures(reply *api.PauseVMsReply, err error) error {
+	if err != nil || len(reply.Failed) == 0 {
+		return err
+	}
+	why := []string{}
+	for _, e := range reply.Failed {
+		why = append(why, e)
+	}
+	sort.Strings(why)
+	return fmt.Errorf("%s", strings.Join(why, "; "))
+}
+
+// Kill hard kills the given VM or, if none, a random one
+func (c *Client) Kill(ctx context.Context, ids ...string) ([]string, error) {
+	reply := &api.StopVMsReply{}
+	err := c.Call(ctx, "StopVMs", &api.StopVMsArgs{Targets: ids,
+		Forced: true}, reply)
+	return reply.Stopped, err
+}
+
+// CreateVM stores the given VM's definition, returning it as stored
+func (c *Client) CreateVM(ctx context.Context, vm *api.VMInfo,
+	overwrite bool) (*api.VMInfo, error) {
+	reply := &api.VMReply{}
+	err := c.Call(ctx, "CreateVM", &api.CreateVMArgs{VM: vm,
+		Overwrite: overwrite}, reply)
+	return reply.VM, err
+}
+
+// DefinedVMs returns the named VM definitions, all if none, indexed by name
+func (c *Client) DefinedVMs(ctx context.Context,
+	names ...string) (api.VMmap, error) {
+	reply := &api.DefinedVMsReply{}
+	err := c.Call(ctx, "DefinedVMs", &api.DefinedVMsArgs{Names: names},
+		reply)
+	return reply.Defined, err
+}
+
+// RemoveVM removes the named VM definitions. Unless forced, definitions of
+// running VMs are kept.
+func (c *Client) RemoveVM(ctx context.Context, forced bool,
+	names ...string) ([]string, error) {
+	reply := &api.RemoveVMReply{}
+	err := c.Call(ctx, "RemoveVM", &api.RemoveVMArgs{Names: names,
+		Forced: forced}, reply)
+	return reply.Removed, err
+}
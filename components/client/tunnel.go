@@ -0,0 +1,146 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+
+	"github.com/genevera/corectl/components/api"
+)
+
+// TLSConfig loads, from the given directory, the client certificate (and
+// corectld's CA) issued by 'corectld client-cert'. serverName is the name,
+// or IP, under which corectld is being reached.
+func TLSConfig(dir, serverName string) (cfg *tls.Config, err error) {
+	var (
+		pair tls.Certificate
+		buf  []byte
+		pool = x509.NewCertPool()
+	)
+	if pair, err = tls.LoadX509KeyPair(filepath.Join(dir, api.ClientCert),
+		filepath.Join(dir, api.ClientKey)); err != nil {
+		return nil, fmt.Errorf("unable to load client certificate from "+
+			"%v (%v)", dir, err)
+	}
+	if buf, err = ioutil.ReadFile(filepath.Join(dir, api.CAcert)); err != nil {
+		return
+	}
+	if !pool.AppendCertsFromPEM(buf) {
+		return nil, fmt.Errorf("no certificates found in %v",
+			filepath.Join(dir, api.CAcert))
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		RootCAs:      pool,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// bufferedConn is a net.Conn whose first bytes may have already been
+// read into a buffer
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// DialVM returns a connection to the given VM's ssh port, either direct or,
+// when corectld is remote, tunneled through it
+func (c *Client) DialVM(ctx context.Context,
+	vm *api.VMInfo) (conn net.Conn, err error) {
+	if vm.Paused {
+		return nil, api.Errorf(api.ErrCodeConflict, "'%v' is paused",
+			vm.Name)
+	}
+	if !c.Remote() {
+		return (&net.Dialer{}).DialContext(ctx, "tcp",
+			net.JoinHostPort(vm.PublicIP, "22"))
+	}
+	if conn, err = c.upgrade(ctx, "/tunnel/"+vm.UUID); err != nil {
+		err = fmt.Errorf("unable to tunnel into %v (%v)", vm.Name, err)
+	}
+	return
+}
+
+// Console attaches to the given VM's serial console, through corectld.
+// Read-only clients just get to watch.
+func (c *Client) Console(ctx context.Context, vm *api.VMInfo,
+	readOnly bool) (conn net.Conn, err error) {
+	path := "/console/" + vm.UUID
+	if readOnly {
+		path += "?readonly=true"
+	}
+	if conn, err = c.upgrade(ctx, path); err != nil {
+		err = fmt.Errorf("unable to attach to %v's console (%v)", vm.Name,
+			err)
+	}
+	return
+}
+
+// upgrade turns a fresh connection to corectld, once the given endpoint
+// accepts it, into a raw bidirectional stream
+func (c *Client) upgrade(ctx context.Context, path string) (conn net.Conn,
+	err error) {
+	var (
+		req    *http.Request
+		resp   *http.Response
+		rd     *bufio.Reader
+		dialer = &net.Dialer{}
+	)
+	if c.Remote() {
+		if conn, err = dialer.DialContext(ctx, "tcp",
+			c.opts.Address); err == nil {
+			conn = tls.Client(conn, c.opts.TLS)
+		}
+	} else {
+		conn, err = dialer.DialContext(ctx, "unix", c.opts.Socket)
+	}
+	if err != nil {
+		return
+	}
+	if req, err = http.NewRequest("GET", c.base+path, nil); err != nil {
+		conn.Close()
+		return
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return
+	}
+	rd = bufio.NewReader(conn)
+	if resp, err = http.ReadResponse(rd, req); err != nil {
+		conn.Close()
+		return
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("%v", resp.Status)
+	}
+	return &bufferedConn{conn, rd}, nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package platform abstracts away all that corectl(d) needs from the host
+// operating system it runs on
+package platform
+
+type (
+	// HostPlatform ...
+	HostPlatform interface {
+		// Name returns the name of the host platform
+		Name() string
+		// Network returns the address and mask of the host's side of the
+		// VMs' network
+		Network() (address, mask string, err error)
+		// GuestMACfromUUID returns the MAC address that a VM with the given
+		// UUID will end up with
+		GuestMACfromUUID(uuid string) (string, error)
+		// ExportShare makes dir available, via NFS, to the given network
+		ExportShare(dir, network, mask string) error
+		// SetupResolver makes the host resolve names under the given domain
+		// via the embedded dns server listening in the given port
+		SetupResolver(domain, port string) error
+		// TeardownResolver undoes what SetupResolver did
+		TeardownResolver() error
+		// ForwardDNS redirects the VMs' dns traffic to the embedded dns
+		// server listening at the given address and port
+		ForwardDNS(address, port string) error
+		// StopForwardDNS undoes what ForwardDNS did
+		StopForwardDNS() error
+		// PhysicalMemory returns the host's total physical memory, in bytes
+		PhysicalMemory() (uint64, error)
+	}
+)
+
+var (
+	// Host is the platform we are running on
+	Host HostPlatform
+	// WatermarkHeader tags the host files we generate and own
+	WatermarkHeader = "#\n# This file is automatically generated " +
+		"and managed by corectl\n#\n"
+)
@@ -0,0 +1,221 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package platform
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/deis/pkg/log"
+	// looks to be the new upstream
+	"github.com/keybase/go-ps"
+
+	"github.com/genevera/corectl/components/host/darwin/misc/uuid2ip"
+)
+
+// darwin is macOS, with VMs' networking provided by vmnet.framework
+type darwin struct{}
+
+// Bridge is the host interface to which the VMs are attached
+var Bridge = "bridge100"
+
+func init() {
+	Host = darwin{}
+}
+
+func (darwin) Name() string {
+	return "darwin"
+}
+
+func (darwin) Network() (address, mask string, err error) {
+	var (
+		netMask, netAddress []byte
+		cmdL                = []string{
+			"defaults", "read",
+			"/Library/Preferences/SystemConfiguration/com.apple.vmnet.plist",
+		}
+	)
+	if netAddress, err = exec.Command(cmdL[0],
+		append(cmdL[1:], "Shared_Net_Address")...).Output(); err != nil {
+		err = nil
+		log.Warn("%v \"%v %v %v\" %v ...",
+			"unable to run", cmdL[0], cmdL[1], cmdL[2], "Shared_Net_Address")
+		log.Warn("... assuming macOS default value (192.168.64.1)")
+		netAddress = []byte("192.168.64.1")
+	}
+
+	if netMask, err = exec.Command(cmdL[0],
+		append(cmdL[1:], "Shared_Net_Mask")...).Output(); err != nil {
+		err = nil
+		log.Warn("%v \"%v %v %v\" %v ...",
+			"unable to run", cmdL[0], cmdL[1], cmdL[2], "Shared_Net_Mask")
+		log.Warn("... assuming macOS default value (255.255.255.0)")
+		netMask = []byte("255.255.255.0")
+	}
+	return strings.TrimSpace(string(netAddress)),
+		strings.TrimSpace(string(netMask)), err
+}
+
+func (darwin) GuestMACfromUUID(uuid string) (string, error) {
+	return uuid2ip.GuestMACfromUUID(uuid)
+}
+
+func (darwin) ExportShare(dir, network, mask string) (err error) {
+	const exportsF = "/etc/exports"
+	var (
+		buf, bufN []byte
+		shared    bool
+		oldSigA   = "/Users -network 192.168.64.0 " +
+			"-mask 255.255.255.0 -alldirs -mapall="
+		oldSigB = fmt.Sprintf("%v -network %v -mask %v -alldirs -mapall=",
+			dir, network, mask)
+		signature = fmt.Sprintf("%v -network %v -mask %v -alldirs "+
+			"-maproot=root:wheel", dir, network, mask)
+		exportSet = func() (ok bool) {
+			for _, line := range strings.Split(string(buf), "\n") {
+				if strings.HasPrefix(line, signature) {
+					ok = true
+				}
+				if !strings.HasPrefix(line, oldSigA) &&
+					!strings.HasPrefix(line, oldSigB) {
+					bufN = append(bufN, []byte(line+"\n")...)
+				} else {
+					bufN = append(bufN, []byte("\n")...)
+				}
+			}
+			return
+		}
+		nfsIsRunning = func() bool {
+			all, _ := ps.Processes()
+			for _, p := range all {
+				if strings.HasSuffix(p.Executable(), "nfsd") {
+					return true
+				}
+			}
+			return false
+		}()
+		exportsCheck = func(previous []byte) (err error) {
+			var out []byte
+			if out, err = exec.Command("nfsd", "-F",
+				exportsF, "checkexports").Output(); err != nil {
+				err = fmt.Errorf("unable to validate %s ('%v')\n"+
+					"keeping original contents ('%v')",
+					exportsF, string(out), string(previous))
+				// getting back to where we were
+				ioutil.WriteFile(exportsF, previous, os.ModeAppend)
+			}
+			return
+		}
+	)
+	// check if /etc/exports exists, and if not create an empty one
+	if _, err = os.Stat(exportsF); os.IsNotExist(err) {
+		if err = ioutil.WriteFile(exportsF, []byte(""), 0644); err != nil {
+			return
+		}
+	}
+
+	if buf, err = ioutil.ReadFile(exportsF); err != nil {
+		return
+	}
+
+	if shared = exportSet(); !shared {
+		if err = ioutil.WriteFile(exportsF, append(bufN,
+			[]byte(signature+"\n")...), os.ModeAppend); err != nil {
+			return
+		}
+	}
+
+	if err = exportsCheck(buf); err != nil {
+		return
+	}
+
+	if nfsIsRunning {
+		if !shared {
+			if err = exec.Command("nfsd", "update").Run(); err != nil {
+				return fmt.Errorf("unable to update nfs "+
+					"service definitions... (%v)", err)
+			}
+			log.Info("'%s' now available to VMs' network via nfs", dir)
+		} else {
+			log.Info("'%s' was already available to VMs' network via nfs",
+				dir)
+		}
+	} else {
+		if err = exec.Command("nfsd", "start").Run(); err != nil {
+			return fmt.Errorf("unable to start NFS service... (%v)", err)
+		}
+		log.Info("nfs service started in order for '%s' to be "+
+			"made available to VMs' network", dir)
+	}
+	return
+}
+
+// SetupResolver transparently exposes the embedded dns server to the macOS
+// host
+func (darwin) SetupResolver(domain, port string) (err error) {
+	var resolver *os.File
+
+	os.Mkdir("/etc/resolver", 0755)
+	if resolver, err = os.Create("/etc/resolver/corectld"); err != nil {
+		return
+	}
+	defer resolver.Close()
+	fmt.Fprint(resolver, WatermarkHeader)
+	_, err = fmt.Fprintf(resolver,
+		"domain %s\nsearch %s\nnameserver 127.0.0.1\nport %v\n",
+		domain, domain, port)
+	return
+}
+
+func (darwin) TeardownResolver() error {
+	return os.Remove("/etc/resolver/corectld")
+}
+
+func (darwin) ForwardDNS(address, port string) (err error) {
+	var pfRules *os.File
+	if pfRules, err = ioutil.TempFile("", "coreos"); err != nil {
+		return
+	}
+	defer os.Remove(pfRules.Name())
+
+	fmt.Fprintf(pfRules,
+		"%s rdr pass on %s inet proto { tcp udp } "+
+			"from any to any port = domain -> %s port %v\n",
+		WatermarkHeader, Bridge, address, port)
+	pfRules.Close()
+	exec.Command("echo", "/sbin/pfctl", "-e").Run()
+	return exec.Command("echo", "/sbin/pfctl", "-a", "corectld-forwarding-dns",
+		"-f", pfRules.Name()).Run()
+}
+
+func (darwin) StopForwardDNS() error {
+	return exec.Command("echo", "/sbin/pfctl", "-a",
+		"corectld-forwarding-dns", "-Fa").Run()
+}
+
+// PhysicalMemory is the only thing we'd need from github.com/shirou/gopsutil
+func (darwin) PhysicalMemory() (v uint64, err error) {
+	var tStr string
+	if tStr, err = syscall.Sysctl("hw.memsize"); err == nil {
+		v = uint64(binary.LittleEndian.Uint64([]byte(tStr + "\x00")))
+	}
+	return
+}
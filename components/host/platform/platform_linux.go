@@ -0,0 +1,198 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package platform
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/deis/pkg/log"
+	"github.com/satori/go.uuid"
+)
+
+// linux expects the VMs to be attached to a pre-existing bridge, which
+// someone else (libvirt, NetworkManager, a dnsmasq instance...) is already
+// serving DHCP on
+type linux struct{}
+
+const (
+	exportsF       = "/etc/exports.d/corectld.exports"
+	resolvedF      = "/etc/systemd/resolved.conf.d/corectld.conf"
+	dnsmasqF       = "/etc/dnsmasq.d/corectld.conf"
+	nftablesTable  = "corectld"
+	defaultAddress = "192.168.64.1"
+	defaultMask    = "255.255.255.0"
+)
+
+// Bridge is the host interface to which the VMs are attached
+var Bridge = "corectl0"
+
+func init() {
+	Host = linux{}
+}
+
+func (linux) Name() string {
+	return "linux"
+}
+
+func (linux) Network() (address, mask string, err error) {
+	var (
+		iface *net.Interface
+		addrs []net.Addr
+	)
+	if iface, err = net.InterfaceByName(Bridge); err == nil {
+		if addrs, err = iface.Addrs(); err == nil {
+			for _, a := range addrs {
+				if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+					return n.IP.String(), net.IP(n.Mask).String(), err
+				}
+			}
+		}
+	}
+	log.Warn("unable to find an IPv4 address for bridge '%s' (%v) ...",
+		Bridge, err)
+	log.Warn("... assuming default values (%s/%s)",
+		defaultAddress, defaultMask)
+	return defaultAddress, defaultMask, nil
+}
+
+// GuestMACfromUUID derives a stable, locally administered, unicast MAC
+// address from the given UUID
+func (linux) GuestMACfromUUID(id string) (mac string, err error) {
+	var u uuid.UUID
+	if u, err = uuid.FromString(id); err != nil {
+		return
+	}
+	b := u.Bytes()[10:]
+	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x",
+		(b[0]|0x02)&0xfe, b[1], b[2], b[3], b[4], b[5]), err
+}
+
+func (linux) ExportShare(dir, network, mask string) (err error) {
+	var (
+		out       []byte
+		signature = fmt.Sprintf("%v %v/%v(rw,async,no_subtree_check,"+
+			"no_root_squash,insecure)\n", dir, network, mask)
+	)
+	if err = os.MkdirAll(filepath.Dir(exportsF), 0755); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(exportsF,
+		[]byte(WatermarkHeader+signature), 0644); err != nil {
+		return
+	}
+	if out, err = exec.Command("exportfs", "-ra").CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to refresh nfs exports ('%v')",
+			strings.TrimSpace(string(out)))
+	}
+	log.Info("'%s' now available to VMs' network via nfs", dir)
+	return
+}
+
+// SetupResolver hooks the embedded dns server into either systemd-resolved
+// or dnsmasq, whatever the host is running
+func (linux) SetupResolver(domain, port string) (err error) {
+	var (
+		target, service, contents string
+	)
+	if _, err = os.Stat("/run/systemd/resolve"); err == nil {
+		target, service = resolvedF, "systemd-resolved"
+		contents = fmt.Sprintf("[Resolve]\nDNS=127.0.0.1:%v\nDomains=~%s\n",
+			port, domain)
+	} else if _, err = os.Stat(filepath.Dir(dnsmasqF)); err == nil {
+		target, service = dnsmasqF, "dnsmasq"
+		contents = fmt.Sprintf("server=/%s/127.0.0.1#%v\n", domain, port)
+	} else {
+		return fmt.Errorf("neither systemd-resolved nor dnsmasq found, so " +
+			"host won't be able to resolve VMs by name")
+	}
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(target,
+		[]byte(WatermarkHeader+contents), 0644); err != nil {
+		return
+	}
+	return exec.Command("systemctl", "reload-or-restart", service).Run()
+}
+
+func (linux) TeardownResolver() (err error) {
+	for target, service := range map[string]string{
+		resolvedF: "systemd-resolved", dnsmasqF: "dnsmasq",
+	} {
+		if _, e := os.Stat(target); e == nil {
+			if err = os.Remove(target); err != nil {
+				return
+			}
+			err = exec.Command("systemctl", "reload-or-restart", service).Run()
+		}
+	}
+	return
+}
+
+func (linux) ForwardDNS(address, port string) (err error) {
+	var (
+		out   []byte
+		rules = fmt.Sprintf("table ip %s {\n"+
+			"  chain prerouting {\n"+
+			"    type nat hook prerouting priority -100;\n"+
+			"    iifname \"%s\" udp dport 53 dnat to %s:%s\n"+
+			"    iifname \"%s\" tcp dport 53 dnat to %s:%s\n"+
+			"  }\n"+
+			"}\n", nftablesTable, Bridge, address, port, Bridge, address, port)
+		cmd = exec.Command("nft", "-f", "-")
+	)
+	// start from a clean slate
+	exec.Command("nft", "delete", "table", "ip", nftablesTable).Run()
+
+	cmd.Stdin = strings.NewReader(WatermarkHeader + rules)
+	if out, err = cmd.CombinedOutput(); err != nil {
+		err = fmt.Errorf("unable to setup nftables dns forwarding ('%v')",
+			strings.TrimSpace(string(out)))
+	}
+	return
+}
+
+func (linux) StopForwardDNS() error {
+	return exec.Command("nft", "delete", "table", "ip", nftablesTable).Run()
+}
+
+func (linux) PhysicalMemory() (v uint64, err error) {
+	var meminfo *os.File
+	if meminfo, err = os.Open("/proc/meminfo"); err != nil {
+		return
+	}
+	defer meminfo.Close()
+
+	s := bufio.NewScanner(meminfo)
+	for s.Scan() {
+		// MemTotal:       16318036 kB
+		if f := strings.Fields(s.Text()); len(f) == 3 && f[0] == "MemTotal:" {
+			if v, err = strconv.ParseUint(f[1], 10, 64); err == nil {
+				v = v * 1024
+			}
+			return
+		}
+	}
+	return v, fmt.Errorf("unable to find MemTotal in /proc/meminfo")
+}
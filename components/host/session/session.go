@@ -19,7 +19,6 @@ import (
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
 	"os/user"
 	"path"
 	"path/filepath"
@@ -28,10 +27,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/genevera/corectl/components/host/platform"
+	"github.com/genevera/corectl/components/target"
 	"github.com/genevera/corectl/components/target/coreos"
 	"github.com/genevera/corectl/release"
 	"github.com/bugsnag/osext"
-	"github.com/deis/pkg/log"
 	"github.com/spf13/viper"
 )
 
@@ -132,6 +132,11 @@ func (ctx *Context) RunDir() string {
 	return path.Join(ctx.ConfigDir(), "/running/")
 }
 
+// LogDir keeps, for a while, the serial logs of VMs that are gone
+func (ctx *Context) LogDir() string {
+	return path.Join(ctx.ConfigDir(), "/logs/")
+}
+
 // TmpDir ...
 func (ctx *Context) TmpDir() string {
 	return path.Join(ctx.ConfigDir(), "/tmp/")
@@ -142,15 +147,57 @@ func (ctx *Context) EtcDir() string {
 	return path.Join(ctx.ConfigDir(), "/embedded.etcd/")
 }
 
+// ControlSocket is where corectld's control API is served locally
+func (ctx *Context) ControlSocket() string {
+	return path.Join(ctx.ConfigDir(), "corectld.sock")
+}
+
+// TLSDir holds corectld's CA, server and (owner's) client certificates
+func (ctx *Context) TLSDir() string {
+	return path.Join(ctx.ConfigDir(), "tls")
+}
+
+// ClientTLSDir is where corectl looks for the client certificate (and CA)
+// with which to reach a remote corectld
+func (ctx *Context) ClientTLSDir() string {
+	if d := ctx.CmdLine.GetString("tls-dir"); d != "" {
+		return d
+	}
+	return ctx.TLSDir()
+}
+
+// RemoteServer tells whether corectld is to be reached over the network
+func (ctx *Context) RemoteServer() bool {
+	host, _, _ := net.SplitHostPort(ctx.ServerAddress)
+	return !(host == "" || host == "localhost" || net.ParseIP(host).IsLoopback())
+}
+
 // NormalizeOnDiskLayout ...
 func (ctx *Context) NormalizeOnDiskLayout() (err error) {
-	// first run
+	// images used not to be namespaced by target, as only CoreOS was around
 	for _, i := range coreos.Channels {
-		if err =
-			os.MkdirAll(path.Join(ctx.ImageStore(), i),
-				0755); err != nil {
+		legacy := path.Join(ctx.ImageStore(), i)
+		if _, err = os.Stat(legacy); err != nil {
+			continue
+		}
+		if err = os.MkdirAll(path.Join(ctx.ImageStore(), target.Default),
+			0755); err != nil {
 			return
 		}
+		if err = os.Rename(legacy,
+			path.Join(ctx.ImageStore(), target.Default, i)); err != nil {
+			return
+		}
+	}
+	// first run
+	for _, t := range target.All() {
+		for _, i := range t.Channels() {
+			if err =
+				os.MkdirAll(path.Join(ctx.ImageStore(), t.Name(), i),
+					0755); err != nil {
+				return
+			}
+		}
 	}
 	for _, i := range []string{ctx.RunDir(), ctx.TmpDir(), ctx.EtcDir()} {
 		if err = os.MkdirAll(i, 0755); err != nil {
@@ -180,32 +227,7 @@ func (ctx *Context) NormalizeOnDiskLayout() (err error) {
 }
 
 func (ctx *Network) SetContext() (err error) {
-	var (
-		netMask, netAddress []byte
-		cmdL                = []string{
-			"defaults", "read",
-			"/Library/Preferences/SystemConfiguration/com.apple.vmnet.plist",
-		}
-	)
-	if netAddress, err = exec.Command(cmdL[0],
-		append(cmdL[1:], "Shared_Net_Address")...).Output(); err != nil {
-		err = nil
-		log.Warn("%v \"%v %v %v\" %v ...",
-			"unable to run", cmdL[0], cmdL[1], cmdL[2], "Shared_Net_Address")
-		log.Warn("... assuming macOS default value (192.168.64.1)")
-		netAddress = []byte("192.168.64.1")
-	}
-
-	if netMask, err = exec.Command(cmdL[0],
-		append(cmdL[1:], "Shared_Net_Mask")...).Output(); err != nil {
-		err = nil
-		log.Warn("%v \"%v %v %v\" %v ...",
-			"unable to run", cmdL[0], cmdL[1], cmdL[2], "Shared_Net_Mask")
-		log.Warn("... assuming macOS default value (255.255.255.0)")
-		netMask = []byte("255.255.255.0")
-	}
-	ctx.Address = strings.TrimSpace(string(netAddress))
-	ctx.Mask = strings.TrimSpace(string(netMask))
+	ctx.Address, ctx.Mask, err = platform.Host.Network()
 	return
 }
 
@@ -0,0 +1,168 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/host/platform"
+	"github.com/genevera/corectl/components/host/session"
+)
+
+var (
+	// MemoryOvercommit is the share of the host's physical memory that all
+	// VMs, together, may be given
+	MemoryOvercommit = 0.66
+	// CPUOvercommit is how many vCPUs, all VMs together, may be given per
+	// host CPU
+	CPUOvercommit = 2.0
+	// QuotaMemory caps, when set, how much RAM (in MB) each owner's VMs may
+	// add up to
+	QuotaMemory = 0
+	// QuotaCPUs caps, when set, how many vCPUs each owner's VMs may add up to
+	QuotaCPUs = 0
+)
+
+// hostCapacity is how much RAM (in MB) and how many vCPUs corectld may hand
+// out to VMs overall
+func hostCapacity() (memory, cpus int, err error) {
+	var physical uint64
+	if physical, err = platform.Host.PhysicalMemory(); err != nil {
+		return
+	}
+	memory = int(float64(physical/1024/1024) * MemoryOvercommit)
+	cpus = int(float64(runtime.NumCPU()) * CPUOvercommit)
+	return
+}
+
+// ownerOf tells who's asking for the given request: remote clients are
+// known by their certificate's name, local ones are corectld's owner
+func ownerOf(r *http.Request) string {
+	if r != nil && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return session.Caller.Username
+}
+
+// mayManage checks that whoever's asking for the given request may act on
+// the VM named so, owned by owner. Local clients, and remote ones
+// authenticated as corectld's owner, get to act on every VM while other
+// remote clients only get to act on their own.
+func mayManage(r *http.Request, name, owner string) error {
+	who := restrictedTo(r)
+	if who == "" {
+		return nil
+	}
+	if owner == "" {
+		owner = session.Caller.Username
+	}
+	if who != owner {
+		return api.Errorf(api.ErrCodeForbidden, "'%v' belongs to %v, not "+
+			"to %v", name, owner, who)
+	}
+	return nil
+}
+
+// restrictedTo returns whom the request is restricted to, as far as VMs go,
+// if anyone: that is remote clients other than corectld's owner
+func restrictedTo(r *http.Request) string {
+	if r == nil || r.TLS == nil {
+		return ""
+	}
+	if who := ownerOf(r); who != session.Caller.Username {
+		return who
+	}
+	return ""
+}
+
+// ownedBy keeps, out of the given VMs, the ones the request may act on
+func (vms VMs) ownedBy(r *http.Request) (owned VMs) {
+	for _, vm := range vms {
+		if mayManage(r, vm.Name, vm.Owner) == nil {
+			owned = append(owned, vm)
+		}
+	}
+	return
+}
+
+// admit checks that there's still room, both host wide and within its
+// owner's quota, for the given VM. As the VMs already registered include
+// the ones still booting, those hold their share all along.
+// Daemon's lock is to be held by the caller.
+func (vm *VMInfo) admit() (err error) {
+	var (
+		capMemory, capCPUs int
+		memory, cpus       int
+		ownMemory, ownCPUs int
+	)
+	if capMemory, capCPUs, err = hostCapacity(); err != nil {
+		return fmt.Errorf("unable to find host's capacity (%v)", err)
+	}
+	for _, v := range Daemon.Active {
+		memory, cpus = memory+v.Memory, cpus+v.Cpus
+		if v.Owner == vm.Owner {
+			ownMemory, ownCPUs = ownMemory+v.Memory, ownCPUs+v.Cpus
+		}
+	}
+	if memory+vm.Memory > capMemory {
+		return api.Errorf(api.ErrCodeNoCapacity, "'%v' asks for %vMB of "+
+			"RAM but only %vMB, of the %vMB handed out to VMs (%v%% of "+
+			"host's memory), are left", vm.Name, vm.Memory,
+			capMemory-memory, capMemory, int(MemoryOvercommit*100))
+	}
+	if cpus+vm.Cpus > capCPUs {
+		return api.Errorf(api.ErrCodeNoCapacity, "'%v' asks for %v vCPUs "+
+			"but only %v, of the %v handed out to VMs (%v per host CPU), "+
+			"are left", vm.Name, vm.Cpus, capCPUs-cpus, capCPUs,
+			CPUOvercommit)
+	}
+	if QuotaMemory > 0 && ownMemory+vm.Memory > QuotaMemory {
+		return api.Errorf(api.ErrCodeNoCapacity, "'%v' asks for %vMB of "+
+			"RAM but %v's VMs already use %vMB out of a %vMB quota",
+			vm.Name, vm.Memory, vm.Owner, ownMemory, QuotaMemory)
+	}
+	if QuotaCPUs > 0 && ownCPUs+vm.Cpus > QuotaCPUs {
+		return api.Errorf(api.ErrCodeNoCapacity, "'%v' asks for %v vCPUs "+
+			"but %v's VMs already use %v out of a %v vCPUs quota",
+			vm.Name, vm.Cpus, vm.Owner, ownCPUs, QuotaCPUs)
+	}
+	return
+}
+
+// reserve admits and registers the VM in one go, so that concurrent boots
+// can't both be granted the same resources
+func (vm *VMInfo) reserve() (err error) {
+	Daemon.Lock()
+	defer Daemon.Unlock()
+
+	if err = vm.VolumesInUse(Daemon.Active.wire()); err != nil {
+		return api.Errorf(api.ErrCodeConflict, "%v", err)
+	}
+	if err = vm.admit(); err != nil {
+		return
+	}
+	return vm.register()
+}
+
+// release gives back what was reserved for a VM that didn't get to boot
+func (vm *VMInfo) release() {
+	Daemon.Lock()
+	vm.deregister()
+	Daemon.Unlock()
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/host/session"
+	"github.com/genevera/corectl/components/target"
+	"github.com/gorilla/mux"
+)
+
+// httpExport streams, as a tarball, the given image along with its
+// manifest and (for verified images) the signed DIGESTS it was checked
+// against, so that it can be imported elsewhere without network access
+func httpExport(w http.ResponseWriter, r *http.Request) {
+	var (
+		err  error
+		m    *api.ImageManifest
+		vars = mux.Vars(r)
+	)
+	if err = api.ValidImage(vars["target"], vars["channel"],
+		vars["version"]); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	t, _ := target.Get(vars["target"])
+	kernel, initrd, digests := t.Artifacts()
+	dir := filepath.Join(imageStore(t), vars["channel"], vars["version"])
+	files := []string{api.ManifestFile, kernel, initrd}
+
+	if _, err = os.Stat(dir); err != nil {
+		http.Error(w, ErrUnknownImage.Error(), httpStatus(ErrUnknownImage))
+		return
+	}
+	if m, err = readManifest(dir); err != nil {
+		err = api.Errorf(api.ErrCodeConflict, "%s/%s predates image "+
+			"manifests, so it can't be exported. Please re-pull it "+
+			"(with --force) first", vars["channel"], vars["version"])
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	if m.Verified {
+		files = append(files, digests)
+	}
+	for _, f := range files {
+		if _, err = os.Stat(filepath.Join(dir, f)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		if err = tarFile(tw, filepath.Join(dir, f)); err != nil {
+			// too late to tell the client other than by cutting it short
+			log.Err("exporting %s/%s: %v", m.Channel, m.Version, err)
+			return
+		}
+	}
+	if err = tw.Close(); err != nil {
+		log.Err("exporting %s/%s: %v", m.Channel, m.Version, err)
+	}
+}
+
+func tarFile(tw *tar.Writer, file string) (err error) {
+	var (
+		f  *os.File
+		fi os.FileInfo
+	)
+	if f, err = os.Open(file); err != nil {
+		return
+	}
+	defer f.Close()
+	if fi, err = f.Stat(); err != nil {
+		return
+	}
+	if err = tw.WriteHeader(&tar.Header{
+		Name:     fi.Name(),
+		Mode:     0644,
+		Size:     fi.Size(),
+		ModTime:  fi.ModTime(),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return
+	}
+	_, err = io.Copy(tw, f)
+	return
+}
+
+// httpImport takes an image bundle, as produced by httpExport (or built by
+// corectl out of locally built kernels and initrds), checks it and adds it
+// to the image store
+func httpImport(w http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		staging string
+		m       *api.ImageManifest
+		force   = r.URL.Query().Get("force") == "true"
+	)
+	if staging, err = ioutil.TempDir(session.Caller.TmpDir(),
+		"import"); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(staging)
+
+	if m, err = unbundle(r.Body, staging); err == nil {
+		err = verifyBundle(m, staging)
+	}
+	if err != nil {
+		log.Debug("import: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	t, _ := target.Get(m.Target)
+
+	pulls.Lock()
+	if _, err = os.Stat(filepath.Join(imageStore(t), m.Channel,
+		m.Version)); err == nil && !force {
+		err = api.Errorf(api.ErrCodeConflict, "%s %s/%s is already "+
+			"available locally", t.Name(), m.Channel, m.Version)
+	} else {
+		err = publish(staging, m)
+	}
+	pulls.Unlock()
+	if err == nil {
+		err = refreshImages(t)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// unbundle extracts into dir the given image bundle, whose manifest is
+// expected to come first, refusing anything but the files it is supposed
+// to carry
+func unbundle(r io.Reader, dir string) (m *api.ImageManifest, err error) {
+	var (
+		hdr     *tar.Header
+		f       *os.File
+		allowed = map[string]bool{api.ManifestFile: true}
+		tr      = tar.NewReader(r)
+	)
+	for {
+		if hdr, err = tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, api.Invalid("malformed image bundle (%v)", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			return nil, api.Invalid("image bundles only hold regular "+
+				"files, unlike %s", hdr.Name)
+		}
+		if !allowed[hdr.Name] {
+			return nil, api.Invalid("unexpected %s in image bundle",
+				hdr.Name)
+		}
+		delete(allowed, hdr.Name)
+		if f, err = os.Create(filepath.Join(dir, hdr.Name)); err != nil {
+			return
+		}
+		_, err = io.Copy(f, tr)
+		if e := f.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			return
+		}
+		if m != nil {
+			continue
+		}
+		if m, err = readManifest(dir); err != nil {
+			return nil, api.Invalid("unreadable image manifest (%v)", err)
+		}
+		if err = m.Validate(); err != nil {
+			return
+		}
+		t, _ := target.Get(m.Target)
+		kernel, initrd, digests := t.Artifacts()
+		allowed[kernel], allowed[initrd], allowed[digests] = true, true, true
+	}
+	if m == nil {
+		return nil, api.Invalid("image bundle carries no manifest")
+	}
+	return
+}
+
+// verifyBundle checks the unbundled image in dir against its signed
+// DIGESTS, when it carries them, or else against its own manifest, which
+// is only acceptable for (unverified) images in custom channels
+func verifyBundle(m *api.ImageManifest, dir string) (err error) {
+	var (
+		raw  []byte
+		sum  string
+		sums map[string]string
+	)
+	t, _ := target.Get(m.Target)
+	kernel, initrd, digests := t.Artifacts()
+
+	if raw, err = ioutil.ReadFile(filepath.Join(dir, digests)); err == nil {
+		if sums, m.KeyID, err = verifyDigests(t, raw); err != nil {
+			return api.Invalid("%s %s/%s: %v", t.Name(), m.Channel,
+				m.Version, err)
+		}
+		m.Verified = true
+	} else if !os.IsNotExist(err) {
+		return
+	} else if m.Verified {
+		return api.Invalid("%s %s/%s claims to be verified but carries "+
+			"no signed DIGESTS", t.Name(), m.Channel, m.Version)
+	} else if target.HasChannel(t, m.Channel) {
+		return api.Invalid("unverified images can only go into custom "+
+			"channels, not into %s's %s one", t.Name(), m.Channel)
+	} else {
+		sums = m.Files
+	}
+
+	files := make(map[string]string)
+	for _, f := range []string{kernel, initrd} {
+		if _, ok := sums[f]; !ok {
+			return api.Invalid("no SHA512 hash for %s", f)
+		}
+		if sum, err = sha512sum(filepath.Join(dir, f)); err != nil {
+			if os.IsNotExist(err) {
+				err = api.Invalid("image bundle lacks %s", f)
+			}
+			return
+		}
+		if sum != sums[f] {
+			return api.Invalid("SHA512 hash verification failed for %s", f)
+		}
+		files[f] = sum
+	}
+	m.Files = files
+	if m.Created.IsZero() {
+		m.Created = time.Now()
+	}
+	return
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -115,8 +116,15 @@ func (c *SSHclient) SCoPy(source, destination, target string) (err error) {
 	return
 }
 
-func StartSSHsession(ip string, privateKey string) (c *SSHclient, err error) {
-	var secret ssh.Signer
+// StartSSHsession logs into the VM at the other end of the given connection
+func StartSSHsession(conn net.Conn, privateKey string) (c *SSHclient, err error) {
+	var (
+		secret ssh.Signer
+		sc     ssh.Conn
+		chans  <-chan ssh.NewChannel
+		reqs   <-chan *ssh.Request
+		addr   = conn.RemoteAddr().String()
+	)
 	c = &SSHclient{}
 
 	if secret, err = ssh.ParsePrivateKey(
@@ -130,9 +138,12 @@ func StartSSHsession(ip string, privateKey string) (c *SSHclient, err error) {
 		},
 	}
 
-	if c.conn, err = ssh.Dial("tcp", ip+":22", config); err != nil {
-		return c, fmt.Errorf("%s unreachable", ip+":22")
+	if sc, chans, reqs, err = ssh.NewClientConn(conn, addr,
+		config); err != nil {
+		conn.Close()
+		return c, fmt.Errorf("%s unreachable (%v)", addr, err)
 	}
+	c.conn = ssh.NewClient(sc, chans, reqs)
 
 	if c.session, err = c.conn.NewSession(); err != nil {
 		return c, fmt.Errorf("unable to create session: %s", err)
@@ -160,3 +171,44 @@ func StartSSHsession(ip string, privateKey string) (c *SSHclient, err error) {
 	}
 	return
 }
+
+// Execute runs, non interactively, the given command inside the VM at the
+// other end of the given connection, with the connection's deadline bounding
+// the whole exchange
+func Execute(conn net.Conn, privateKey, command string) (err error) {
+	var (
+		secret  ssh.Signer
+		sc      ssh.Conn
+		chans   <-chan ssh.NewChannel
+		reqs    <-chan *ssh.Request
+		client  *ssh.Client
+		session *ssh.Session
+		addr    = conn.RemoteAddr().String()
+	)
+	defer conn.Close()
+
+	if secret, err = ssh.ParsePrivateKey([]byte(privateKey)); err != nil {
+		return
+	}
+	config := &ssh.ClientConfig{
+		User: "core", Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(secret),
+		},
+	}
+	if sc, chans, reqs, err = ssh.NewClientConn(conn, addr,
+		config); err != nil {
+		return fmt.Errorf("%s unreachable (%v)", addr, err)
+	}
+	client = ssh.NewClient(sc, chans, reqs)
+	defer client.Close()
+
+	if session, err = client.NewSession(); err != nil {
+		return fmt.Errorf("unable to create session: %s", err)
+	}
+	defer session.Close()
+	if err = session.Run(command); err != nil && !strings.HasSuffix(
+		err.Error(), "exited without exit status or exit signal") {
+		return
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/deis/pkg/log"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	// how long a console reader may stall before being dropped
+	consoleWriteTimeout = 5 * time.Second
+	// how many chunks of output may be pending for a single reader
+	consoleBacklog = 64
+)
+
+// consoleBroker shares a VM's serial console among all attached clients:
+// everything the VM writes goes to all of them, while only the ones not
+// attached read-only get to write back
+type consoleBroker struct {
+	vm      *VMInfo
+	device  io.ReadWriteCloser
+	clients map[net.Conn]*consoleClient
+	sync.Mutex
+}
+
+// consoleClient is an attached client, fed the console's output by its own
+// goroutine so that a slow one can't hold back the others
+type consoleClient struct {
+	writable bool
+	out      chan []byte
+}
+
+var (
+	consoles   = make(map[string]*consoleBroker)
+	consolesMu sync.Mutex
+)
+
+// openConsole opens the VM's serial console, be it a pty or an unix socket
+func openConsole(vm *VMInfo) (device io.ReadWriteCloser, err error) {
+	var f *os.File
+
+	if vm.hypervisor().Capabilities().Console == ConsoleSocket {
+		return net.DialTimeout("unix", vm.TTY(), 5*time.Second)
+	}
+	if f, err = os.OpenFile(vm.TTY(),
+		os.O_RDWR|syscall.O_NOCTTY, 0); err != nil {
+		return
+	}
+	// as is, with no echo nor line editing on our side
+	if _, err = terminal.MakeRaw(int(f.Fd())); err != nil {
+		log.Debug("unable to set %v's console raw (%v)", vm.Name, err)
+	}
+	return f, nil
+}
+
+// attachConsole hands the given connection over to the VM's console broker,
+// which gets created on first use
+func attachConsole(vm *VMInfo, conn net.Conn, writable bool) (err error) {
+	consolesMu.Lock()
+	defer consolesMu.Unlock()
+
+	b, ok := consoles[vm.UUID]
+	if !ok {
+		b = &consoleBroker{vm: vm,
+			clients: make(map[net.Conn]*consoleClient)}
+		if b.device, err = openConsole(vm); err != nil {
+			return
+		}
+		consoles[vm.UUID] = b
+		go b.pump()
+	}
+	c := &consoleClient{writable: writable,
+		out: make(chan []byte, consoleBacklog)}
+	b.Lock()
+	b.clients[conn] = c
+	b.Unlock()
+	go b.feed(conn, c.out)
+	go b.serve(conn, writable)
+	return
+}
+
+// drop forgets about the client, closing it. Must hold b's lock.
+func (b *consoleBroker) drop(conn net.Conn) {
+	if c, ok := b.clients[conn]; ok {
+		delete(b.clients, conn)
+		close(c.out)
+	}
+	conn.Close()
+}
+
+// feed writes the console's output to the client, until it gets dropped
+func (b *consoleBroker) feed(conn net.Conn, out <-chan []byte) {
+	for chunk := range out {
+		conn.SetWriteDeadline(time.Now().Add(consoleWriteTimeout))
+		if _, err := conn.Write(chunk); err != nil {
+			log.Debug("dropping a lagging %v console reader", b.vm.Name)
+			conn.Close()
+			// serve's pending read fails, detaching the client
+			return
+		}
+	}
+}
+
+// pump copies whatever the VM writes to its console to all attached clients
+func (b *consoleBroker) pump() {
+	var (
+		buf    = make([]byte, 4096)
+		device = b.device
+	)
+	for {
+		n, err := device.Read(buf)
+		if n > 0 {
+			chunk := append([]byte(nil), buf[:n]...)
+			b.Lock()
+			for conn, c := range b.clients {
+				select {
+				case c.out <- chunk:
+				default:
+					log.Debug("dropping a lagging %v console reader",
+						b.vm.Name)
+					b.drop(conn)
+				}
+			}
+			b.Unlock()
+		}
+		if err != nil {
+			break
+		}
+	}
+	b.close()
+}
+
+// serve forwards what the client types to the VM, if allowed to, until it
+// detaches
+func (b *consoleBroker) serve(conn net.Conn, writable bool) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 && writable {
+			werr := io.ErrClosedPipe
+			// written to without holding b's lock, as that blocks for as
+			// long as the VM isn't reading its input (say, when paused)
+			b.Lock()
+			device := b.device
+			b.Unlock()
+			if device != nil {
+				_, werr = device.Write(buf[:n])
+			}
+			if werr != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	b.detach(conn)
+}
+
+// detach drops the client, letting go of the console once no one's left
+func (b *consoleBroker) detach(conn net.Conn) {
+	// decided along with attachConsole's lock held, so no new client can
+	// sneak in on a broker that's about to go away
+	var device io.ReadWriteCloser
+
+	consolesMu.Lock()
+	b.Lock()
+	b.drop(conn)
+	if len(b.clients) == 0 && consoles[b.vm.UUID] == b {
+		delete(consoles, b.vm.UUID)
+		device, b.device = b.device, nil
+	}
+	b.Unlock()
+	consolesMu.Unlock()
+	if device != nil {
+		device.Close()
+	}
+}
+
+// close releases the console, along with all clients still attached
+func (b *consoleBroker) close() {
+	consolesMu.Lock()
+	if consoles[b.vm.UUID] == b {
+		delete(consoles, b.vm.UUID)
+	}
+	consolesMu.Unlock()
+
+	b.Lock()
+	for conn := range b.clients {
+		b.drop(conn)
+	}
+	device := b.device
+	b.device = nil
+	b.Unlock()
+	if device != nil {
+		device.Close()
+	}
+}
+
+// httpConsole hijacks the request's connection, attaching it to the VM's
+// serial console (?readonly=true to just watch)
+func httpConsole(w http.ResponseWriter, r *http.Request) {
+	var (
+		vm       *VMInfo
+		ok       bool
+		hijacker http.Hijacker
+		client   net.Conn
+		err      error
+		writable = r.URL.Query().Get("readonly") != "true"
+	)
+	Daemon.Lock()
+	vm, ok = Daemon.Active[mux.Vars(r)["uuid"]]
+	Daemon.Unlock()
+	if !ok {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+	if err = mayManage(r, vm.Name, vm.Owner); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	if hijacker, ok = w.(http.Hijacker); !ok {
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+	if client, _, err = hijacker.Hijack(); err != nil {
+		return
+	}
+	io.WriteString(client, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Connection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	if err = attachConsole(vm, client, writable); err != nil {
+		fmt.Fprintf(client, "unable to attach to %v's console (%v)\r\n",
+			vm.Name, err)
+		client.Close()
+		return
+	}
+	log.Debug("%v attached to %v's console (writable: %v)", r.RemoteAddr,
+		vm.Name, writable)
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/host/session"
+	"github.com/gorilla/mux"
+)
+
+// controlServices are the ones meant to be consumed by corectl, as opposed
+// to httpServices which are the ones meant to be consumed by the VMs
+var controlServices = mux.NewRouter()
+
+// peerCredListener only lets through connections coming from either root or
+// the user owning the corectld session
+type peerCredListener struct {
+	net.Listener
+	owner int
+}
+
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return c, err
+		}
+		uid, err := peerUID(c.(*net.UnixConn))
+		if err == nil && (uid == 0 || uid == l.owner) {
+			return c, nil
+		}
+		log.Warn("refused control connection from uid %v (%v)", uid, err)
+		c.Close()
+	}
+}
+
+// listenControl binds corectld's control socket, making it reachable
+// only by its owner
+func listenControl() (l net.Listener, err error) {
+	var (
+		uid, gid int
+		sock     = session.Caller.ControlSocket()
+	)
+	if uid, err = strconv.Atoi(session.Caller.Uid); err != nil {
+		return
+	}
+	if gid, err = strconv.Atoi(session.Caller.Gid); err != nil {
+		return
+	}
+	// left behind by a previous session
+	if err = os.Remove(sock); err != nil && !os.IsNotExist(err) {
+		return
+	}
+	if l, err = net.Listen("unix", sock); err != nil {
+		return
+	}
+	if err = os.Chown(sock, uid, gid); err == nil {
+		err = os.Chmod(sock, 0600)
+	}
+	if err != nil {
+		l.Close()
+		return
+	}
+	return &peerCredListener{l, uid}, err
+}
+
+// listenRemote binds, on all interfaces, the mutual TLS endpoint through
+// which remote clients reach the control services
+func listenRemote() (l net.Listener, err error) {
+	var cfg *tls.Config
+
+	if cfg, err = serverTLSconfig(); err != nil {
+		return
+	}
+	return tls.Listen("tcp", ":"+RemotePort, cfg)
+}
+
+// httpTunnel hijacks the request's connection, splicing it to the VM's ssh
+// port. That's how remote clients get to ssh (and put) into the VMs.
+func httpTunnel(w http.ResponseWriter, r *http.Request) {
+	var (
+		vm       *VMInfo
+		ok       bool
+		hijacker http.Hijacker
+		upstream net.Conn
+		client   net.Conn
+		err      error
+		paused   bool
+	)
+	Daemon.Lock()
+	if vm, ok = Daemon.Active[mux.Vars(r)["uuid"]]; ok {
+		paused = vm.Paused
+	}
+	Daemon.Unlock()
+	if !ok || vm.PublicIP == "" {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+	if err = mayManage(r, vm.Name, vm.Owner); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	if paused {
+		http.Error(w, fmt.Sprintf("'%v' is paused", vm.Name),
+			http.StatusConflict)
+		return
+	}
+	if hijacker, ok = w.(http.Hijacker); !ok {
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+	if upstream, err = net.DialTimeout("tcp",
+		net.JoinHostPort(vm.PublicIP, "22"), 10*time.Second); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if client, _, err = hijacker.Hijack(); err != nil {
+		upstream.Close()
+		return
+	}
+	io.WriteString(client, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Connection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	log.Debug("tunneling %v to %v's ssh", r.RemoteAddr, vm.Name)
+	go splice(client, upstream)
+}
+
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go pipe(a, b)
+	go pipe(b, a)
+	<-done
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"golang.org/x/net/context"
+
+	"github.com/coreos/etcd/client"
+	"github.com/genevera/corectl/components/api"
+)
+
+// DefinitionsRoot is where, inside the embedded etcd, VM definitions live
+const DefinitionsRoot = "/corectl/definitions"
+
+var (
+	ErrUnknownDefinition = api.Errorf(api.ErrCodeNotFound, "Request ignored as "+
+		"no VM definition with requested name was found")
+	ErrDefinitionExists = api.Errorf(api.ErrCodeConflict, "Request ignored as a "+
+		"VM definition with the same name already exists")
+)
+
+// definitionOf returns a copy of the VM stripped of everything that only
+// makes sense while it is running
+func definitionOf(vm *api.VMInfo) *api.VMInfo {
+	return &api.VMInfo{
+		Name:            vm.Name,
+		Target:          vm.Target,
+		Channel:         vm.Channel,
+		Version:         vm.Version,
+		UUID:            vm.UUID,
+		MacAddress:      vm.MacAddress,
+		Cpus:            vm.Cpus,
+		Memory:          vm.Memory,
+		Pid:             -1,
+		SSHkey:          vm.SSHkey,
+		CloudConfig:     vm.CloudConfig,
+		CClocation:      vm.CClocation,
+		AddToHypervisor: vm.AddToHypervisor,
+		AddToKernel:     vm.AddToKernel,
+		Hypervisor:      vm.Hypervisor,
+		Ethernet:        vm.Ethernet,
+		Storage:         vm.Storage,
+		SharedHomedir:   vm.SharedHomedir,
+		OfflineMode:     vm.OfflineMode,
+		FormatRoot:      vm.FormatRoot,
+		PersistentRoot:  vm.PersistentRoot,
+		Restart:         vm.Restart,
+		MaxRestarts:     vm.MaxRestarts,
+		StopTimeout:     vm.StopTimeout,
+		DependsOn:       vm.DependsOn,
+		InitrdOverlay:   vm.InitrdOverlay,
+		Owner:           vm.Owner,
+	}
+}
+
+func definitionKey(name string) string {
+	return path.Join(DefinitionsRoot, name)
+}
+
+// saveDefinition durably stores the given VM's definition, refusing to
+// overwrite an already existing one unless told otherwise
+func (d *ServerContext) saveDefinition(vm *api.VMInfo,
+	overwrite bool) (err error) {
+	var (
+		buf  []byte
+		opts = &client.SetOptions{PrevExist: client.PrevNoExist}
+	)
+	if vm.Name == "" {
+		return fmt.Errorf("VM definitions must be named")
+	}
+	if overwrite {
+		opts.PrevExist = client.PrevIgnore
+	}
+	if buf, err = json.Marshal(definitionOf(vm)); err != nil {
+		return
+	}
+	if _, err = d.EtcdClient.Set(context.Background(),
+		definitionKey(vm.Name), string(buf), opts); err != nil {
+		if e, ok := err.(client.Error); ok &&
+			e.Code == client.ErrorCodeNodeExist {
+			err = ErrDefinitionExists
+		}
+	}
+	return
+}
+
+// definition returns the VM definition stored under the given name
+func (d *ServerContext) definition(name string) (vm *api.VMInfo, err error) {
+	var resp *client.Response
+	if resp, err = d.EtcdClient.Get(context.Background(),
+		definitionKey(name), nil); err != nil {
+		if client.IsKeyNotFound(err) {
+			err = ErrUnknownDefinition
+		}
+		return
+	}
+	vm = &api.VMInfo{}
+	err = json.Unmarshal([]byte(resp.Node.Value), vm)
+	return
+}
+
+// definitions returns all stored VM definitions, indexed by name
+func (d *ServerContext) definitions() (defs api.VMmap, err error) {
+	var resp *client.Response
+
+	defs = make(api.VMmap)
+	if resp, err = d.EtcdClient.Get(context.Background(), DefinitionsRoot,
+		&client.GetOptions{Recursive: true}); err != nil {
+		if client.IsKeyNotFound(err) {
+			err = nil
+		}
+		return
+	}
+	for _, n := range resp.Node.Nodes {
+		vm := &api.VMInfo{}
+		if err = json.Unmarshal([]byte(n.Value), vm); err != nil {
+			return
+		}
+		defs[vm.Name] = vm
+	}
+	return
+}
+
+func (d *ServerContext) removeDefinition(name string) (err error) {
+	if _, err = d.EtcdClient.Delete(context.Background(),
+		definitionKey(name), nil); client.IsKeyNotFound(err) {
+		err = ErrUnknownDefinition
+	}
+	return
+}
+
+// bootedFrom returns the active VM, if any, that was booted from the given
+// definition
+func (in VMmap) bootedFrom(def *api.VMInfo) *VMInfo {
+	for _, v := range in {
+		if v.Name == def.Name || v.UUID == def.UUID {
+			return v
+		}
+	}
+	return nil
+}
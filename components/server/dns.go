@@ -21,15 +21,14 @@ package server
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"os"
-	"os/exec"
 	"strings"
 	"time"
 
 	"golang.org/x/net/context"
 
+	"github.com/genevera/corectl/components/host/platform"
 	"github.com/genevera/corectl/components/host/session"
 	backendetcd "github.com/skynetservices/skydns/backends/etcd"
 	skymetrics "github.com/skynetservices/skydns/metrics"
@@ -42,8 +41,6 @@ var (
 		"8.8.4.4:53",
 	}
 	LocalDomainName = "coreos.local"
-	WatermarkHeader = "#\n# This file is automatically generated " +
-		"and managed by corectl\n#\n"
 	EmbeddedDNSport = "15353"
 )
 
@@ -63,7 +60,6 @@ func (d *ServerContext) NewDNSServer(root,
 			Nameservers: ns,
 			MinTtl:      30,
 		}
-		resolver *os.File
 	)
 	if dnsAddress, err = net.ResolveUDPAddr("udp", serverAddress); err != nil {
 		return
@@ -96,16 +92,10 @@ func (d *ServerContext) NewDNSServer(root,
 		return
 	}
 
-	// transparently expose the embedded DNS server to the macOS host
-	os.Mkdir("/etc/resolver", 0755)
-	if resolver, err = os.Create("/etc/resolver/corectld"); err != nil {
+	// transparently expose the embedded DNS server to the host
+	if err = platform.Host.SetupResolver(root, EmbeddedDNSport); err != nil {
 		return
 	}
-	fmt.Fprintf(resolver, WatermarkHeader)
-	fmt.Fprintf(resolver,
-		"domain %s\nsearch %s\nnameserver 127.0.0.1\nport %v\n",
-		root, root, EmbeddedDNSport)
-	resolver.Close()
 	if err = d.DNSServer.PortForward(); err != nil {
 		return
 	}
@@ -114,21 +104,8 @@ func (d *ServerContext) NewDNSServer(root,
 }
 
 func (dns *DNSServer) PortForward() (err error) {
-	var pfRules *os.File
-	if pfRules, err =
-		ioutil.TempFile(session.Caller.TmpDir(), "coreos"); err != nil {
-		return
-	}
-	defer os.Remove(pfRules.Name())
-
-	fmt.Fprintf(pfRules,
-		"%s rdr pass on bridge100 inet proto { tcp udp } "+
-			"from any to any port = domain -> %s port %v\n",
-		WatermarkHeader, session.Caller.Network.Address, EmbeddedDNSport)
-	pfRules.Close()
-	exec.Command("echo", "/sbin/pfctl", "-e").Run()
-	return exec.Command("echo", "/sbin/pfctl", "-a", "corectld-forwarding-dns",
-		"-f", pfRules.Name()).Run()
+	return platform.Host.ForwardDNS(session.Caller.Network.Address,
+		EmbeddedDNSport)
 }
 
 func (dns *DNSServer) Start() {
@@ -158,9 +135,9 @@ type runner interface {
 }
 
 func teardownService() {
-	exec.Command("echo", "/sbin/pfctl", "-a", "corectld-forwarding-dns", "-Fa").Run()
+	platform.Host.StopForwardDNS()
 	Daemon.DNSServer.rmRecord("corectld", session.Caller.Network.Address)
-	os.Remove("/etc/resolver/corectld")
+	platform.Host.TeardownResolver()
 }
 
 func invertDomain(in string) (out string) {
@@ -230,5 +207,5 @@ func until(fn func() error, w io.Writer,
 	}
 }
 func pad(str string) string {
-	return fmt.Sprint("\n%s\n", str)
+	return fmt.Sprintf("\n%s\n", str)
 }
@@ -0,0 +1,226 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/api"
+)
+
+var (
+	// EventHistory is how many past events are kept around for late
+	// subscribers (see '--since')
+	EventHistory = 1024
+	// how many events a slow subscriber may lag behind before being dropped
+	eventBacklog = 256
+)
+
+type (
+	// EventFilter selects which events a subscriber gets, Owner restricting
+	// them to that owner's VMs
+	EventFilter struct {
+		Since time.Time
+		VMs   []string
+		Owner string
+	}
+
+	// EventBus fans out VM lifecycle events to whoever is listening
+	EventBus struct {
+		history     []*api.Event
+		next        int
+		subscribers map[chan *api.Event]*EventFilter
+		done        chan struct{}
+		sync.Mutex
+	}
+)
+
+func newEventBus() *EventBus {
+	return &EventBus{
+		history:     make([]*api.Event, 0, EventHistory),
+		subscribers: make(map[chan *api.Event]*EventFilter),
+		done:        make(chan struct{}),
+	}
+}
+
+func (f *EventFilter) matches(e *api.Event) bool {
+	if e.Time.Before(f.Since) {
+		return false
+	}
+	if f.Owner != "" && f.Owner != e.Owner {
+		return false
+	}
+	if len(f.VMs) == 0 {
+		return true
+	}
+	for _, v := range f.VMs {
+		if v == e.VM || v == e.UUID {
+			return true
+		}
+	}
+	return false
+}
+
+// publish records the event and hands it over to all interested subscribers,
+// never blocking on slow ones
+func (b *EventBus) publish(e *api.Event) {
+	b.Lock()
+	defer b.Unlock()
+
+	if len(b.history) < cap(b.history) {
+		b.history = append(b.history, e)
+	} else {
+		b.history[b.next] = e
+		b.next = (b.next + 1) % len(b.history)
+	}
+	for ch, f := range b.subscribers {
+		if !f.matches(e) {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+			log.Warn("dropping a lagging event subscriber")
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns the already recorded events matching the filter, and a
+// channel over which all upcoming ones will be sent
+func (b *EventBus) subscribe(f *EventFilter) (past []*api.Event,
+	ch chan *api.Event) {
+	b.Lock()
+	defer b.Unlock()
+
+	for i := range b.history {
+		if e := b.history[(b.next+i)%len(b.history)]; f.matches(e) {
+			past = append(past, e)
+		}
+	}
+	ch = make(chan *api.Event, eventBacklog)
+	b.subscribers[ch] = f
+	return
+}
+
+func (b *EventBus) unsubscribe(ch chan *api.Event) {
+	b.Lock()
+	defer b.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Close ends all ongoing subscriptions
+func (b *EventBus) Close() {
+	b.Lock()
+	defer b.Unlock()
+	close(b.done)
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (vm *VMInfo) emit(kind string, detail string) {
+	Daemon.Events.publish(&api.Event{
+		Time:   time.Now(),
+		Type:   kind,
+		VM:     vm.Name,
+		UUID:   vm.UUID,
+		Detail: detail,
+		Owner:  vm.Owner,
+	})
+}
+
+// httpEvents streams, as newline delimited JSON, the lifecycle events
+// matching the given query (?vm=X&vm=Y&since=RFC3339|duration)
+func httpEvents(w http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		past    []*api.Event
+		ch      chan *api.Event
+		flusher http.Flusher
+		ok      bool
+		f       = &EventFilter{VMs: r.URL.Query()["vm"]}
+		enc     = json.NewEncoder(w)
+	)
+	if flusher, ok = w.(http.Flusher); !ok {
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+	if f.Since, err = parseSince(r.URL.Query().Get("since")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// remote clients only get to hear about their own VMs
+	f.Owner = restrictedTo(r)
+
+	past, ch = Daemon.Events.subscribe(f)
+	defer Daemon.Events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range past {
+		if enc.Encode(e) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	for {
+		select {
+		case e, open := <-ch:
+			if !open {
+				return
+			}
+			if enc.Encode(e) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-Daemon.Events.done:
+			return
+		}
+	}
+}
+
+// parseSince accepts either an absolute timestamp (RFC3339) or a duration
+// relative to now (i.e 10m)
+func parseSince(s string) (t time.Time, err error) {
+	var d time.Duration
+
+	if s == "" {
+		// only what's upcoming
+		return time.Now(), nil
+	}
+	if t, err = time.Parse(time.RFC3339, s); err == nil {
+		return
+	}
+	if d, err = time.ParseDuration(s); err != nil {
+		err = fmt.Errorf("unable to parse '%s' as either a timestamp "+
+			"(RFC3339) or a duration", s)
+		return
+	}
+	return time.Now().Add(-d), nil
+}
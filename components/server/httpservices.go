@@ -24,8 +24,8 @@ import (
 	"strings"
 	"text/template"
 
+	"github.com/genevera/corectl/components/api"
 	"github.com/genevera/corectl/components/host/session"
-	"github.com/genevera/corectl/components/target/coreos"
 	"github.com/coreos/fuze/config"
 	"github.com/coreos/go-systemd/unit"
 	"github.com/deis/pkg/log"
@@ -43,6 +43,13 @@ type corectlTmpl struct {
 }
 
 func httpServiceSetup() {
+	controlServices.HandleFunc("/events", httpEvents)
+	controlServices.HandleFunc("/tunnel/{uuid}", httpTunnel)
+	controlServices.HandleFunc("/console/{uuid}", httpConsole)
+	controlServices.HandleFunc("/logs/{id}", httpLogs)
+	controlServices.HandleFunc("/bundles/{target}/{channel}/{version}",
+		httpExport).Methods("GET")
+	controlServices.HandleFunc("/bundles", httpImport).Methods("POST")
 	httpServices.HandleFunc("/{uuid}/ignition", httpInstanceIgnitionConfig)
 	httpServices.HandleFunc("/{uuid}/cloud-config", httpInstanceCloudConfig)
 	httpServices.HandleFunc("/{uuid}/ping", httpInstanceCallback)
@@ -88,7 +95,7 @@ func acceptableRequest(r *http.Request, w http.ResponseWriter) bool {
 func httpInstanceCloudConfig(w http.ResponseWriter, r *http.Request) {
 	if acceptableRequest(r, w) {
 		vm := Daemon.Active[mux.Vars(r)["uuid"]]
-		if vm.CloudConfig == "" || vm.CClocation != Local {
+		if vm.CloudConfig == "" || vm.CClocation != api.Local {
 			httpError(w, http.StatusPreconditionFailed)
 		} else if vm.cloudConfigContents == nil {
 			httpError(w, http.StatusInternalServerError)
@@ -130,10 +137,10 @@ func httpInstanceIgnitionConfig(w http.ResponseWriter, r *http.Request) {
 		if vm.SSHkey != "" {
 			setup.SSHAuthorizedKeys = append(setup.SSHAuthorizedKeys, vm.SSHkey)
 		}
-		if vm.CloudConfig != "" && vm.CClocation == Local {
+		if vm.CloudConfig != "" && vm.CClocation == api.Local {
 			vm.cloudConfigContents, _ = ioutil.ReadFile(vm.CloudConfig)
 		}
-		t, _ := template.New("").Parse(string(coreos.CoreOSIgnitionTmpl))
+		t, _ := template.New("").Parse(vm.target().Provisioning())
 		if err := t.Execute(&rendered, setup); err != nil {
 			log.Err("==> %v", err.Error())
 			httpError(w, http.StatusInternalServerError)
@@ -146,6 +153,7 @@ func httpInstanceIgnitionConfig(w http.ResponseWriter, r *http.Request) {
 			httpError(w, http.StatusInternalServerError)
 		} else {
 			w.Write([]byte(append(i, '\n')))
+			vm.emit(api.EventIgnitionServed, "")
 			if !isLoopback(remoteIP(r.RemoteAddr)) {
 				Daemon.DNSServer.addRecord(vm.Name, remoteIP(r.RemoteAddr))
 			}
@@ -163,7 +171,9 @@ func httpInstanceExternalConnectivity(w http.ResponseWriter, r *http.Request) {
 			vm.isolationCheck.Do(func() {
 				Daemon.Lock()
 				Daemon.Active[vm.UUID].NotIsolated = true
+				vm.persistState()
 				Daemon.Unlock()
+				vm.emit(api.EventNotIsolated, "")
 			})
 		}
 	}
@@ -179,6 +189,7 @@ func httpInstanceCallback(w http.ResponseWriter, r *http.Request) {
 				Daemon.Lock()
 				Daemon.Active[vm.UUID].publicIPCh <- remoteIP(r.RemoteAddr)
 				Daemon.Unlock()
+				vm.emit(api.EventPhoneHome, remoteIP(r.RemoteAddr))
 			})
 		}
 	}
@@ -0,0 +1,109 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/host/session"
+)
+
+// hyperkit runs VMs via (our build of) moby/hyperkit, shipped alongside
+// corectld as 'corectld.runner'
+type hyperkit struct{ processRunner }
+
+func init() {
+	registerHypervisor(hyperkit{})
+	DefaultHypervisor = "hyperkit"
+}
+
+func (hyperkit) Name() string {
+	return "hyperkit"
+}
+
+func (hyperkit) Capabilities() HypervisorCapabilities {
+	return HypervisorCapabilities{
+		Accelerator:  "hypervisor.framework",
+		Console:      ConsolePTY,
+		Tap:          true,
+		CDROM:        true,
+		RawVolumes:   true,
+		Qcow2Volumes: true,
+	}
+}
+
+func (hyperkit) BuildArgs(vm *VMInfo) (args []string, err error) {
+	var (
+		vmlinuz, initrd string
+		instr           = []string{
+			"-s", "0:0,hostbridge",
+			"-l", "com1,autopty=" + vm.TTY() + ",log=" + vm.Log(),
+			"-s", "5,virtio-rnd",
+			"-s", "31,lpc",
+			"-U", vm.UUID,
+			"-m", fmt.Sprintf("%vM", vm.Memory),
+			"-c", fmt.Sprintf("%v", vm.Cpus),
+			"-A",
+			"-u",
+		}
+	)
+	if vmlinuz, initrd, err = vm.bootImages(); err != nil {
+		return
+	}
+
+	if vm.AddToHypervisor != "" {
+		instr = append(instr, vm.AddToHypervisor)
+	}
+
+	for v, vv := range vm.Ethernet {
+		if vv.Type == api.Tap {
+			instr = append(instr, "-s",
+				fmt.Sprintf("2:%d,virtio-tap,%v", v, vv.Path))
+		} else {
+			instr = append(instr, "-s",
+				fmt.Sprintf("2:%d,virtio-net", v))
+		}
+	}
+
+	for _, v := range vm.Storage.CDDrives {
+		instr = append(instr, "-s", fmt.Sprintf("3:%d,ahci-cd,%s",
+			v.Slot, v.Path))
+	}
+
+	for _, v := range vm.Storage.HardDrives {
+		switch v.Format {
+		case api.Raw:
+			instr = append(instr, "-s", fmt.Sprintf("4:%d,virtio-blk,%s",
+				v.Slot, v.Path))
+		case api.Qcow2:
+			instr = append(instr, "-s",
+				fmt.Sprintf("4:%d,virtio-blk,file://%s,format=qcow",
+					v.Slot, v.Path))
+		}
+	}
+
+	return append(strings.Split(strings.Join(instr, " "), " "), "-f",
+		fmt.Sprintf("kexec,%s,%s,%s", vmlinuz, initrd,
+			vm.kernelCmdline())), err
+}
+
+func (h hyperkit) Start(vm *VMInfo, args []string) error {
+	return h.start(vm,
+		filepath.Join(session.ExecutableFolder(), "corectld.runner"), args)
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/genevera/corectl/components/api"
+)
+
+type (
+	// Hypervisor is the backend in charge of actually running the VMs
+	Hypervisor interface {
+		// Name returns the name under which the backend is known
+		Name() string
+		// Capabilities returns what the backend is able to provide
+		Capabilities() HypervisorCapabilities
+		// BuildArgs assembles the runner's command line for the given VM
+		BuildArgs(vm *VMInfo) ([]string, error)
+		// Start launches the runner for the given VM
+		Start(vm *VMInfo, args []string) error
+		// Signal delivers a signal to the given VM's runner
+		Signal(vm *VMInfo, sig os.Signal) error
+		// Wait blocks until the given VM's runner is gone
+		Wait(vm *VMInfo) error
+		// Pause suspends the given VM's runner, Resume brings it back
+		Pause(vm *VMInfo) error
+		Resume(vm *VMInfo) error
+	}
+	// HypervisorCapabilities ...
+	HypervisorCapabilities struct {
+		Accelerator                          string
+		Console                              string
+		Tap, CDROM, RawVolumes, Qcow2Volumes bool
+		Monitor                              bool
+	}
+)
+
+const (
+	// ConsolePTY means the VM's serial console is exposed as a pty
+	ConsolePTY = "pty"
+	// ConsoleSocket means the VM's serial console is exposed as an unix socket
+	ConsoleSocket = "socket"
+)
+
+var (
+	// DefaultHypervisor is the backend used when a VM doesn't ask for one
+	DefaultHypervisor = "qemu"
+
+	hypervisors = make(map[string]Hypervisor)
+)
+
+func registerHypervisor(h Hypervisor) {
+	hypervisors[h.Name()] = h
+}
+
+// Hypervisors returns the names of all the supported backends
+func Hypervisors() (names []string) {
+	for name := range hypervisors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// LookupHypervisor returns the backend known by the given name
+func LookupHypervisor(name string) (h Hypervisor, err error) {
+	var ok bool
+	if h, ok = hypervisors[name]; !ok {
+		err = fmt.Errorf("'%s' is not a supported hypervisor (%v)",
+			name, Hypervisors())
+	}
+	return
+}
+
+// supports checks that the given VM only asks for things that the backend
+// is able to provide
+func (caps HypervisorCapabilities) supports(vm *VMInfo) (err error) {
+	for _, v := range vm.Ethernet {
+		if v.Type == api.Tap && !caps.Tap {
+			return fmt.Errorf("tap interfaces aren't supported by %s",
+				vm.Hypervisor)
+		}
+	}
+	if len(vm.Storage.CDDrives) > 0 && !caps.CDROM {
+		return fmt.Errorf("cdroms aren't supported by %s", vm.Hypervisor)
+	}
+	for _, v := range vm.Storage.HardDrives {
+		if (v.Format == api.Raw && !caps.RawVolumes) ||
+			(v.Format == api.Qcow2 && !caps.Qcow2Volumes) {
+			return fmt.Errorf("'%s' is in a volume format not supported "+
+				"by %s", v.Path, vm.Hypervisor)
+		}
+	}
+	return
+}
+
+// processRunner holds what is common to the backends that run each VM as a
+// standalone host process
+type processRunner struct{}
+
+func (processRunner) start(vm *VMInfo, binary string, args []string) (err error) {
+	vm.exec = exec.Command(binary, args...)
+	vm.exec.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+		Setsid:  false,
+		Pgid:    0,
+	}
+	if err = vm.exec.Start(); err == nil {
+		vm.process, vm.runner = vm.exec.Process, filepath.Base(binary)
+	}
+	return
+}
+
+func (processRunner) Signal(vm *VMInfo, sig os.Signal) error {
+	if vm.process == nil {
+		return fmt.Errorf("%v has no runner attached", vm.Name)
+	}
+	return vm.process.Signal(sig)
+}
+
+// Pause stops the runner in its tracks, which is all it takes for backends
+// that don't know better
+func (p processRunner) Pause(vm *VMInfo) error {
+	return p.Signal(vm, syscall.SIGSTOP)
+}
+
+func (p processRunner) Resume(vm *VMInfo) error {
+	return p.Signal(vm, syscall.SIGCONT)
+}
+
+// Wait blocks until the runner exits. As runners adopted from a previous
+// corectld session aren't our children we can only poll for those, and
+// never get to know their exit status. Unless we halted them ourselves,
+// their going away is therefore taken as a failure.
+func (processRunner) Wait(vm *VMInfo) (err error) {
+	if vm.exec != nil {
+		return vm.exec.Wait()
+	}
+	if vm.process == nil {
+		return fmt.Errorf("%v has no runner attached", vm.Name)
+	}
+	for {
+		if err = vm.process.Signal(syscall.Signal(0)); err != nil {
+			if vm.halting() {
+				return nil
+			}
+			return fmt.Errorf("runner of adopted VM exited (status unknown)")
+		}
+		time.Sleep(time.Second)
+	}
+}
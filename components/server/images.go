@@ -18,24 +18,28 @@ package server
 import (
 	"bytes"
 	"crypto/sha512"
-	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
-	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/clearsign"
 
+	"github.com/genevera/corectl/components/api"
 	"github.com/genevera/corectl/components/host/session"
+	"github.com/genevera/corectl/components/target"
 	"github.com/genevera/corectl/components/target/coreos"
 
 	"github.com/deis/pkg/log"
@@ -44,63 +48,75 @@ import (
 	"github.com/blang/semver"
 )
 
-type timeSlice []time.Time
+// imageStore is where the given target's images are kept
+func imageStore(t target.Target) string {
+	return filepath.Join(session.Caller.ImageStore(), t.Name())
+}
+
+// allImages returns, indexed by target, all locally available images
+func allImages() (all map[string]MediaAssets, err error) {
+	all = make(map[string]MediaAssets)
+	for _, t := range target.All() {
+		if all[t.Name()], err = localImages(t); err != nil {
+			return
+		}
+	}
+	return
+}
 
-func (s timeSlice) Less(i, j int) bool { return s[i].Before(s[j]) }
-func (s timeSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
-func (s timeSlice) Len() int           { return len(s) }
+// channels returns t's upstream channels, along with the custom ones found
+// in the image store
+func channels(t target.Target) (all []string, err error) {
+	var dirs []os.FileInfo
+
+	all = append(all, t.Channels()...)
+	if dirs, err = ioutil.ReadDir(imageStore(t)); err != nil {
+		return
+	}
+	for _, d := range dirs {
+		if d.IsDir() && !target.HasChannel(t, d.Name()) &&
+			target.ValidChannel(t, d.Name()) == nil {
+			all = append(all, d.Name())
+		}
+	}
+	return
+}
 
-func localImages() (local map[string]semver.Versions, err error) {
-	releasesStore := []os.FileInfo{}
-	local = make(map[string]semver.Versions, 0)
+// localImages returns, indexed by channel, t's images that look usable,
+// merely ignoring (and leaving alone) the others. Whether their artifacts
+// are still intact is for verifyImage to tell.
+func localImages(t target.Target) (local MediaAssets, err error) {
+	var (
+		chans    []string
+		releases []os.FileInfo
+	)
+	local = make(MediaAssets, 0)
 
-	for _, channel := range coreos.Channels {
-		dir := path.Join(session.Caller.ImageStore(), channel)
+	if chans, err = channels(t); err != nil {
+		return
+	}
+	for _, channel := range chans {
+		dir := path.Join(imageStore(t), channel)
 		all := semver.Versions{}
 
-		if releasesStore, err = ioutil.ReadDir(dir); err != nil {
+		if releases, err = ioutil.ReadDir(dir); err != nil {
 			return
 		}
-
-		for _, rev := range releasesStore {
-			if rev.IsDir() {
-				var (
-					ok bool
-					fm os.FileInfo
-				)
-				lastMod := make([]time.Time, 3)
-
-				for cnt, f := range []string{".vmlinuz", "_image.cpio.gz"} {
-					fn := path.Join(dir, rev.Name(), "coreos_production_pxe"+f)
-					if fm, err = os.Stat(fn); err != nil {
-						ok = false
-						log.Warn("%v missing - %v/%v ignored",
-							fn, channel, rev.Name())
-						break
-					}
-					ok = true
-					lastMod[cnt] = fm.ModTime()
-				}
-				// we pick as baseline the most recent modTime...
-				lastMod[2] = rev.ModTime()
-				sort.Sort(timeSlice(lastMod))
-				if ok && lastMod[2].After(coreos.LatestImageBreackage()) {
-					v := semver.Version{}
-					if v, err = semver.Make(rev.Name()); err != nil {
-						return
-					}
-					all = append(all, v)
-				} else {
-					if err =
-						os.RemoveAll(path.Join(dir, rev.Name())); err != nil {
-						return
-					}
-					if ok {
-						log.Warn("%v/%v ignored as it will need to be rebuilt",
-							channel, rev.Name())
-					}
-				}
+		for _, rev := range releases {
+			if !rev.IsDir() {
+				continue
+			}
+			v, e := semver.Make(rev.Name())
+			if e != nil {
+				log.Warn("%s/%s ignored, as not a version", channel,
+					rev.Name())
+				continue
+			}
+			if e = usableImage(t, path.Join(dir, rev.Name())); e != nil {
+				log.Warn("%s/%s ignored (%v)", channel, rev.Name(), e)
+				continue
 			}
+			all = append(all, v)
 		}
 		semver.Sort(all)
 		local[channel] = all
@@ -108,32 +124,117 @@ func localImages() (local map[string]semver.Versions, err error) {
 	return
 }
 
-// PullImage ...
-func PullImage(channel, version string,
-	override, preferLocal bool) (v string, err error) {
+// usableImage tells whether the image in dir has all it takes to boot,
+// and isn't older than its target's last breakage
+func usableImage(t target.Target, dir string) (err error) {
+	var (
+		m       *api.ImageManifest
+		fi      os.FileInfo
+		created time.Time
+	)
+	kernel, initrd, _ := t.Artifacts()
+
+	for _, f := range []string{kernel, initrd} {
+		if fi, err = os.Stat(path.Join(dir, f)); err != nil {
+			return fmt.Errorf("%s missing", f)
+		}
+		// images predating manifests only have this much to go by
+		if fi.ModTime().After(created) {
+			created = fi.ModTime()
+		}
+	}
+	if m, err = readManifest(dir); err == nil {
+		created = m.Created
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("unreadable manifest (%v)", err)
+	}
+	if !created.After(t.Breakage()) {
+		return fmt.Errorf("needs to be re-pulled, as it no longer boots")
+	}
+	return nil
+}
+
+// pulls serializes image downloads, as concurrent ones would step on each
+// other's toes
+var pulls sync.Mutex
+
+// ImageSources are where images are fetched from, in fallback order, before
+// resorting to the targets' own release servers
+var ImageSources []target.Source
+
+// sourcesFor returns, in the order they are to be tried, the roots from
+// which the given channel's images can be fetched. The ones requested
+// take precedence over corectld's own, and the target's release server
+// comes last.
+func sourcesFor(t target.Target, channel string,
+	requested []target.Source) (roots []string) {
+	for _, s := range append(append(
+		target.SourcesFor(t, channel, requested),
+		target.SourcesFor(t, channel, ImageSources)...),
+		target.Source{URL: t.Upstream()}) {
+		roots = append(roots, s.Root(channel))
+	}
+	return
+}
+
+// latestUpstream returns the version currently shipping in the given
+// channel, as told by the first source that answers
+func latestUpstream(t target.Target,
+	roots []string) (latest string, err error) {
+	for _, root := range roots {
+		if latest, err = t.LatestUpstream(downloader,
+			root); err == nil && len(latest) > 0 {
+			return
+		}
+		log.Debug("unable to find latest version at %s (%v)", root, err)
+	}
+	return "", fmt.Errorf("no image source answered")
+}
+
+// pullImage makes the given image locally available, fetching it from
+// upstream if needed (or told to)
+func pullImage(t target.Target, channel, version string,
+	override, preferLocal bool,
+	requested []target.Source) (v string, err error) {
 	var (
 		available   bool
-		allChannels map[string]semver.Versions
+		allChannels MediaAssets
 		latest      string
+		roots       = sourcesFor(t, channel, requested)
 	)
 
-	if allChannels, err = localImages(); err != nil {
+	pulls.Lock()
+	defer pulls.Unlock()
+
+	if allChannels, err = localImages(t); err != nil {
 		return version, err
 	}
 	local := allChannels[channel]
+	if !target.HasChannel(t, channel) {
+		// custom channels only ever hold what was imported into them
+		if version == "latest" && len(local) > 0 {
+			version = local[local.Len()-1].String()
+		}
+		for _, i := range local {
+			if version == i.String() {
+				return version, err
+			}
+		}
+		return version, fmt.Errorf("%s %s/%s isn't available locally (custom "+
+			"channels' images can only be imported)", t.Name(), channel, version)
+	}
 	if version == "latest" {
 		if preferLocal == true && len(local) > 0 {
 			version = local[local.Len()-1].String()
 		} else {
-			if latest, err =
-				coreos.LatestUpstream(channel); err != nil || len(latest) == 0 {
+			if latest, err = latestUpstream(t, roots); err != nil {
 				// as we're probably offline
 				if len(local) == 0 {
 					err = fmt.Errorf("offline and not a single locally image"+
 						"available for '%s' channel", channel)
 					return
 				}
-				version = local[local.Len()-1].String()
+				version, err = local[local.Len()-1].String(), nil
 			} else {
 				version = latest
 			}
@@ -146,178 +247,512 @@ func PullImage(channel, version string,
 			break
 		}
 	}
-	if available {
-		if !override {
-			log.Debug("%s/%s already available on your system", channel, version)
-			return version, err
-		} else {
-			// tell server that this image become unavailable in the meantime
-			if _, err = RPCQuery("RemoveImage", &RPCquery{
-				Input: []string{channel, version}}); err != nil {
-				return
-			}
+	if available && !override {
+		log.Debug("%s/%s already available on your system", channel, version)
+		return version, err
+	}
+	if v, err = localize(t, channel, version, roots); err != nil {
+		return
+	}
+	if err = refreshImages(t); err == nil &&
+		PruneAfterPull && !ImageRetention.IsZero() {
+		if _, _, e := pruneImages(t, ImageRetention, false); e != nil {
+			log.Warn("unable to prune %s images (%v)", t.Name(), e)
 		}
 	}
-	return localize(channel, version)
+	return
 }
 
-func localize(channel, version string) (b string, err error) {
-	var files map[string]string
-	destination := fmt.Sprintf("%s/%s/%s", session.Caller.ImageStore(),
+// refreshImages makes the daemon aware of what is now in t's image store
+func refreshImages(t target.Target) (err error) {
+	Daemon.Lock()
+	defer Daemon.Unlock()
+	Daemon.Media[t.Name()], err = localImages(t)
+	return
+}
+
+// pullDir is where an image is assembled while being fetched. It is kept
+// across failed attempts (and corectld restarts) so that interrupted
+// downloads resume where they left off, instead of from scratch.
+func pullDir(t target.Target, channel, version string) string {
+	return filepath.Join(session.Caller.TmpDir(), "pulls", t.Name(),
 		channel, version)
+}
 
-	if err = os.MkdirAll(destination, 0755); err != nil {
+func localize(t target.Target, channel, version string,
+	roots []string) (b string, err error) {
+	var (
+		staging = pullDir(t, channel, version)
+		m       = &api.ImageManifest{
+			Target: t.Name(), Channel: channel, Version: version,
+			Verified: true,
+		}
+	)
+	if err = os.MkdirAll(staging, 0755); err != nil {
 		return version, err
 	}
-	if files, err = downloadAndVerify(channel, version); err != nil {
+	if err = downloadAndVerify(t, channel, version, staging, roots,
+		m); err != nil {
 		return version, err
 	}
-	defer func() {
-		for _, location := range files {
-			if e := os.RemoveAll(filepath.Dir(location)); e != nil {
-				log.Err(e.Error())
-			}
-		}
-	}()
-	for fn, location := range files {
-		if err = os.Rename(location,
-			fmt.Sprintf("%s/%s", destination, fn)); err != nil {
-			return version, err
-		}
+	// only fully verified images ever make it into the image store
+	m.Created = time.Now()
+	return version, publish(staging, m)
+}
+
+// publish moves the image assembled in staging into the image store,
+// replacing whatever was there under the same version, and records its
+// manifest along
+func publish(staging string, m *api.ImageManifest) (err error) {
+	t, _ := target.Get(m.Target)
+	destination := filepath.Join(imageStore(t), m.Channel, m.Version)
+
+	if err = writeManifest(staging, m); err != nil {
+		return
+	}
+	// replacing an image is only ever forced by the user, yet never
+	// underneath running VMs, nor if pinned
+	Daemon.Lock()
+	if vms := imagesInUse(t)[m.Channel+"/"+m.Version]; len(vms) > 0 {
+		err = errImageInUse(m.Channel, m.Version, vms,
+			", so it can't be replaced")
+	} else if pinned(t, m.Channel, m.Version) {
+		err = errImagePinned(m.Channel, m.Version,
+			" (unpin it to replace it)")
+	}
+	Daemon.Unlock()
+	if err != nil {
+		return
+	}
+	if err = os.RemoveAll(destination); err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return
+	}
+	if err = os.Rename(staging, destination); err != nil {
+		return
+	}
+	if err = ownedByCaller(destination); err == nil {
+		log.Info("%s %s/%s ready", t.Name(), m.Channel, m.Version)
 	}
-	if err = session.Caller.NormalizeOnDiskLayout(); err == nil {
-		log.Info("%s/%s ready", channel, version)
+	return
+}
+
+// readManifest returns the manifest of the image kept in dir
+func readManifest(dir string) (m *api.ImageManifest, err error) {
+	var raw []byte
+
+	if raw, err = ioutil.ReadFile(filepath.Join(dir,
+		api.ManifestFile)); err != nil {
+		return
 	}
-	return version, err
+	m = &api.ImageManifest{}
+	err = json.Unmarshal(raw, m)
+	return
 }
-func downloadAndVerify(channel,
-	version string) (l map[string]string, err error) {
+
+func writeManifest(dir string, m *api.ImageManifest) (err error) {
+	var raw []byte
+
+	if raw, err = json.MarshalIndent(m, "", "  "); err != nil {
+		return
+	}
+	return ioutil.WriteFile(filepath.Join(dir, api.ManifestFile), raw, 0644)
+}
+
+// verifyImage re-hashes, offline, the given image's artifacts, checking
+// them against its manifest and (for verified images) its signed DIGESTS,
+// and returns whatever is wrong with it
+func verifyImage(t target.Target,
+	channel, version string) (problems []string, err error) {
 	var (
-		prefix = "coreos_production_pxe"
-		root   = fmt.Sprintf("http://%s.release.core-os.net/amd64-usr/%s/",
-			channel, version)
-		files = []string{fmt.Sprintf("%s.vmlinuz", prefix),
-			fmt.Sprintf("%s_image.cpio.gz", prefix)}
-		signature = fmt.Sprintf("%s%s%s",
-			root, prefix, "_image.cpio.gz.DIGESTS.asc")
-
-		tmpDir, bzHashSHA512     string
-		output                   *os.File
-		digestRaw, longIDdecoded []byte
-		r, digest                *http.Response
-		longIDdecodedInt         uint64
-		keyring                  openpgp.EntityList
-		check                    *openpgp.Entity
-		re                       = regexp.MustCompile(
-			`(?m)(?P<method>(SHA1|SHA512)) HASH(?:\r?)\n(?P<hash>` +
-				`.[^\s]*)\s*(?P<file>[\w\d_\.]*)`)
-		keymap   = make(map[string]int)
-		location = make(map[string]string)
+		m    *api.ImageManifest
+		raw  []byte
+		sum  string
+		sums map[string]string
+		dir  = imageDir(t, channel, version)
 	)
+	kernel, initrd, digests := t.Artifacts()
 
-	log.Info("downloading and verifying %s/%v", channel, version)
-	for _, target := range files {
-		url := fmt.Sprintf("%s%s", root, target)
+	if _, err = os.Stat(dir); err != nil {
+		return nil, ErrUnknownImage
+	}
+	if m, err = readManifest(dir); err != nil {
+		return []string{fmt.Sprintf("no usable manifest (%v), so nothing "+
+			"to check against. Please re-pull it (with --force)", err)}, nil
+	}
+	if m.Verified {
+		if raw, err = ioutil.ReadFile(filepath.Join(dir,
+			digests)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s missing", digests))
+		} else if sums, _, err = verifyDigests(t, raw); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", digests, err))
+		}
+	}
+	for _, f := range []string{kernel, initrd} {
+		if _, ok := m.Files[f]; !ok {
+			problems = append(problems, fmt.Sprintf("%s not in manifest", f))
+			continue
+		}
+		if sums != nil && sums[f] != m.Files[f] {
+			problems = append(problems, fmt.Sprintf("%s: manifest and %s "+
+				"disagree", f, digests))
+		}
+		if sum, err = sha512sum(filepath.Join(dir, f)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", f, err))
+		} else if sum != m.Files[f] {
+			problems = append(problems, fmt.Sprintf("%s: SHA512 hash "+
+				"mismatch, so corrupted", f))
+		}
+	}
+	return problems, nil
+}
 
-		if tmpDir, err = ioutil.TempDir(session.Caller.TmpDir(), "coreos"); err != nil {
-			return
+// ownedByCaller hands over to the user running corectld what it fetched on
+// its behalf
+func ownedByCaller(dir string) (err error) {
+	var uid, gid int
+
+	if uid, err = strconv.Atoi(session.Caller.Uid); err != nil {
+		return
+	}
+	if gid, err = strconv.Atoi(session.Caller.Gid); err != nil {
+		return
+	}
+	return filepath.Walk(dir, func(p string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
-		defer func() {
-			if err != nil {
-				if e := os.RemoveAll(tmpDir); e != nil {
-					log.Err(e.Error())
-				}
-			}
-		}()
-		token := strings.Split(url, "/")
-		fileName := token[len(token)-1]
-		pack := filepath.Join(tmpDir, "/", fileName)
-		if _, err = http.Head(url); err != nil {
+		return os.Chown(p, uid, gid)
+	})
+}
+
+var (
+	// DownloadAttempts is how many times each image artifact is tried to be
+	// fetched before giving up
+	DownloadAttempts = 5
+	// downloadBackoff is how long to wait before retrying a failed download,
+	// doubled after each further failure
+	downloadBackoff = 2 * time.Second
+
+	downloads = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	}
+	// downloader never gives up on a (slow) transfer as a whole, only on
+	// peers that stop answering
+	downloader = &http.Client{Transport: downloads}
+)
+
+func init() {
+	// so that file:// sources are handled (range requests included) just
+	// like remote ones
+	downloads.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+}
+
+// retrying runs f until it succeeds, backing off exponentially between
+// attempts, up to DownloadAttempts times
+func retrying(what string, f func() error) (err error) {
+	wait := downloadBackoff
+	for attempt := 1; ; attempt++ {
+		if err = f(); err == nil || attempt == DownloadAttempts {
 			return
 		}
-		if digest, err = http.Get(signature); err != nil {
+		log.Warn("%s failed (%v), retrying in %v", what, err, wait)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// trustKey returns the armored key the given target's releases are to be
+// signed with, favouring the one the user may have saved in EtcDir()
+func trustKey(t target.Target) (key string, err error) {
+	file := filepath.Join(session.Caller.EtcDir(), "keys", t.Name()+".asc")
+
+	if buf, e := ioutil.ReadFile(file); e == nil {
+		return string(buf), nil
+	}
+	if key = t.TrustKey(); key == "" {
+		err = fmt.Errorf("no key to verify %s images with. Please save "+
+			"its release signing key (armored) as %s", t.Name(), file)
+	}
+	return
+}
+
+// fetchDigests fetches the given DIGESTS file
+func fetchDigests(url string) (digestRaw []byte, err error) {
+	var r *http.Response
+
+	if err = retrying("fetching "+url, func() (err error) {
+		if r, err = downloader.Get(url); err != nil {
 			return
 		}
-		defer digest.Body.Close()
-		switch digest.StatusCode {
+		defer r.Body.Close()
+		switch r.StatusCode {
 		case http.StatusOK, http.StatusNoContent:
 		default:
-			return l, fmt.Errorf("failed fetching %s: HTTP status: %s",
-				signature, digest.Status)
+			return fmt.Errorf("HTTP status: %s", r.Status)
 		}
-		if digestRaw, err = ioutil.ReadAll(digest.Body); err != nil {
-			return
+		digestRaw, err = ioutil.ReadAll(r.Body)
+		return
+	}); err != nil {
+		return nil, fmt.Errorf("failed fetching %s: %v", url, err)
+	}
+	return
+}
+
+// verifyDigests checks that the given DIGESTS file was signed by the
+// target's trusted key and returns the SHA512 hashes listed in it, indexed
+// by file name, along with the id of the key that signed it
+func verifyDigests(t target.Target,
+	digestRaw []byte) (sums map[string]string, keyID string, err error) {
+	var (
+		key     string
+		keyring openpgp.EntityList
+		check   *openpgp.Entity
+		re      = regexp.MustCompile(
+			`(?m)(?P<method>(SHA1|SHA512)) HASH(?:\r?)\n(?P<hash>` +
+				`.[^\s]*)\s*(?P<file>[\w\d_\.]*)`)
+		keymap = make(map[string]int)
+	)
+
+	if key, err = trustKey(t); err != nil {
+		return
+	}
+	if keyring, err = openpgp.ReadArmoredKeyRing(
+		bytes.NewBufferString(key)); err != nil {
+		return
+	}
+	messageClear, _ := clearsign.Decode(digestRaw)
+	if messageClear == nil {
+		return nil, "", fmt.Errorf("not a signed DIGESTS file")
+	}
+	if check, err =
+		openpgp.CheckDetachedSignature(keyring,
+			bytes.NewReader(messageClear.Bytes),
+			messageClear.ArmoredSignature.Body); err != nil {
+		return nil, "", fmt.Errorf("Signature check for DIGESTS failed.")
+	}
+	keyID = check.PrimaryKey.KeyIdString()
+	log.Debug("DIGESTS signature OK (by key %s)", keyID)
+
+	for index, name := range re.SubexpNames() {
+		keymap[name] = index
+	}
+	sums = make(map[string]string)
+	for _, match := range re.FindAllStringSubmatch(
+		string(messageClear.Bytes), -1) {
+		if match[keymap["method"]] == "SHA512" {
+			sums[match[keymap["file"]]] = match[keymap["hash"]]
 		}
-		if longIDdecoded, err = hex.DecodeString(coreos.GPGLongID); err != nil {
-			return
+	}
+	return
+}
+
+// fetch downloads url into dest, resuming from whatever dest already holds
+// when the server supports range requests. The bar always accounts for
+// what is on disk.
+func fetch(url, dest string, bar *pb.ProgressBar) (err error) {
+	var (
+		req  *http.Request
+		r    *http.Response
+		out  *os.File
+		have int64
+	)
+	if out, err = os.OpenFile(dest, os.O_WRONLY|os.O_CREATE, 0644); err != nil {
+		return
+	}
+	defer out.Close()
+	if have, err = out.Seek(0, io.SeekEnd); err != nil {
+		return
+	}
+	if req, err = http.NewRequest("GET", url, nil); err != nil {
+		return
+	}
+	if have > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", have))
+	}
+	if r, err = downloader.Do(req); err != nil {
+		return
+	}
+	defer r.Body.Close()
+
+	switch r.StatusCode {
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(r.Header.Get("Content-Range"),
+			fmt.Sprintf("bytes %d-", have)) {
+			return fmt.Errorf("unexpected range (%s) in reply",
+				r.Header.Get("Content-Range"))
 		}
-		longIDdecodedInt = binary.BigEndian.Uint64(longIDdecoded)
-		log.Debug("Trusted hex key id %s is decimal %d",
-			coreos.GPGLongID, longIDdecoded)
-		if keyring, err = openpgp.ReadArmoredKeyRing(
-			bytes.NewBufferString(coreos.GPGKey)); err != nil {
+		log.Debug("resuming %s from byte %d", url, have)
+	case http.StatusOK, http.StatusNoContent:
+		// no resume support upstream, so starting over
+		if err = out.Truncate(0); err != nil {
 			return
 		}
-		messageClear, _ := clearsign.Decode(digestRaw)
-		digestTxt := string(messageClear.Bytes)
-		messageClearRdr := bytes.NewReader(messageClear.Bytes)
-		if check, err =
-			openpgp.CheckDetachedSignature(keyring, messageClearRdr,
-				messageClear.ArmoredSignature.Body); err != nil {
-			return l, fmt.Errorf("Signature check for DIGESTS failed.")
-		}
-		if check.PrimaryKey.KeyId == longIDdecodedInt {
-			log.Debug("Trusted key id %d matches keyid %d",
-				longIDdecodedInt, longIDdecodedInt)
+		if _, err = out.Seek(0, io.SeekStart); err != nil {
+			return
 		}
-		log.Debug("DIGESTS signature OK. ")
+		bar.Add(-int(have))
+	case http.StatusRequestedRangeNotSatisfiable:
+		// as we already have it all
+		return
+	default:
+		return fmt.Errorf("HTTP status: %s", r.Status)
+	}
+	_, err = io.Copy(io.MultiWriter(out, bar), r.Body)
+	return
+}
 
-		for index, name := range re.SubexpNames() {
-			keymap[name] = index
-		}
+// sha512sum returns the hex encoded SHA512 hash of the given file
+func sha512sum(file string) (sum string, err error) {
+	var f *os.File
 
-		matches := re.FindAllStringSubmatch(digestTxt, -1)
+	if f, err = os.Open(file); err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha512.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-		for _, match := range matches {
-			if match[keymap["file"]] == fileName {
-				if match[keymap["method"]] == "SHA512" {
-					bzHashSHA512 = match[keymap["hash"]]
-				}
-			}
+// downloadAndVerify fetches the given image's artifacts into dir, from the
+// first of the given roots that fully serves them, recording in m how it
+// went
+func downloadAndVerify(t target.Target, channel, version, dir string,
+	roots []string, m *api.ImageManifest) (err error) {
+	log.Info("downloading and verifying %s %s/%v", t.Name(), channel, version)
+	for _, root := range roots {
+		if err = downloadFrom(t, root+"/"+version+"/", dir,
+			m); err == nil {
+			return
 		}
+		log.Warn("unable to fetch %s/%s from %s (%v)",
+			channel, version, root, err)
+	}
+	if err == nil {
+		err = fmt.Errorf("no image sources available for %s", channel)
+	}
+	return
+}
 
-		sha512h := sha512.New()
+// downloadFrom fetches, in parallel, the artifacts under root into dir,
+// checking each one against the signed DIGESTS published along (which is
+// kept in dir too), and fills m's provenance
+func downloadFrom(t target.Target, root, dir string,
+	m *api.ImageManifest) (err error) {
+	var (
+		kernel, initrd, digests = t.Artifacts()
+		files                   = []string{kernel, initrd}
+		signature               = root + digests
+
+		raw   []byte
+		sums  map[string]string
+		keyID string
+		total int64
+		wg    sync.WaitGroup
+		errs  = make([]error, len(files))
+	)
 
-		if r, err = http.Get(url); err != nil {
+	if raw, err = fetchDigests(signature); err != nil {
+		return
+	}
+	if sums, keyID, err = verifyDigests(t, raw); err != nil {
+		return fmt.Errorf("%s: %v", signature, err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, digests), raw,
+		0644); err != nil {
+		return
+	}
+	hashes := make(map[string]string)
+	for _, fileName := range files {
+		if _, ok := sums[fileName]; !ok {
+			return fmt.Errorf("no SHA512 hash for %s in %s",
+				fileName, signature)
+		}
+		hashes[fileName] = sums[fileName]
+		url := root + fileName
+		if err = retrying("probing "+url, func() (err error) {
+			var r *http.Response
+			if r, err = downloader.Head(url); err != nil {
+				return
+			}
+			r.Body.Close()
+			if r.StatusCode != http.StatusOK {
+				return fmt.Errorf("HTTP status: %s", r.Status)
+			}
+			// file:// replies only carry it in their headers
+			size, _ := strconv.ParseInt(r.Header.Get("Content-Length"),
+				10, 64)
+			total += size
 			return
+		}); err != nil {
+			return fmt.Errorf("failed fetching %s: %v", url, err)
 		}
-		defer r.Body.Close()
+	}
 
-		switch r.StatusCode {
-		case http.StatusOK, http.StatusNoContent:
-		default:
-			return l, fmt.Errorf("failed fetching %s: HTTP status: %s",
-				signature, r.Status)
+	bar := pb.New(int(total)).SetUnits(pb.U_BYTES)
+	for _, fileName := range files {
+		if fi, e := os.Stat(filepath.Join(dir, fileName)); e == nil {
+			bar.Add(int(fi.Size()))
 		}
-
-		bar := pb.New(int(r.ContentLength)).SetUnits(pb.U_BYTES)
-		bar.Start()
-
-		if output, err = os.Create(pack); err != nil {
+	}
+	bar.Start()
+	for i, fileName := range files {
+		wg.Add(1)
+		go func(i int, fileName string) {
+			defer wg.Done()
+			url, dest := root+fileName, filepath.Join(dir, fileName)
+			errs[i] = retrying("fetching "+url, func() (err error) {
+				var (
+					sum string
+					fi  os.FileInfo
+				)
+				if err = fetch(url, dest, bar); err != nil {
+					return
+				}
+				if sum, err = sha512sum(dest); err != nil {
+					return
+				}
+				if sum == sums[fileName] {
+					return
+				}
+				// corrupted, somehow, so starting over on next attempt
+				if fi, err = os.Stat(dest); err == nil {
+					bar.Add(-int(fi.Size()))
+				}
+				if err = os.Remove(dest); err != nil {
+					return
+				}
+				return fmt.Errorf("SHA512 hash verification failed for %s",
+					fileName)
+			})
+			if errs[i] == nil {
+				log.Info("SHA512 hash for %s OK", fileName)
+			}
+		}(i, fileName)
+	}
+	wg.Wait()
+	bar.Finish()
+	for _, err = range errs {
+		if err != nil {
 			return
 		}
-		defer output.Close()
-
-		writer := io.MultiWriter(sha512h, bar, output)
-		io.Copy(writer, r.Body)
-		bar.Finish()
-		if hex.EncodeToString(sha512h.Sum([]byte{})) != bzHashSHA512 {
-			return l, fmt.Errorf("SHA512 hash verification failed for %s",
-				fileName)
-		}
-		log.Info("SHA512 hash for %s OK", fileName)
-
-		location[fileName] = pack
 	}
-	return location, err
+	m.Files, m.KeyID, m.Source = hashes, keyID, strings.TrimSuffix(root, "/")
+	// only informative, so not worth failing over
+	if m.Release, err = coreos.ReleaseInfo(downloader,
+		root+"version.txt"); err != nil {
+		log.Debug("no release info for %s (%v)", root, err)
+	}
+	return nil
 }
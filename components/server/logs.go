@@ -0,0 +1,323 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/host/session"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// LogRetention is how long the serial logs of VMs that are gone are
+	// kept around, for post-mortems
+	LogRetention = 7 * 24 * time.Hour
+	// how often logs get checked for new output
+	logPoll = time.Second
+)
+
+// logIndex records when each chunk of a log got written, as the serial
+// output itself carries no timestamps (one 'unixnano offset' per line)
+func logIndex(logfile string) string {
+	return logfile + ".idx"
+}
+
+// watchLog indexes the VM's log as it grows, until told to stop
+func (vm *VMInfo) watchLog(stop <-chan struct{}, done chan<- struct{}) {
+	var (
+		last int64
+		tick = time.NewTicker(logPoll)
+	)
+	defer close(done)
+	defer tick.Stop()
+
+	for {
+		if fi, err := os.Stat(vm.Log()); err == nil && fi.Size() != last {
+			flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+			if fi.Size() < last {
+				// truncated meanwhile, so starting over
+				flag, last = flag|os.O_TRUNC, 0
+			}
+			if f, err := os.OpenFile(logIndex(vm.Log()), flag,
+				0644); err == nil {
+				fmt.Fprintf(f, "%d %d\n", time.Now().UnixNano(), last)
+				f.Close()
+			}
+			last = fi.Size()
+		}
+		select {
+		case <-tick.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// retainLog sets aside the serial log (and index) left in the given run
+// dir, so that it outlives its VM for LogRetention
+func retainLog(runDir, name, uuid string) {
+	var (
+		logfile = filepath.Join(runDir, "log")
+		kept    = filepath.Join(session.Caller.LogDir(),
+			name+"."+uuid+".log")
+	)
+	defer os.Remove(logIndex(logfile))
+	defer os.Remove(logfile)
+
+	if LogRetention <= 0 {
+		return
+	}
+	if _, err := os.Stat(logfile); err != nil {
+		return
+	}
+	if err := os.MkdirAll(session.Caller.LogDir(), 0755); err != nil {
+		log.Warn("unable to keep %v's log (%v)", name, err)
+		return
+	}
+	if err := os.Rename(logfile, kept); err != nil {
+		log.Warn("unable to keep %v's log (%v)", name, err)
+		return
+	}
+	os.Rename(logIndex(logfile), logIndex(kept))
+	if err := ownedByCaller(session.Caller.LogDir()); err != nil {
+		log.Warn("unable to hand %v's log over to %v (%v)", name,
+			session.Caller.Username, err)
+	}
+	pruneLogs()
+}
+
+// pruneLogs drops the kept logs that outlived LogRetention
+func pruneLogs() {
+	var (
+		entries []os.FileInfo
+		err     error
+		horizon = time.Now().Add(-LogRetention)
+	)
+	if entries, err = ioutil.ReadDir(session.Caller.LogDir()); err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.ModTime().Before(horizon) {
+			os.Remove(filepath.Join(session.Caller.LogDir(), e.Name()))
+		}
+	}
+}
+
+// logOf finds the log of the given VM (name or UUID), be it running (in
+// which case it's returned as well) or gone but its log kept
+func logOf(id string) (logfile string, vm *VMInfo, err error) {
+	var (
+		kept   []string
+		newest time.Time
+	)
+	Daemon.Lock()
+	for _, v := range Daemon.Active {
+		if v.Name == id || v.UUID == id {
+			vm = v
+		}
+	}
+	Daemon.Unlock()
+	if vm != nil {
+		return vm.Log(), vm, nil
+	}
+
+	// kept as name.UUID.log
+	if kept, err = filepath.Glob(filepath.Join(session.Caller.LogDir(),
+		"*.log")); err != nil {
+		return
+	}
+	for _, k := range kept {
+		base := strings.TrimSuffix(filepath.Base(k), ".log")
+		if i := strings.LastIndex(base, "."); i < 0 ||
+			(base[:i] != id && base[i+1:] != id) {
+			continue
+		}
+		if fi, err := os.Stat(k); err == nil && fi.ModTime().After(newest) {
+			logfile, newest = k, fi.ModTime()
+		}
+	}
+	if logfile == "" {
+		err = api.Errorf(api.ErrCodeNotFound, "no log found for '%v'", id)
+	}
+	return
+}
+
+// offsetSince returns where, in the log, the output written since the given
+// time starts
+func offsetSince(logfile string, since time.Time) (offset int64) {
+	var (
+		f   *os.File
+		err error
+	)
+	if f, err = os.Open(logIndex(logfile)); err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var when int64
+		if _, err = fmt.Sscanf(s.Text(), "%d %d", &when, &offset); err != nil {
+			continue
+		}
+		if !time.Unix(0, when).Before(since) {
+			return
+		}
+	}
+	// nothing that recent
+	if fi, err := os.Stat(logfile); err == nil {
+		return fi.Size()
+	}
+	return
+}
+
+// lastLines returns the last n lines of what's left in r
+func lastLines(r io.Reader, n int) []byte {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil || n <= 0 {
+		return buf
+	}
+	for i := len(bytes.TrimSuffix(buf, []byte("\n"))) - 1; i >= 0; i-- {
+		if buf[i] == '\n' {
+			if n--; n == 0 {
+				return buf[i+1:]
+			}
+		}
+	}
+	return buf
+}
+
+// httpLogs streams the serial log of the given VM, running or not
+// (?follow=true&tail=N&since=RFC3339|duration)
+func httpLogs(w http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		f       *os.File
+		vm      *VMInfo
+		logfile string
+		offset  int64
+		tail    int
+		flusher http.Flusher
+		ok      bool
+		q       = r.URL.Query()
+		follow  = q.Get("follow") == "true"
+	)
+	if flusher, ok = w.(http.Flusher); !ok {
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+	if t := q.Get("tail"); t != "" {
+		if tail, err = strconv.Atoi(t); err != nil || tail < 0 {
+			http.Error(w, fmt.Sprintf("'%v' isn't a valid line count", t),
+				http.StatusBadRequest)
+			return
+		}
+	}
+	if logfile, vm, err = logOf(mux.Vars(r)["id"]); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	if vm != nil {
+		err = mayManage(r, vm.Name, vm.Owner)
+	} else if def, e := Daemon.definition(mux.Vars(r)["id"]); e == nil {
+		err = mayManage(r, def.Name, def.Owner)
+	} else {
+		// gone, along with whom it belonged to
+		err = mayManage(r, mux.Vars(r)["id"], "")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	if q.Get("since") != "" {
+		var since time.Time
+		if since, err = parseSince(q.Get("since")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		offset = offsetSince(logfile, since)
+	}
+	if f, err = os.Open(logfile); err != nil {
+		if !os.IsNotExist(err) || vm == nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// nothing logged yet
+		f = nil
+	}
+	if f != nil {
+		defer f.Close()
+		f.Seek(offset, io.SeekStart)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if f != nil {
+		if tail > 0 {
+			w.Write(lastLines(f, tail))
+		} else if _, err = io.Copy(w, f); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	if !follow || vm == nil {
+		return
+	}
+
+	tick := time.NewTicker(logPoll)
+	defer tick.Stop()
+	for {
+		gone := false
+		select {
+		case <-tick.C:
+		case <-vm.gone:
+			gone = true
+		case <-r.Context().Done():
+			return
+		case <-Daemon.Events.done:
+			return
+		}
+		if f == nil {
+			if f, err = os.Open(logfile); err != nil {
+				f = nil
+			} else {
+				defer f.Close()
+			}
+		}
+		if f != nil {
+			if _, err = io.Copy(w, f); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if gone {
+			return
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/genevera/corectl/components/api"
+)
+
+// schema is a (minimal) JSON schema, as understood by OpenAPI 2.0
+type schema map[string]interface{}
+
+var (
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	timeType      = reflect.TypeOf(time.Time{})
+)
+
+// schemaOf derives, by reflection, the JSON schema of the given type,
+// recording named structs in defs so that they're only described once
+func schemaOf(t reflect.Type, defs map[string]schema) schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return schema{"type": "string", "format": "date-time"}
+	}
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		return schema{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": schemaOf(t.Elem(), defs)}
+	case reflect.Map:
+		return schema{"type": "object",
+			"additionalProperties": schemaOf(t.Elem(), defs)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, defs)
+		}
+		if _, done := defs[t.Name()]; !done {
+			// placeholder, as types may be recursive
+			defs[t.Name()] = schema{}
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		return schema{"$ref": "#/definitions/" + t.Name()}
+	}
+	return schema{}
+}
+
+func structSchema(t reflect.Type, defs map[string]schema) schema {
+	props := make(map[string]schema)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		if f.Anonymous {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if embedded, ok := structSchema(ft,
+					defs)["properties"].(map[string]schema); ok {
+					for k, v := range embedded {
+						props[k] = v
+					}
+				}
+				continue
+			}
+		}
+		props[name] = schemaOf(f.Type, defs)
+	}
+	return schema{"type": "object", "properties": props}
+}
+
+// openAPI documents the REST endpoints, straight out of restEndpoints
+func openAPI() map[string]interface{} {
+	var (
+		defs  = make(map[string]schema)
+		paths = make(map[string]map[string]interface{})
+	)
+	errSchema := schemaOf(reflect.TypeOf(api.RPCError{}), defs)
+	for _, e := range restEndpoints {
+		var (
+			params []map[string]interface{}
+			status = e.Status
+			ok     = map[string]interface{}{"description": "success"}
+		)
+		if status == 0 {
+			status = http.StatusOK
+		}
+		for _, p := range e.Params {
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"description": p.Description,
+				"required":    p.Required,
+				"type":        "string",
+			})
+		}
+		if e.Body != nil {
+			params = append(params, map[string]interface{}{
+				"name":     "body",
+				"in":       "body",
+				"required": true,
+				"schema":   schemaOf(reflect.TypeOf(e.Body), defs),
+			})
+		}
+		if e.Reply != nil {
+			ok["schema"] = schemaOf(reflect.TypeOf(e.Reply), defs)
+		}
+		op := map[string]interface{}{
+			"summary": e.Summary,
+			"responses": map[string]interface{}{
+				strconv.Itoa(status): ok,
+				"default": map[string]interface{}{
+					"description": "error, with its RPC error code",
+					"schema":      errSchema,
+				},
+			},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if paths[e.Path] == nil {
+			paths[e.Path] = make(map[string]interface{})
+		}
+		paths[e.Path][strings.ToLower(e.Method)] = op
+	}
+	return map[string]interface{}{
+		"swagger": "2.0",
+		"info": map[string]interface{}{
+			"title":   "corectld",
+			"version": strconv.Itoa(api.APIVersion),
+		},
+		"basePath":    RESTprefix,
+		"consumes":    []string{"application/json"},
+		"produces":    []string{"application/json"},
+		"paths":       paths,
+		"definitions": defs,
+	}
+}
+
+func httpOpenAPI(w http.ResponseWriter, r *http.Request) {
+	var (
+		buf []byte
+		err error
+	)
+	if buf, err = json.MarshalIndent(openAPI(), "", "  "); err != nil {
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(buf, '\n'))
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/deis/pkg/log"
+	"github.com/deoxxa/gocpio"
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/host/darwin/misc/image"
+	"github.com/genevera/corectl/components/host/session"
+	"github.com/genevera/corectl/components/target"
+)
+
+// overlays serializes the building of derived initrds, as VMs sharing an
+// image and overlay would otherwise build the same one at once
+var overlays sync.Mutex
+
+// overlayCache is where the initrds derived from the given image are kept
+func overlayCache(t target.Target, channel, version string) string {
+	return filepath.Join(session.Caller.TmpDir(), "initrds", t.Name(),
+		channel, version)
+}
+
+// overlaidInitrd returns an initrd made of the given image's own, with
+// the files in dir appended as an extra (gzipped) cpio segment, which the
+// kernel unpacks over the former. It is only built once per image and
+// overlay contents.
+func overlaidInitrd(t target.Target, channel, version,
+	dir string) (initrd string, err error) {
+	var (
+		fi       os.FileInfo
+		sum      string
+		tmp      *os.File
+		w        *image.Writer
+		base     = imageDir(t, channel, version)
+		cache    = overlayCache(t, channel, version)
+		_, rd, _ = t.Artifacts()
+	)
+
+	if fi, err = os.Stat(dir); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("initrd overlay %s isn't a directory", dir)
+	}
+	if sum, err = overlayDigest(base, rd, dir); err != nil {
+		return
+	}
+	initrd = filepath.Join(cache, sum+".cpio.gz")
+
+	overlays.Lock()
+	defer overlays.Unlock()
+
+	if _, err = os.Stat(initrd); err == nil {
+		return
+	}
+	log.Info("adding %s to %s %s/%s's initrd", dir, t.Name(), channel,
+		version)
+	if err = os.MkdirAll(cache, 0755); err != nil {
+		return
+	}
+	if tmp, err = ioutil.TempFile(cache, "building"); err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err = copyFile(tmp, filepath.Join(base, rd)); err != nil {
+		return
+	}
+	if w, err = image.NewWriter(tmp); err != nil {
+		return
+	}
+	if err = appendTree(w, dir); err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), initrd)
+	return
+}
+
+func copyFile(dst io.Writer, src string) (err error) {
+	var f *os.File
+
+	if f, err = os.Open(src); err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = io.Copy(dst, f)
+	return
+}
+
+// overlayDigest identifies the initrd derived from the given image's one
+// with the given overlay, hashing the later's layout and contents
+func overlayDigest(base, rd, dir string) (sum string, err error) {
+	var (
+		m  *api.ImageManifest
+		fi os.FileInfo
+		h  = sha256.New()
+	)
+
+	// images predating manifests are only told apart by their timestamps
+	if m, err = readManifest(base); err == nil {
+		fmt.Fprintf(h, "%s\x00", m.Files[rd])
+	} else if fi, err = os.Stat(filepath.Join(base, rd)); err == nil {
+		fmt.Fprintf(h, "%d\x00", fi.ModTime().UnixNano())
+	} else {
+		return
+	}
+	err = filepath.Walk(dir, func(p string, fi os.FileInfo,
+		err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		fmt.Fprintf(h, "%s\x00%o\x00", filepath.ToSlash(rel), fi.Mode())
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			fmt.Fprintf(h, "%s\x00", link)
+			return err
+		case fi.Mode().IsRegular():
+			return copyFile(h, p)
+		}
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil)), err
+}
+
+// appendTree writes into w all that's in dir, as owned by root
+func appendTree(w *image.Writer, dir string) error {
+	return filepath.Walk(dir, func(p string, fi os.FileInfo,
+		err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		var (
+			name = filepath.ToSlash(rel)
+			mode = int64(fi.Mode().Perm())
+		)
+		switch {
+		case fi.IsDir():
+			return w.WriteDir(name, mode)
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			if err = w.WriteHeader(&cpio.Header{
+				Name:  name,
+				Mode:  0777,
+				Mtime: fi.ModTime().Unix(),
+				Size:  int64(len(link)),
+				Type:  cpio.TYPE_SYMLINK,
+			}); err != nil {
+				return err
+			}
+			_, err = w.Write([]byte(link))
+			return err
+		case fi.Mode().IsRegular():
+			if err = w.WriteHeader(&cpio.Header{
+				Name:  name,
+				Mode:  mode,
+				Mtime: fi.ModTime().Unix(),
+				Size:  fi.Size(),
+				Type:  cpio.TYPE_REG,
+			}); err != nil {
+				return err
+			}
+			return copyFile(w, p)
+		}
+		log.Warn("%s left out of initrd overlay, as not a regular file, "+
+			"directory or symlink", p)
+		return nil
+	})
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/api"
+)
+
+// pause suspends the VM, freeing the host's CPU, until resumed. Meanwhile
+// it's kept out of the DNS, so that no one ends up waiting on it.
+func (vm *VMInfo) pause() (err error) {
+	Daemon.Lock()
+	switch {
+	case vm.pausing != nil:
+		err = api.Errorf(api.ErrCodeConflict, "'%v' is already being "+
+			"paused or resumed", vm.Name)
+	case vm.halting():
+		err = api.Errorf(api.ErrCodeConflict, "'%v' is shutting down",
+			vm.Name)
+	case vm.Paused:
+		err = api.Errorf(api.ErrCodeConflict, "'%v' is already paused",
+			vm.Name)
+	case vm.PublicIP == "":
+		err = api.Errorf(api.ErrCodeConflict, "'%v' is still booting",
+			vm.Name)
+	}
+	if err != nil {
+		Daemon.Unlock()
+		return
+	}
+	vm.pausing = make(chan struct{})
+	Daemon.Unlock()
+
+	// as backends may take a while to answer (if at all), no one else is
+	// to be held meanwhile
+	err = vm.hypervisor().Pause(vm)
+
+	Daemon.Lock()
+	defer Daemon.Unlock()
+	close(vm.pausing)
+	vm.pausing = nil
+	if err != nil {
+		return fmt.Errorf("unable to pause '%v' (%v)", vm.Name, err)
+	}
+	vm.Paused = true
+	if err = Daemon.DNSServer.rmRecord(vm.Name, vm.PublicIP); err != nil {
+		log.Warn("unable to drop %v's dns records (%v)", vm.Name, err)
+	}
+	vm.stateChanged()
+	vm.emit(api.EventPaused, "")
+	return nil
+}
+
+// resume brings back a paused VM
+func (vm *VMInfo) resume() (err error) {
+	Daemon.Lock()
+	switch {
+	case vm.pausing != nil:
+		err = api.Errorf(api.ErrCodeConflict, "'%v' is already being "+
+			"paused or resumed", vm.Name)
+	case !vm.Paused:
+		err = api.Errorf(api.ErrCodeConflict, "'%v' isn't paused", vm.Name)
+	}
+	if err != nil {
+		Daemon.Unlock()
+		return
+	}
+	vm.pausing = make(chan struct{})
+	Daemon.Unlock()
+
+	err = vm.hypervisor().Resume(vm)
+
+	Daemon.Lock()
+	defer Daemon.Unlock()
+	close(vm.pausing)
+	vm.pausing = nil
+	if err != nil {
+		return fmt.Errorf("unable to resume '%v' (%v)", vm.Name, err)
+	}
+	vm.Paused = false
+	if err = Daemon.DNSServer.addRecord(vm.Name, vm.PublicIP); err != nil {
+		log.Warn("unable to restore %v's dns records (%v)", vm.Name, err)
+	}
+	vm.stateChanged()
+	vm.emit(api.EventResumed, "")
+	return nil
+}
+
+// pauseAll pauses (or resumes) each of the given VMs, carrying on past
+// failures, which are reported per VM. Only when all of them failed does
+// it fail too.
+func pauseAll(targets VMs, resume bool,
+	reply *api.PauseVMsReply) (err error) {
+	for _, vm := range targets {
+		op := vm.pause
+		if resume {
+			op = vm.resume
+		}
+		if e := op(); e != nil {
+			log.Warn("%v", e)
+			if reply.Failed == nil {
+				reply.Failed = make(map[string]string)
+			}
+			reply.Failed[vm.Name], err = e.Error(), e
+			continue
+		}
+		reply.VMs = append(reply.VMs, vm.Name)
+	}
+	if len(reply.VMs) > 0 {
+		return nil
+	}
+	return
+}
+
+// stateChanged persists the VM's state, so that a corectld restart finds it
+// as it is now
+func (vm *VMInfo) stateChanged() {
+	if err := vm.persistState(); err != nil {
+		log.Warn("unable to persist %v's state (%v)", vm.Name, err)
+	}
+}
+
+// targetsOf resolves the given VM names or UUIDs into the running VMs
+func targetsOf(names []string) (targets VMs, err error) {
+	Daemon.Lock()
+	defer Daemon.Unlock()
+
+	for _, t := range names {
+		for _, v := range Daemon.Active {
+			if v.Name == t || v.UUID == t {
+				targets = append(targets, v)
+			}
+		}
+	}
+	if len(targets) != len(names) {
+		return nil, ErrUnknownVM
+	}
+	return
+}
+
+func (s *RPCservice) PauseVMs(r *http.Request,
+	args *api.PauseVMsArgs, reply *api.PauseVMsReply) (err error) {
+	log.Debug("vm:pause")
+	defer rpcGuard("vm:pause", &err)
+
+	var targets VMs
+
+	if err = rpcAdmit(args); err != nil {
+		return
+	}
+	if targets, err = targetsOf(args.Targets); err != nil {
+		return
+	}
+	for _, t := range targets {
+		if err = mayManage(r, t.Name, t.Owner); err != nil {
+			return
+		}
+	}
+	return pauseAll(targets, false, reply)
+}
+
+func (s *RPCservice) ResumeVMs(r *http.Request,
+	args *api.PauseVMsArgs, reply *api.PauseVMsReply) (err error) {
+	log.Debug("vm:resume")
+	defer rpcGuard("vm:resume", &err)
+
+	var targets VMs
+
+	if err = rpcAdmit(args); err != nil {
+		return
+	}
+	if targets, err = targetsOf(args.Targets); err != nil {
+		return
+	}
+	for _, t := range targets {
+		if err = mayManage(r, t.Name, t.Owner); err != nil {
+			return
+		}
+	}
+	return pauseAll(targets, true, reply)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// from <sys/un.h> and <sys/ucred.h>
+const (
+	solLocal      = 0
+	localPeerCred = 0x001
+	xucredVersion = 0
+)
+
+type xucred struct {
+	Version uint32
+	UID     uint32
+	Ngroups int16
+	Groups  [16]uint32
+}
+
+// peerUID returns the uid of the process at the other end of the socket
+func peerUID(c *net.UnixConn) (uid int, err error) {
+	var (
+		raw   syscall.RawConn
+		cred  xucred
+		size  = uint32(unsafe.Sizeof(cred))
+		errno syscall.Errno
+	)
+	uid = -1
+	if raw, err = c.SyscallConn(); err != nil {
+		return
+	}
+	if err = raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd,
+			solLocal, localPeerCred, uintptr(unsafe.Pointer(&cred)),
+			uintptr(unsafe.Pointer(&size)), 0)
+	}); err != nil {
+		return
+	}
+	if errno != 0 {
+		return uid, errno
+	}
+	if cred.Version != xucredVersion {
+		return uid, syscall.EINVAL
+	}
+	return int(cred.UID), nil
+}
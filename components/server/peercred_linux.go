@@ -0,0 +1,44 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerUID returns the uid of the process at the other end of the socket
+func peerUID(c *net.UnixConn) (uid int, err error) {
+	var (
+		raw   syscall.RawConn
+		cred  *syscall.Ucred
+		inner error
+	)
+	uid = -1
+	if raw, err = c.SyscallConn(); err != nil {
+		return
+	}
+	if err = raw.Control(func(fd uintptr) {
+		cred, inner = syscall.GetsockoptUcred(int(fd),
+			syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return
+	}
+	if err = inner; err != nil {
+		return
+	}
+	return int(cred.Uid), nil
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/host/session"
+)
+
+var (
+	// RemoteAccess tells whether corectld accepts remote clients at all
+	RemoteAccess = false
+	// RemotePort is where corectld serves its control API, over mutual TLS,
+	// to remote clients
+	RemotePort = "2512"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 365 * 24 * time.Hour
+)
+
+func tlsPath(file string) string {
+	return filepath.Join(session.Caller.TLSDir(), file)
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// writeKeyPair stores, PEM encoded, the given certificate and key
+func writeKeyPair(dir, certFile, keyFile string, der []byte,
+	key *ecdsa.PrivateKey) (err error) {
+	var raw []byte
+
+	if raw, err = x509.MarshalECPrivateKey(key); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, certFile),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0644); err != nil {
+		return
+	}
+	return ioutil.WriteFile(filepath.Join(dir, keyFile),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: raw}),
+		0600)
+}
+
+// loadCA returns corectld's certificate authority
+func loadCA() (ca *x509.Certificate, key *ecdsa.PrivateKey, err error) {
+	var (
+		pair tls.Certificate
+		ok   bool
+	)
+	if pair, err = tls.LoadX509KeyPair(tlsPath(api.CAcert),
+		tlsPath(api.CAkey)); err != nil {
+		return
+	}
+	if key, ok = pair.PrivateKey.(*ecdsa.PrivateKey); !ok {
+		return nil, nil, fmt.Errorf("unexpected CA key type")
+	}
+	ca, err = x509.ParseCertificate(pair.Certificate[0])
+	return
+}
+
+func newCA() (err error) {
+	var (
+		der    []byte
+		serial *big.Int
+		key    *ecdsa.PrivateKey
+	)
+	if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return
+	}
+	if serial, err = newSerial(); err != nil {
+		return
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "corectld CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if der, err = x509.CreateCertificate(rand.Reader, tmpl, tmpl,
+		&key.PublicKey, key); err != nil {
+		return
+	}
+	return writeKeyPair(session.Caller.TLSDir(), api.CAcert, api.CAkey,
+		der, key)
+}
+
+// issue signs a new certificate, out of the given template, with
+// corectld's CA
+func issue(tmpl *x509.Certificate, dir, certFile, keyFile string) (err error) {
+	var (
+		der    []byte
+		ca     *x509.Certificate
+		caKey  *ecdsa.PrivateKey
+		key    *ecdsa.PrivateKey
+		serial *big.Int
+	)
+	if ca, caKey, err = loadCA(); err != nil {
+		return
+	}
+	if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return
+	}
+	if serial, err = newSerial(); err != nil {
+		return
+	}
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(certValidity)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature |
+		x509.KeyUsageKeyEncipherment
+	if der, err = x509.CreateCertificate(rand.Reader, tmpl, ca,
+		&key.PublicKey, caKey); err != nil {
+		return
+	}
+	if err = writeKeyPair(dir, certFile, keyFile, der, key); err != nil {
+		return
+	}
+	if dir == session.Caller.TLSDir() {
+		return
+	}
+	// ship the CA along, so that the client can verify the server
+	if der, err = ioutil.ReadFile(tlsPath(api.CAcert)); err != nil {
+		return
+	}
+	return ioutil.WriteFile(filepath.Join(dir, api.CAcert), der, 0644)
+}
+
+// hostAddresses returns all the names and IPs under which this host may be
+// reached
+func hostAddresses() (names []string, ips []net.IP) {
+	names = []string{"localhost", "corectld." + LocalDomainName}
+	if h, err := os.Hostname(); err == nil {
+		names = append(names, h)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok {
+				ips = append(ips, n.IP)
+			}
+		}
+	}
+	return
+}
+
+// IssueClientCert creates a new client certificate, signed by corectld's
+// CA, and stores it, along with the CA certificate, in the given directory
+func IssueClientCert(name, dir string) (err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	return issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, dir, api.ClientCert, api.ClientKey)
+}
+
+// setupPKI makes sure that there's a CA, that the server certificate is
+// current and that the owner has a client certificate of its own
+func setupPKI() (err error) {
+	if err = os.MkdirAll(session.Caller.TLSDir(), 0700); err != nil {
+		return
+	}
+	if _, err = os.Stat(tlsPath(api.CAcert)); os.IsNotExist(err) {
+		log.Info("creating corectld's certificate authority")
+		if err = newCA(); err != nil {
+			return
+		}
+	}
+	// always refreshed, as host's addresses may have changed meanwhile
+	names, ips := hostAddresses()
+	if err = issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "corectld"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:    names,
+		IPAddresses: ips,
+	}, session.Caller.TLSDir(), api.ServerCert, api.ServerKey); err != nil {
+		return
+	}
+	if _, err = os.Stat(tlsPath(api.ClientCert)); os.IsNotExist(err) {
+		if err = IssueClientCert(session.Caller.Username,
+			session.Caller.TLSDir()); err != nil {
+			return
+		}
+	}
+	return ownedByCaller(session.Caller.TLSDir())
+}
+
+func certPool(file string) (pool *x509.CertPool, err error) {
+	var buf []byte
+	if buf, err = ioutil.ReadFile(file); err != nil {
+		return
+	}
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(buf) {
+		err = fmt.Errorf("no certificates found in %v", file)
+	}
+	return
+}
+
+// serverTLSconfig only lets in clients bearing a certificate issued by
+// corectld's CA
+func serverTLSconfig() (cfg *tls.Config, err error) {
+	var (
+		pair tls.Certificate
+		pool *x509.CertPool
+	)
+	if pair, err = tls.LoadX509KeyPair(tlsPath(api.ServerCert),
+		tlsPath(api.ServerKey)); err != nil {
+		return
+	}
+	if pool, err = certPool(tlsPath(api.CAcert)); err != nil {
+		return
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
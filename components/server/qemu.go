@@ -0,0 +1,214 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/host/platform"
+)
+
+// qemu runs VMs via qemu-system-x86_64, either hardware accelerated (KVM)
+// or fully emulated (TCG)
+type qemu struct{ processRunner }
+
+// QemuBinary is the qemu executable to be used
+var QemuBinary = "qemu-system-x86_64"
+
+func init() {
+	registerHypervisor(qemu{})
+}
+
+func (qemu) Name() string {
+	return "qemu"
+}
+
+func (qemu) Capabilities() HypervisorCapabilities {
+	return HypervisorCapabilities{
+		Accelerator:  qemuAccelerator(),
+		Console:      ConsoleSocket,
+		Tap:          true,
+		CDROM:        true,
+		RawVolumes:   true,
+		Qcow2Volumes: true,
+		Monitor:      true,
+	}
+}
+
+// qemuAccelerator returns KVM whenever the host exposes it to us, falling
+// back to plain emulation (TCG) otherwise
+func qemuAccelerator() string {
+	if runtime.GOOS == "linux" {
+		if kvm, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0); err == nil {
+			kvm.Close()
+			return "kvm"
+		}
+	}
+	return "tcg"
+}
+
+func (q qemu) BuildArgs(vm *VMInfo) (args []string, err error) {
+	var (
+		vmlinuz, initrd string
+		cpu             = "max"
+		accel           = q.Capabilities().Accelerator
+	)
+	if vmlinuz, initrd, err = vm.bootImages(); err != nil {
+		return
+	}
+	if accel == "kvm" {
+		cpu = "host"
+	}
+	args = []string{
+		"-name", vm.Name,
+		"-uuid", vm.UUID,
+		"-machine", "q35,accel=" + accel,
+		"-cpu", cpu,
+		"-m", fmt.Sprintf("%vM", vm.Memory),
+		"-smp", fmt.Sprintf("%v", vm.Cpus),
+		"-nodefaults", "-no-user-config", "-nographic",
+		"-kernel", vmlinuz,
+		"-initrd", initrd,
+		"-append", vm.kernelCmdline(),
+		"-chardev", fmt.Sprintf("socket,id=com1,path=%s,server,nowait,"+
+			"logfile=%s,logappend=on", vm.TTY(), vm.Log()),
+		"-serial", "chardev:com1",
+		"-qmp", fmt.Sprintf("unix:%s,server,nowait", vm.Monitor()),
+		"-device", "virtio-rng-pci",
+	}
+
+	for v, vv := range vm.Ethernet {
+		netdev := fmt.Sprintf("bridge,id=net%d,br=%s", v, platform.Bridge)
+		if vv.Type == api.Tap {
+			netdev = fmt.Sprintf("tap,id=net%d,ifname=%s,script=no,"+
+				"downscript=no", v, vv.Path)
+		}
+		device := fmt.Sprintf("virtio-net-pci,netdev=net%d", v)
+		if v == 0 && vm.MacAddress != "" {
+			device = fmt.Sprintf("%s,mac=%s", device, vm.MacAddress)
+		}
+		args = append(args, "-netdev", netdev, "-device", device)
+	}
+
+	for _, v := range vm.Storage.CDDrives {
+		args = append(args, "-drive",
+			fmt.Sprintf("file=%s,media=cdrom,readonly=on,index=%d",
+				v.Path, v.Slot))
+	}
+
+	for _, v := range vm.Storage.HardDrives {
+		format := "raw"
+		if v.Format == api.Qcow2 {
+			format = "qcow2"
+		}
+		args = append(args, "-drive",
+			fmt.Sprintf("file=%s,if=virtio,format=%s,index=%d",
+				v.Path, format, v.Slot))
+	}
+
+	if vm.AddToHypervisor != "" {
+		args = append(args, strings.Fields(vm.AddToHypervisor)...)
+	}
+	return
+}
+
+func (q qemu) Start(vm *VMInfo, args []string) (err error) {
+	var binary string
+	if binary, err = exec.LookPath(QemuBinary); err != nil {
+		return fmt.Errorf("unable to find %s (%v)", QemuBinary, err)
+	}
+	return q.start(vm, binary, args)
+}
+
+// Signal asks the guest, via qemu's monitor, to power itself off cleanly
+// when told to terminate, as qemu itself would otherwise just pull the plug
+func (q qemu) Signal(vm *VMInfo, sig os.Signal) error {
+	if sig == syscall.SIGTERM {
+		err := qmpExecute(vm.Monitor(), "system_powerdown")
+		if err == nil {
+			return nil
+		}
+		log.Warn("unable to ask %v to power off via qemu's monitor "+
+			"(%v), signaling it instead", vm.Name, err)
+	}
+	return q.processRunner.Signal(vm, sig)
+}
+
+// Pause has qemu stop the guest's vCPUs, via its monitor
+func (q qemu) Pause(vm *VMInfo) error {
+	return qmpExecute(vm.Monitor(), "stop")
+}
+
+func (q qemu) Resume(vm *VMInfo) error {
+	return qmpExecute(vm.Monitor(), "cont")
+}
+
+// qmpExecute runs a single (argumentless) command over qemu's QMP socket
+func qmpExecute(socket, command string) (err error) {
+	var (
+		conn  net.Conn
+		reply struct {
+			Return json.RawMessage        `json:"return"`
+			Error  map[string]interface{} `json:"error"`
+		}
+	)
+	if conn, err = net.DialTimeout("unix", socket, 5*time.Second); err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	rd := bufio.NewReader(conn)
+	// greeting
+	if _, err = rd.ReadBytes('\n'); err != nil {
+		return
+	}
+	for _, cmd := range []string{"qmp_capabilities", command} {
+		if _, err = fmt.Fprintf(conn,
+			"{\"execute\": \"%s\"}\n", cmd); err != nil {
+			return
+		}
+		for {
+			var line []byte
+			if line, err = rd.ReadBytes('\n'); err != nil {
+				return
+			}
+			reply.Return, reply.Error = nil, nil
+			if err = json.Unmarshal(line, &reply); err != nil {
+				return
+			}
+			// asynchronous events may arrive in between
+			if reply.Return != nil || reply.Error != nil {
+				break
+			}
+		}
+		if reply.Error != nil {
+			return fmt.Errorf("qmp '%s' failed: %v", cmd, reply.Error["desc"])
+		}
+	}
+	return
+}
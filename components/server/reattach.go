@@ -0,0 +1,165 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/deis/pkg/log"
+	"github.com/keybase/go-ps"
+
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/host/session"
+)
+
+// vmState is what gets persisted, per VM, inside its run dir so that it can
+// be re-adopted by a (re)started corectld
+type vmState struct {
+	VM       *VMInfo
+	Runner   string
+	BootArgs []string
+}
+
+func (vm *VMInfo) stateFile() string {
+	return filepath.Join(vm.RunDir(), "state.json")
+}
+
+// persistState dumps, atomically, the VM's state into its run dir
+func (vm *VMInfo) persistState() (err error) {
+	var (
+		buf []byte
+		tmp = vm.stateFile() + ".tmp"
+	)
+	if buf, err = json.Marshal(&vmState{vm, vm.runner, vm.bootArgs}); err != nil {
+		return
+	}
+	// holds the VM's internal ssh private key
+	if err = ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return
+	}
+	return os.Rename(tmp, vm.stateFile())
+}
+
+// alive tells if the given state still belongs to a live runner, and not to
+// some unrelated process that got its pid recycled meanwhile
+func (s *vmState) alive() bool {
+	var (
+		p   ps.Process
+		err error
+	)
+	if s.VM.Pid <= 0 || s.Runner == "" {
+		return false
+	}
+	if err = syscall.Kill(s.VM.Pid, syscall.Signal(0)); err != nil &&
+		err != syscall.EPERM {
+		return false
+	}
+	if p, err = ps.FindProcess(s.VM.Pid); err != nil || p == nil {
+		return false
+	}
+	// process names may come truncated, but an empty one matches anything
+	return p.Executable() != "" &&
+		strings.HasPrefix(s.Runner, p.Executable())
+}
+
+// reattach walks the run dir looking for VMs left behind by a previous
+// corectld session, re-adopting the ones still alive and cleaning up after
+// the dead ones
+func (d *ServerContext) reattach() (err error) {
+	var entries []os.FileInfo
+
+	if entries, err = ioutil.ReadDir(session.Caller.RunDir()); err != nil {
+		return
+	}
+	for _, e := range entries {
+		var (
+			buf   []byte
+			state = &vmState{}
+			dir   = filepath.Join(session.Caller.RunDir(), e.Name())
+		)
+		if !e.IsDir() {
+			continue
+		}
+		if buf, err = ioutil.ReadFile(filepath.Join(dir,
+			"state.json")); err == nil {
+			err = json.Unmarshal(buf, state)
+		}
+		if err != nil || state.VM == nil || !state.alive() {
+			log.Info("cleaning up after dead VM (%v)", e.Name())
+			name := e.Name()
+			if state.VM != nil {
+				name = state.VM.Name
+			}
+			retainLog(dir, name, e.Name())
+			if err = os.RemoveAll(dir); err != nil {
+				return
+			}
+			continue
+		}
+		if err = d.adopt(state); err != nil {
+			log.Warn("unable to re-adopt '%v' (%v)", state.VM.Name, err)
+			err = nil
+		}
+	}
+	return
+}
+
+// adopt brings back under our management a VM whose runner outlived the
+// corectld session that booted it
+func (d *ServerContext) adopt(state *vmState) (err error) {
+	var (
+		hv Hypervisor
+		vm = state.VM
+	)
+	if hv, err = LookupHypervisor(vm.Hypervisor); err != nil {
+		return
+	}
+	if vm.process, err = os.FindProcess(vm.Pid); err != nil {
+		return
+	}
+	vm.runner, vm.bootArgs = state.Runner, state.BootArgs
+	vm.done = make(chan struct{})
+	close(vm.done)
+	vm.halted, vm.gone = make(chan struct{}), make(chan struct{})
+	// it already phoned home in its previous life
+	vm.callBack.Do(func() {})
+
+	d.Lock()
+	err = vm.register()
+	d.Unlock()
+	if err != nil {
+		return
+	}
+	// paused VMs are kept out of the DNS
+	if vm.PublicIP != "" && !vm.Paused {
+		if err = d.DNSServer.addRecord(vm.Name, vm.PublicIP); err != nil {
+			return fmt.Errorf("unable to restore dns records (%v)", err)
+		}
+	}
+	log.Info("re-adopted '%v' with IP %v and PID %v", vm.Name,
+		vm.PublicIP, vm.Pid)
+	vm.emit(api.EventReattached, fmt.Sprintf("pid %v", vm.Pid))
+
+	d.Jobs.Add(1)
+	go vm.supervise(hv, true)
+	return
+}
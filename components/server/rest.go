@@ -0,0 +1,334 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/blang/semver"
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/release"
+	"github.com/gorilla/mux"
+)
+
+// RESTprefix is where the REST flavour of the control API lives
+const RESTprefix = "/v1"
+
+type (
+	// restParam documents a path or query parameter
+	restParam struct {
+		Name, In, Description string
+		Required              bool
+	}
+
+	// restEndpoint maps a resource (and verb) into the matching RPC method,
+	// so that both share the exact same semantics. Body and Reply are
+	// only used to document it.
+	restEndpoint struct {
+		Method, Path, Summary string
+		Params                []restParam
+		Body, Reply           interface{}
+		Status                int
+		handle                func(r *http.Request) (interface{}, error)
+	}
+)
+
+var services = new(RPCservice)
+
+var restEndpoints = []*restEndpoint{
+	{
+		Method:  "GET",
+		Path:    "/server",
+		Summary: "corectld's version and capabilities",
+		Reply:   serverStatus{},
+		handle: func(r *http.Request) (interface{}, error) {
+			var (
+				echo = &api.EchoReply{}
+				nfs  = &api.HandlesNFSReply{}
+			)
+			if err := services.Echo(r,
+				&api.EchoArgs{APIVersion: api.APIVersion}, echo); err != nil {
+				return nil, err
+			}
+			if err := services.HandlesNFS(r, &api.NoArgs{}, nfs); err != nil {
+				return nil, err
+			}
+			return &serverStatus{echo.APIVersion, echo.Meta,
+				nfs.WorkingNFS}, nil
+		},
+	},
+	{
+		Method: "DELETE",
+		Path:   "/server",
+		Summary: "shuts corectld down, answering once all running VMs " +
+			"are gone",
+		handle: func(r *http.Request) (interface{}, error) {
+			return nil, services.Stop(r, &api.NoArgs{}, &api.NoArgs{})
+		},
+	},
+	{
+		Method:  "GET",
+		Path:    "/vms",
+		Summary: "all running VMs, indexed by UUID",
+		Reply:   api.VMmap{},
+		handle: func(r *http.Request) (interface{}, error) {
+			reply := &api.ActiveVMsReply{}
+			err := services.ActiveVMs(r, &api.NoArgs{}, reply)
+			return reply.Running, err
+		},
+	},
+	{
+		Method: "POST",
+		Path:   "/vms",
+		Summary: "boots the given, fully specified, VM (as corectl " +
+			"assembles it)",
+		Body:   api.VMInfo{},
+		Reply:  api.VMInfo{},
+		Status: http.StatusCreated,
+		handle: func(r *http.Request) (interface{}, error) {
+			var (
+				args  = &api.RunArgs{VM: &api.VMInfo{}}
+				reply = &api.VMReply{}
+			)
+			if err := json.NewDecoder(r.Body).Decode(args.VM); err != nil {
+				return nil, api.Invalid("malformed VM (%v)", err)
+			}
+			err := services.Run(r, args, reply)
+			return reply.VM, err
+		},
+	},
+	{
+		Method:  "GET",
+		Path:    "/vms/{id}",
+		Summary: "a running VM",
+		Params:  []restParam{vmIDparam},
+		Reply:   api.VMInfo{},
+		handle: func(r *http.Request) (interface{}, error) {
+			reply := &api.ActiveVMsReply{}
+			if err := services.ActiveVMs(r, &api.NoArgs{}, reply); err != nil {
+				return nil, err
+			}
+			id := mux.Vars(r)["id"]
+			for _, vm := range reply.Running {
+				if vm.Name == id || vm.UUID == id {
+					return vm, nil
+				}
+			}
+			return nil, ErrUnknownVM
+		},
+	},
+	{
+		Method:  "DELETE",
+		Path:    "/vms/{id}",
+		Summary: "halts a running VM",
+		Params: []restParam{vmIDparam, {"force", "query",
+			"hard kills the VM instead of gracefully shutting it down", false}},
+		Reply: api.StopVMsReply{},
+		handle: func(r *http.Request) (interface{}, error) {
+			reply := &api.StopVMsReply{}
+			err := services.StopVMs(r, &api.StopVMsArgs{
+				Targets: []string{mux.Vars(r)["id"]},
+				Forced:  r.URL.Query().Get("force") == "true",
+			}, reply)
+			return reply, err
+		},
+	},
+	{
+		Method:  "POST",
+		Path:    "/vms/{id}/pause",
+		Summary: "suspends a running VM, until resumed",
+		Params:  []restParam{vmIDparam},
+		Reply:   api.PauseVMsReply{},
+		handle: func(r *http.Request) (interface{}, error) {
+			reply := &api.PauseVMsReply{}
+			err := services.PauseVMs(r, &api.PauseVMsArgs{
+				Targets: []string{mux.Vars(r)["id"]},
+			}, reply)
+			return reply, err
+		},
+	},
+	{
+		Method:  "POST",
+		Path:    "/vms/{id}/resume",
+		Summary: "resumes a paused VM",
+		Params:  []restParam{vmIDparam},
+		Reply:   api.PauseVMsReply{},
+		handle: func(r *http.Request) (interface{}, error) {
+			reply := &api.PauseVMsReply{}
+			err := services.ResumeVMs(r, &api.PauseVMsArgs{
+				Targets: []string{mux.Vars(r)["id"]},
+			}, reply)
+			return reply, err
+		},
+	},
+	{
+		Method:  "GET",
+		Path:    "/images",
+		Summary: "locally available images, indexed by channel",
+		Params:  []restParam{targetParam},
+		Reply:   MediaAssets{},
+		handle: func(r *http.Request) (interface{}, error) {
+			reply := &api.ImagesReply{}
+			err := services.AvailableImages(r, &api.ImagesArgs{
+				Target: r.URL.Query().Get("target"),
+			}, reply)
+			return reply.Images, err
+		},
+	},
+	{
+		Method:  "GET",
+		Path:    "/images/{channel}",
+		Summary: "locally available images of the given channel",
+		Params:  []restParam{channelParam, targetParam},
+		Reply:   semver.Versions{},
+		handle: func(r *http.Request) (interface{}, error) {
+			reply := &api.ImagesReply{}
+			err := services.AvailableImages(r, &api.ImagesArgs{
+				Target: r.URL.Query().Get("target"),
+			}, reply)
+			return reply.Images[mux.Vars(r)["channel"]], err
+		},
+	},
+	{
+		Method:  "POST",
+		Path:    "/images/{channel}",
+		Summary: "fetches an image from upstream, if not yet local",
+		Params: []restParam{channelParam, targetParam,
+			{"version", "query", "image version (defaults to latest)", false},
+			{"force", "query", "refetches the image even if local", false}},
+		Reply:  api.PullImageReply{},
+		Status: http.StatusCreated,
+		handle: func(r *http.Request) (interface{}, error) {
+			var (
+				reply = &api.PullImageReply{}
+				args  = &api.PullImageArgs{
+					Target:   r.URL.Query().Get("target"),
+					Channel:  mux.Vars(r)["channel"],
+					Version:  r.URL.Query().Get("version"),
+					Override: r.URL.Query().Get("force") == "true",
+					Sources:  r.URL.Query()["source"],
+				}
+			)
+			if args.Version == "" {
+				args.Version = "latest"
+			}
+			err := services.PullImage(r, args, reply)
+			return reply, err
+		},
+	},
+	{
+		Method:  "GET",
+		Path:    "/images/{channel}/{version}",
+		Summary: "a locally available image's manifest, size and usage",
+		Params:  []restParam{channelParam, versionParam, targetParam},
+		Reply:   api.InspectImageReply{},
+		handle: func(r *http.Request) (interface{}, error) {
+			reply := &api.InspectImageReply{}
+			err := services.InspectImage(r, &api.ImageArgs{
+				Target:  r.URL.Query().Get("target"),
+				Channel: mux.Vars(r)["channel"],
+				Version: mux.Vars(r)["version"],
+			}, reply)
+			return reply, err
+		},
+	},
+	{
+		Method:  "DELETE",
+		Path:    "/images/{channel}/{version}",
+		Summary: "removes a locally available image",
+		Params: []restParam{channelParam, versionParam, targetParam,
+			{"force", "query", "removes the image even if pinned or in use",
+				false}},
+		Reply: MediaAssets{},
+		handle: func(r *http.Request) (interface{}, error) {
+			reply := &api.ImagesReply{}
+			err := services.RemoveImage(r, &api.RemoveImageArgs{
+				Target:  r.URL.Query().Get("target"),
+				Channel: mux.Vars(r)["channel"],
+				Version: mux.Vars(r)["version"],
+				Force:   r.URL.Query().Get("force") == "true",
+			}, reply)
+			return reply.Images, err
+		},
+	},
+}
+
+var (
+	vmIDparam    = restParam{"id", "path", "VM's name or UUID", true}
+	channelParam = restParam{"channel", "path",
+		"release channel (i.e. alpha, beta or stable)", true}
+	versionParam = restParam{"version", "path", "image version", true}
+	targetParam  = restParam{"target", "query",
+		"OS distribution (defaults to coreos)", false}
+)
+
+// serverStatus ...
+type serverStatus struct {
+	APIVersion int
+	Meta       *release.Info
+	WorkingNFS bool
+}
+
+// httpStatus maps RPC error codes into HTTP status codes
+func httpStatus(err error) int {
+	switch api.ErrorCode(err) {
+	case api.ErrCodeInvalidRequest, api.ErrCodeVersionMismatch:
+		return http.StatusBadRequest
+	case api.ErrCodeNotFound:
+		return http.StatusNotFound
+	case api.ErrCodeForbidden:
+		return http.StatusForbidden
+	case api.ErrCodeConflict, api.ErrCodeNoCapacity:
+		return http.StatusConflict
+	case api.ErrCodeShuttingDown:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func (e *restEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		out    interface{}
+		err    error
+		status = e.Status
+	)
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if out, err = e.handle(r); err != nil {
+		log.Debug("%v %v: %v", r.Method, r.URL.Path, err)
+		if _, ok := err.(*api.RPCError); !ok {
+			err = &api.RPCError{Code: api.ErrCodeInternal,
+				Message: err.Error()}
+		}
+		status, out = httpStatus(err), err
+	}
+	w.WriteHeader(status)
+	if out != nil {
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+func restServiceSetup() {
+	v1 := controlServices.PathPrefix(RESTprefix).Subrouter()
+	for _, e := range restEndpoints {
+		v1.Handle(e.Path, e).Methods(e.Method)
+	}
+	v1.HandleFunc("/openapi.json", httpOpenAPI).Methods("GET")
+}
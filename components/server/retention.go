@@ -0,0 +1,279 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/api"
+	"github.com/genevera/corectl/components/target"
+)
+
+var (
+	// ImageRetention is the policy 'corectl image prune' applies, unless
+	// given its own
+	ImageRetention api.RetentionPolicy
+	// PruneAfterPull applies ImageRetention after each pull
+	PruneAfterPull bool
+)
+
+// pinMarker, in an image's directory, keeps it from being pruned
+const pinMarker = ".pinned"
+
+func imageDir(t target.Target, channel, version string) string {
+	return filepath.Join(imageStore(t), channel, version)
+}
+
+func pinned(t target.Target, channel, version string) bool {
+	_, err := os.Stat(filepath.Join(imageDir(t, channel, version), pinMarker))
+	return err == nil
+}
+
+func pinImage(t target.Target, channel, version string,
+	pin bool) (err error) {
+	var f *os.File
+
+	marker := filepath.Join(imageDir(t, channel, version), pinMarker)
+	if _, err = os.Stat(filepath.Dir(marker)); err != nil {
+		return ErrUnknownImage
+	}
+	if !pin {
+		if err = os.Remove(marker); os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	if f, err = os.Create(marker); err == nil {
+		err = f.Close()
+	}
+	return
+}
+
+// imagesInUse returns, indexed by channel/version, the VMs running off t's
+// images. Daemon must be locked.
+func imagesInUse(t target.Target) map[string][]string {
+	inUse := make(map[string][]string)
+	for _, vm := range Daemon.Active {
+		if vm.target().Name() == t.Name() {
+			image := vm.Channel + "/" + vm.Version
+			inUse[image] = append(inUse[image], vm.Name)
+		}
+	}
+	return inUse
+}
+
+// errImageInUse and errImagePinned tell why an image can't go away
+func errImageInUse(channel, version string, vms []string, hint string) error {
+	return api.Errorf(api.ErrCodeConflict, "%s/%s is in use by %s%s",
+		channel, version, strings.Join(vms, ", "), hint)
+}
+
+func errImagePinned(channel, version, hint string) error {
+	return api.Errorf(api.ErrCodeConflict, "%s/%s is pinned%s", channel,
+		version, hint)
+}
+
+// removeImage removes the given image, unless pinned or in use by running
+// VMs (and not forced to)
+func removeImage(t target.Target, channel, version string,
+	force bool) (err error) {
+	var (
+		x     int
+		y     semver.Version
+		found bool
+	)
+
+	Daemon.Lock()
+	media := Daemon.Media[t.Name()]
+
+	for x, y = range media[channel] {
+		if found = version == y.String(); found {
+			break
+		}
+	}
+	if !found {
+		Daemon.Unlock()
+		return ErrUnknownImage
+	}
+	if !force {
+		if vms := imagesInUse(t)[channel+"/"+version]; len(vms) > 0 {
+			Daemon.Unlock()
+			return errImageInUse(channel, version, vms,
+				" (use --force to remove it anyway)")
+		}
+		if pinned(t, channel, version) {
+			Daemon.Unlock()
+			return errImagePinned(channel, version,
+				" (unpin it, or use --force, to remove it)")
+		}
+	}
+
+	log.Debug("removing %v/%v", channel, version)
+
+	media[channel] = append(media[channel][:x], media[channel][x+1:]...)
+	Daemon.Unlock()
+
+	log.Debug("%s/%s was made unavailable", channel, version)
+
+	if err = os.RemoveAll(imageDir(t, channel, version)); err != nil {
+		log.Err(err.Error())
+		return
+	}
+	// along with the initrds derived from it
+	if err = os.RemoveAll(overlayCache(t, channel, version)); err != nil {
+		log.Err(err.Error())
+	}
+	return
+}
+
+// localImage is a prune candidate
+type localImage struct {
+	channel, version string
+	created          time.Time
+	size             int64
+	// pinned, or in use by running VMs, and thus never pruned
+	pinned, inUse bool
+}
+
+func (i *localImage) String() string {
+	return i.channel + "/" + i.version
+}
+
+type byAge []*localImage
+
+func (s byAge) Less(i, j int) bool { return s[i].created.Before(s[j].created) }
+func (s byAge) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byAge) Len() int           { return len(s) }
+
+// statImage tells when the image in dir got in, and how much disk it takes
+func statImage(dir string) (i *localImage, err error) {
+	var (
+		fi os.FileInfo
+		m  *api.ImageManifest
+	)
+	i = &localImage{}
+	if fi, err = os.Stat(dir); err != nil {
+		return
+	}
+	i.created = fi.ModTime()
+	if m, err = readManifest(dir); err == nil {
+		i.created = m.Created
+	}
+	err = filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			i.size += fi.Size()
+		}
+		return err
+	})
+	return
+}
+
+// pruneImages applies the given retention policy to t's images, returning
+// which ones were removed (or, on a dry run, would be) and how much disk
+// that freed
+func pruneImages(t target.Target, p api.RetentionPolicy,
+	dryRun bool) (removed []string, freed int64, err error) {
+	var (
+		local  MediaAssets
+		i      *localImage
+		inUse  map[string][]string
+		images = make(map[string][]*localImage)
+	)
+
+	if local, err = localImages(t); err != nil {
+		return
+	}
+	Daemon.Lock()
+	inUse = imagesInUse(t)
+	Daemon.Unlock()
+
+	for channel, versions := range local {
+		// newest first
+		for rank := 0; rank < len(versions); rank++ {
+			version := versions[len(versions)-1-rank].String()
+			if i, err = statImage(imageDir(t, channel, version)); err != nil {
+				return
+			}
+			i.channel, i.version = channel, version
+			i.pinned = pinned(t, channel, version)
+			i.inUse = len(inUse[i.String()]) > 0
+			images[channel] = append(images[channel], i)
+		}
+	}
+
+	for _, i = range prunable(images, p, time.Now()) {
+		if !dryRun {
+			if err = removeImage(t, i.channel, i.version, false); err != nil {
+				// raced by a VM booting off it, most likely
+				log.Warn("%s %s kept (%v)", t.Name(), i, err)
+				continue
+			}
+			log.Info("%s %s pruned", t.Name(), i)
+		}
+		removed = append(removed, i.String())
+		freed += i.size
+	}
+	err = nil
+	return
+}
+
+// prunable picks, out of the given images (indexed by channel, newest
+// first), the ones the retention policy has removed. Each channel's newest
+// image, and the pinned or in use ones, are always kept.
+func prunable(images map[string][]*localImage, p api.RetentionPolicy,
+	now time.Time) (drop []*localImage) {
+	var (
+		channels     []string
+		total, freed int64
+		spare        []*localImage
+	)
+	for channel := range images {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	for _, channel := range channels {
+		for rank, i := range images[channel] {
+			total += i.size
+			if rank == 0 || i.pinned || i.inUse {
+				continue
+			}
+			if (p.Keep > 0 && rank >= p.Keep) ||
+				(p.MaxAge > 0 && now.Sub(i.created) > p.MaxAge) {
+				drop = append(drop, i)
+				freed += i.size
+			} else {
+				spare = append(spare, i)
+			}
+		}
+	}
+	if p.MaxDisk > 0 {
+		sort.Stable(byAge(spare))
+		// oldest first
+		for len(spare) > 0 && total-freed > p.MaxDisk<<20 {
+			drop = append(drop, spare[0])
+			freed += spare[0].size
+			spare = spare[1:]
+		}
+	}
+	return
+}
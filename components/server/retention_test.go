@@ -0,0 +1,141 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/genevera/corectl/components/api"
+)
+
+var pruneNow = time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// candidate describes a local image as channel/version, days old and taking
+// size MB, optionally "pinned" or "in use"
+func candidate(name string, days int, size int64, flags ...string) *localImage {
+	s := strings.SplitN(name, "/", 2)
+	i := &localImage{channel: s[0], version: s[1], size: size << 20,
+		created: pruneNow.AddDate(0, 0, -days)}
+	for _, f := range flags {
+		i.pinned = i.pinned || f == "pinned"
+		i.inUse = i.inUse || f == "in use"
+	}
+	return i
+}
+
+func TestPrunable(t *testing.T) {
+	// newest first, as pruneImages hands them over
+	channels := func(images ...*localImage) map[string][]*localImage {
+		m := make(map[string][]*localImage)
+		for _, i := range images {
+			m[i.channel] = append(m[i.channel], i)
+		}
+		return m
+	}
+	for _, tc := range []struct {
+		name   string
+		policy api.RetentionPolicy
+		images map[string][]*localImage
+		pruned []string
+	}{
+		{
+			name: "no policy",
+			images: channels(
+				candidate("alpha/3.0.0", 1, 200),
+				candidate("alpha/2.0.0", 90, 200),
+			),
+		},
+		{
+			name:   "keep",
+			policy: api.RetentionPolicy{Keep: 2},
+			images: channels(
+				candidate("alpha/3.0.0", 1, 200),
+				candidate("alpha/2.0.0", 20, 200),
+				candidate("alpha/1.0.0", 40, 200),
+				candidate("beta/2.0.0", 20, 200),
+				candidate("beta/1.0.0", 40, 200),
+			),
+			pruned: []string{"alpha/1.0.0"},
+		},
+		{
+			name:   "newest is always kept",
+			policy: api.RetentionPolicy{Keep: 1, MaxAge: 24 * time.Hour},
+			images: channels(
+				candidate("alpha/2.0.0", 30, 200),
+				candidate("alpha/1.0.0", 40, 200),
+				candidate("stable/1.0.0", 90, 200),
+			),
+			pruned: []string{"alpha/1.0.0"},
+		},
+		{
+			name:   "pinned and in use are kept",
+			policy: api.RetentionPolicy{Keep: 1},
+			images: channels(
+				candidate("alpha/4.0.0", 1, 200),
+				candidate("alpha/3.0.0", 20, 200, "pinned"),
+				candidate("alpha/2.0.0", 40, 200, "in use"),
+				candidate("alpha/1.0.0", 60, 200),
+			),
+			pruned: []string{"alpha/1.0.0"},
+		},
+		{
+			name:   "max age",
+			policy: api.RetentionPolicy{MaxAge: 30 * 24 * time.Hour},
+			images: channels(
+				candidate("alpha/3.0.0", 1, 200),
+				candidate("alpha/2.0.0", 20, 200),
+				candidate("alpha/1.0.0", 40, 200, "pinned"),
+				candidate("beta/2.0.0", 10, 200),
+				candidate("beta/1.0.0", 50, 200),
+			),
+			pruned: []string{"beta/1.0.0"},
+		},
+		{
+			name:   "max disk, oldest first",
+			policy: api.RetentionPolicy{MaxDisk: 500},
+			images: channels(
+				candidate("alpha/3.0.0", 1, 200),
+				candidate("alpha/2.0.0", 20, 200),
+				candidate("beta/2.0.0", 10, 200),
+				candidate("beta/1.0.0", 50, 200),
+			),
+			pruned: []string{"beta/1.0.0", "alpha/2.0.0"},
+		},
+		{
+			name:   "max disk can't go below what's kept",
+			policy: api.RetentionPolicy{MaxDisk: 100},
+			images: channels(
+				candidate("alpha/3.0.0", 1, 200),
+				candidate("alpha/2.0.0", 20, 200, "in use"),
+				candidate("alpha/1.0.0", 30, 200, "pinned"),
+				candidate("beta/1.0.0", 50, 200),
+			),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var pruned []string
+			for _, i := range prunable(tc.images, tc.policy, pruneNow) {
+				pruned = append(pruned, i.String())
+			}
+			if !reflect.DeepEqual(pruned, tc.pruned) {
+				t.Errorf("pruned %v, want %v", pruned, tc.pruned)
+			}
+		})
+	}
+}
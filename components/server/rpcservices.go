@@ -17,22 +17,21 @@ package server
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
 	"os/exec"
-	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
 
-	"os"
 	"path"
 	"time"
 
+	"github.com/genevera/corectl/components/api"
 	"github.com/genevera/corectl/components/host/session"
-	"github.com/genevera/corectl/release"
+	"github.com/genevera/corectl/components/target"
 	"github.com/blang/semver"
 	"github.com/gorilla/rpc"
 	"github.com/gorilla/rpc/json"
@@ -42,128 +41,236 @@ import (
 
 var (
 	rpcServices           = rpc.NewServer()
-	ErrServerShuttingDown = fmt.Errorf("Request ignored as server is shutting down")
-	ErrNothingToShutdown  = fmt.Errorf("Request ignored as no VMs were found running")
-	ErrUnknownVM          = fmt.Errorf("Request ignored as no VM with requested name or UUID was found")
+	ErrServerShuttingDown = api.Errorf(api.ErrCodeShuttingDown,
+		"Request ignored as server is shutting down")
+	ErrNothingToShutdown = api.Errorf(api.ErrCodeNotFound,
+		"Request ignored as no VMs were found running")
+	ErrUnknownVM = api.Errorf(api.ErrCodeNotFound,
+		"Request ignored as no VM with requested name or UUID was found")
+	ErrUnknownImage = api.Errorf(api.ErrCodeNotFound,
+		"Request ignored as requested image isn't locally available")
 )
 
-type (
-	RPCservice struct{}
-
-	RPCquery struct {
-		Input  []string
-		Forced bool
-		VM     *VMInfo
-	}
-	RPCreply struct {
-		Output     []string
-		Meta       *release.Info
-		VM         *VMInfo
-		Images     map[string]semver.Versions
-		Running    VMmap
-		WorkingNFS bool
-	}
-)
+// RPCservice ...
+type RPCservice struct{}
 
 func rpcServiceSetup() {
 	rpcServices.RegisterCodec(json.NewCodec(), "application/json")
 	rpcServices.RegisterCodec(json.NewCodec(), "application/json;charset=UTF-8")
 	rpcServices.RegisterService(new(RPCservice), "")
-	httpServices.Handle("/rpc", rpcServices)
+	controlServices.Handle("/rpc", rpcServices)
 }
 
 func (s *RPCservice) Echo(r *http.Request,
-	args *RPCquery, reply *RPCreply) (err error) {
+	args *api.EchoArgs, reply *api.EchoReply) (err error) {
 	log.Debug("ping")
+	defer rpcGuard("ping", &err)
 
-	if !Daemon.AcceptingRequests {
-		return ErrServerShuttingDown
-	}
-	reply.Meta = Daemon.Meta
-	return
+	reply.APIVersion, reply.Meta = api.APIVersion, Daemon.Meta
+	return rpcAdmit(args)
 }
+
 func (s *RPCservice) HandlesNFS(r *http.Request,
-	args *RPCquery, reply *RPCreply) (err error) {
+	args *api.NoArgs, reply *api.HandlesNFSReply) (err error) {
 	log.Debug("NFS?")
+	defer rpcGuard("NFS?", &err)
 
-	if !Daemon.AcceptingRequests {
-		return ErrServerShuttingDown
+	if err = rpcAdmit(args); err != nil {
+		return
 	}
 	reply.WorkingNFS = Daemon.WorkingNFS
 	return
 }
 
 func (s *RPCservice) AvailableImages(r *http.Request,
-	args *RPCquery, reply *RPCreply) (err error) {
+	args *api.ImagesArgs, reply *api.ImagesReply) (err error) {
 	log.Debug("images:list")
+	defer rpcGuard("images:list", &err)
 
-	if !Daemon.AcceptingRequests {
-		return ErrServerShuttingDown
+	if err = rpcAdmit(args); err != nil {
+		return
 	}
+	t, _ := target.Get(args.Target)
 	Daemon.Lock()
 	defer Daemon.Unlock()
-	reply.Images, err = localImages()
+	if reply.Images, err = localImages(t); err != nil {
+		return
+	}
+	for channel, versions := range reply.Images {
+		for _, v := range versions {
+			m, e := readManifest(path.Join(imageStore(t), channel,
+				v.String()))
+			if e == nil && !m.Verified {
+				reply.Unverified = append(reply.Unverified,
+					channel+"/"+v.String())
+			}
+			if pinned(t, channel, v.String()) {
+				reply.Pinned = append(reply.Pinned, channel+"/"+v.String())
+			}
+		}
+	}
 	return
 }
 
 func (s *RPCservice) RemoveImage(r *http.Request,
-	args *RPCquery, reply *RPCreply) (err error) {
-	var (
-		channel, version = args.Input[0], args.Input[1]
-		x                int
-		y                semver.Version
-	)
-
+	args *api.RemoveImageArgs, reply *api.ImagesReply) (err error) {
 	log.Debug("images:remove")
+	defer rpcGuard("images:remove", &err)
 
-	if !Daemon.AcceptingRequests {
-		return ErrServerShuttingDown
+	if err = rpcAdmit(args); err != nil {
+		return
+	}
+	t, _ := target.Get(args.Target)
+	if err = removeImage(t, args.Channel, args.Version,
+		args.Force); err != nil {
+		return
 	}
+	reply.Images, err = localImages(t)
+	return
+}
 
+func (s *RPCservice) InspectImage(r *http.Request,
+	args *api.ImageArgs, reply *api.InspectImageReply) (err error) {
+	var i *localImage
+
+	log.Debug("images:inspect")
+	defer rpcGuard("images:inspect", &err)
+
+	if err = rpcAdmit(args); err != nil {
+		return
+	}
+	t, _ := target.Get(args.Target)
+	dir := imageDir(t, args.Channel, args.Version)
+	if i, err = statImage(dir); err != nil {
+		return ErrUnknownImage
+	}
+	reply.Size = i.size
+	reply.Manifest, _ = readManifest(dir)
+	reply.Pinned = pinned(t, args.Channel, args.Version)
 	Daemon.Lock()
+	reply.InUse = imagesInUse(t)[args.Channel+"/"+args.Version]
+	Daemon.Unlock()
+	return
+}
 
-	for x, y = range Daemon.Media[channel] {
-		if version == y.String() {
-			break
+func (s *RPCservice) VerifyImages(r *http.Request,
+	args *api.VerifyImagesArgs, reply *api.VerifyImagesReply) (err error) {
+	var (
+		local    MediaAssets
+		problems []string
+	)
+	log.Debug("images:verify")
+	defer rpcGuard("images:verify", &err)
+
+	if err = rpcAdmit(args); err != nil {
+		return
+	}
+	t, _ := target.Get(args.Target)
+	if args.Version != "" {
+		local = MediaAssets{args.Channel: {semver.MustParse(args.Version)}}
+	} else if local, err = localImages(t); err != nil {
+		return
+	} else if args.Channel != "" {
+		local = MediaAssets{args.Channel: local[args.Channel]}
+	}
+	reply.Problems = make(map[string][]string)
+	for channel, versions := range local {
+		for _, v := range versions {
+			image := channel + "/" + v.String()
+			if problems, err = verifyImage(t, channel,
+				v.String()); err != nil {
+				return
+			}
+			reply.Checked = append(reply.Checked, image)
+			if len(problems) > 0 {
+				log.Warn("%s %s failed verification: %s", t.Name(), image,
+					strings.Join(problems, "; "))
+				reply.Problems[image] = problems
+			}
 		}
 	}
+	sort.Strings(reply.Checked)
+	return
+}
 
-	log.Debug("removing %v/%v", channel, version)
+func (s *RPCservice) PinImage(r *http.Request,
+	args *api.PinImageArgs, reply *api.ImagesReply) (err error) {
+	log.Debug("images:pin")
+	defer rpcGuard("images:pin", &err)
 
-	Daemon.Media[channel] =
-		append(Daemon.Media[channel][:x], Daemon.Media[channel][x+1:]...)
-	Daemon.Unlock()
+	if err = rpcAdmit(args); err != nil {
+		return
+	}
+	t, _ := target.Get(args.Target)
+	if err = pinImage(t, args.Channel, args.Version, args.Pin); err != nil {
+		return
+	}
+	reply.Images, err = localImages(t)
+	return
+}
 
-	log.Debug("%s/%s was made unavailable", channel, version)
+func (s *RPCservice) PruneImages(r *http.Request,
+	args *api.PruneImagesArgs, reply *api.PruneImagesReply) (err error) {
+	log.Debug("images:prune")
+	defer rpcGuard("images:prune", &err)
 
-	if err = os.RemoveAll(path.
-		Join(session.Caller.ImageStore(), channel, y.String())); err != nil {
-		log.Err(err.Error())
+	if err = rpcAdmit(args); err != nil {
 		return
 	}
+	t, _ := target.Get(args.Target)
+	policy := ImageRetention
+	if args.Policy != nil {
+		policy = *args.Policy
+	}
+	if policy.IsZero() {
+		return api.Invalid("no retention policy given, and corectld " +
+			"has none set")
+	}
+	reply.Removed, reply.Freed, err = pruneImages(t, policy, args.DryRun)
+	return
+}
 
-	reply.Images, err = localImages()
+func (s *RPCservice) PullImage(r *http.Request,
+	args *api.PullImageArgs, reply *api.PullImageReply) (err error) {
+	log.Debug("images:pull")
+	defer rpcGuard("images:pull", &err)
+
+	if err = rpcAdmit(args); err != nil {
+		return
+	}
+	t, _ := target.Get(args.Target)
+	sources, _ := target.ParseSources(args.Sources)
+	for _, s := range sources {
+		// remote clients have no business poking at our filesystem
+		if s.IsLocal() && r.TLS != nil {
+			return api.Invalid("local image sources (%v) are only "+
+				"available to local clients", s)
+		}
+	}
+	reply.Version, err = pullImage(t, args.Channel, args.Version,
+		args.Override, args.PreferLocal, sources)
 	return
 }
 
 func (s *RPCservice) UUIDtoMACaddr(r *http.Request,
-	args *RPCquery, reply *RPCreply) (err error) {
+	args *api.UUIDtoMACaddrArgs, reply *api.UUIDtoMACaddrReply) (err error) {
 	var (
 		i              int
 		macAddr        string
 		stdout         io.ReadCloser
-		UUID, original = args.Input[0], args.Input[1]
+		UUID, original = args.UUID, args.Requested
 	)
-	log.Debug("vm:uuid2mac (%v:%v)", args.Input[0], args.Input[1])
+	log.Debug("vm:uuid2mac (%v:%v)", args.UUID, args.Requested)
+	defer rpcGuard("vm:uuid2mac", &err)
 
-	if !Daemon.AcceptingRequests {
-		return ErrServerShuttingDown
+	if err = rpcAdmit(args); err != nil {
+		return
 	}
 
 	// handles UUIDs
 	if _, found := Daemon.Active[UUID]; found {
-		err = fmt.Errorf("Aborted: Another VM is already running with the "+
-			"exact same UUID [%s]", UUID)
+		err = api.Errorf(api.ErrCodeConflict, "Aborted: Another VM is already "+
+			"running with the exact same UUID [%s]", UUID)
 	} else {
 		for i < 3 {
 			//
@@ -217,88 +324,93 @@ func (s *RPCservice) UUIDtoMACaddr(r *http.Request,
 				"this error and wait there for our feedback...")
 		}
 	}
-	reply.Output = []string{macAddr, strings.ToUpper(UUID)}
+	reply.MacAddress, reply.UUID = macAddr, strings.ToUpper(UUID)
 	return
 }
 
 func (s *RPCservice) Run(r *http.Request,
-	args *RPCquery, reply *RPCreply) (err error) {
+	args *api.RunArgs, reply *api.VMReply) (err error) {
 	log.Debug("vm:run")
+	defer rpcGuard("vm:run", &err)
 
-	if !Daemon.AcceptingRequests {
-		return ErrServerShuttingDown
+	if err = rpcAdmit(args); err != nil {
+		return
 	}
 
-	var bootArgs []string
-	vm := args.VM
+	var (
+		bootArgs []string
+		hv       Hypervisor
+		vm       = &VMInfo{VMInfo: *args.VM}
+	)
+
+	if vm.Hypervisor == "" {
+		vm.Hypervisor = DefaultHypervisor
+	}
+	if hv, err = LookupHypervisor(vm.Hypervisor); err != nil {
+		return
+	}
+	if err = hv.Capabilities().supports(vm); err != nil {
+		return
+	}
+
+	vm.Owner = ownerOf(r)
+	// remote clients have no business poking at our filesystem
+	if vm.InitrdOverlay != "" && r.TLS != nil {
+		return api.Invalid("initrd overlays are only available to local " +
+			"clients")
+	}
 
 	vm.publicIPCh = make(chan string, 1)
 	vm.errCh = make(chan error, 1)
 	vm.done = make(chan struct{})
+	vm.halted, vm.gone = make(chan struct{}), make(chan struct{})
 
-	if err = vm.register(); err != nil {
+	if err = vm.reserve(); err != nil {
 		return
 	}
 
-	if bootArgs, err = vm.assembleBootPayload(); err != nil {
+	if bootArgs, err = hv.BuildArgs(vm); err != nil {
+		vm.release()
 		return
 	}
+	vm.bootArgs = bootArgs
 	if err = vm.MkRunDir(); err != nil {
+		vm.release()
 		return
 	}
 	vm.CreationTime = time.Now()
 
-	payload := append(strings.Split(bootArgs[0], " "),
-		"-f", fmt.Sprintf("%s%v", bootArgs[1], bootArgs[2]))
-	vm.exec = exec.Command(filepath.Join(session.ExecutableFolder(),
-		"corectld.runner"), payload...)
-
 	go func() {
 		timeout := time.After(ServerTimeout)
 		select {
 		case <-timeout:
-			vm.Pid = vm.exec.Process.Pid
-			vm.gracefullyShutdown()
+			vm.gracefullyShutdown(vm.stopTimeout())
 			vm.errCh <- fmt.Errorf("Unable to grab VM's IP after " +
 				"30s (!)... Aborted")
 		case ip := <-vm.publicIPCh:
 			Daemon.Lock()
-			vm.Pid, vm.PublicIP = vm.exec.Process.Pid, ip
+			vm.PublicIP = ip
+			if err := vm.persistState(); err != nil {
+				log.Warn("unable to persist %v's state (%v), so it won't "+
+					"survive a corectld restart", vm.Name, err)
+			}
 			Daemon.Unlock()
 			close(vm.publicIPCh)
 			close(vm.done)
 			log.Info("started '%s' in background with IP %v and "+
-				"PID %v\n", vm.Name, vm.PublicIP, vm.exec.Process.Pid)
+				"PID %v\n", vm.Name, vm.PublicIP, vm.Pid)
 		}
 	}()
 
-	go func() {
-		Daemon.Jobs.Add(1)
-		defer Daemon.Jobs.Done()
-		Daemon.Lock()
-		vm.exec.SysProcAttr = &syscall.SysProcAttr{
-			Setpgid: true,
-			Setsid:  false,
-			Pgid:    0,
-		}
-		err := vm.exec.Start()
-		Daemon.Unlock()
-		if err != nil {
-			vm.errCh <- err
-		}
-		vm.exec.Wait()
-		Daemon.Lock()
-		vm.deregister()
-		Daemon.Unlock()
-		os.Remove(vm.TTY())
-	}()
+	Daemon.Jobs.Add(1)
+	go vm.supervise(hv, false)
 
 	select {
 	case <-vm.done:
 		if len(vm.PublicIP) == 0 {
 			err = fmt.Errorf("VM terminated abnormally too early")
 		}
-		reply.VM = vm
+		reply.VM = vm.public()
 		return
 	case err = <-vm.errCh:
 		return
@@ -308,11 +420,16 @@ func (s *RPCservice) Run(r *http.Request,
 }
 
 func (s *RPCservice) Stop(r *http.Request,
-	args *RPCquery, reply *RPCreply) (err error) {
+	args *api.NoArgs, reply *api.NoArgs) (err error) {
 	log.Debug("server:stop")
+	defer rpcGuard("server:stop", &err)
 
-	if !Daemon.AcceptingRequests {
-		return ErrServerShuttingDown
+	if err = rpcAdmit(args); err != nil {
+		return
+	}
+
+	if err = mayManage(r, "corectld", session.Caller.Username); err != nil {
+		return
 	}
 
 	log.Info("Sky must be falling. Shutting down...")
@@ -320,35 +437,61 @@ func (s *RPCservice) Stop(r *http.Request,
 	Daemon.AcceptingRequests = false
 	Daemon.Unlock()
 
-	Daemon.Active.array().gracefullyShutdown()
+	if killed := Daemon.Active.array().gracefullyShutdown(
+		time.Now().Add(ShutdownDeadline)); len(killed) > 0 {
+		log.Warn("%v had to be hard killed", killed)
+	}
 	Daemon.Oops <- nil
 	return
 }
 
 func (s *RPCservice) ActiveVMs(r *http.Request,
-	args *RPCquery, reply *RPCreply) (err error) {
+	args *api.NoArgs, reply *api.ActiveVMsReply) (err error) {
 	log.Debug("vm:list")
+	defer rpcGuard("vm:list", &err)
 
-	if !Daemon.AcceptingRequests {
-		return ErrServerShuttingDown
+	if err = rpcAdmit(args); err != nil {
+		return
 	}
 
-	reply.Running = Daemon.Active
+	Daemon.Lock()
+	reply.Running = Daemon.Active.array().ownedBy(r).public()
+	Daemon.Unlock()
+	return
+}
+
+func (s *RPCservice) SSHkey(r *http.Request,
+	args *api.SSHkeyArgs, reply *api.SSHkeyReply) (err error) {
+	var targets VMs
+	log.Debug("vm:sshkey")
+	defer rpcGuard("vm:sshkey", &err)
+
+	if err = rpcAdmit(args); err != nil {
+		return
+	}
+	if targets, err = targetsOf([]string{args.VM}); err != nil {
+		return
+	}
+	if err = mayManage(r, targets[0].Name, targets[0].Owner); err != nil {
+		return
+	}
+	reply.Private = targets[0].InternalSSHprivate
 	return
 }
 
 func (s *RPCservice) StopVMs(r *http.Request,
-	args *RPCquery, reply *RPCreply) (err error) {
+	args *api.StopVMsArgs, reply *api.StopVMsReply) (err error) {
 	log.Debug("vm:stop")
+	defer rpcGuard("vm:stop", &err)
 
 	var targets VMs
 
-	if !Daemon.AcceptingRequests {
-		return ErrServerShuttingDown
+	if err = rpcAdmit(args); err != nil {
+		return
 	}
 
-	if len(args.Input) == 0 {
-		active := Daemon.Active.array()
+	if len(args.Targets) == 0 {
+		active := Daemon.Active.array().ownedBy(r)
 		if len(active) == 0 {
 			return ErrNothingToShutdown
 		}
@@ -357,22 +500,21 @@ func (s *RPCservice) StopVMs(r *http.Request,
 		} else {
 			// random pick
 			targets = append(targets, active[rand.Intn(len(active))])
-			reply.Output = append(reply.Output, targets[0].Name)
-		}
-	} else {
-		for _, t := range args.Input {
-			for _, v := range Daemon.Active {
-				if v.Name == t || v.UUID == t {
-					targets = append(targets, v)
-				}
-			}
 		}
-		if len(targets) != len(args.Input) {
-			return ErrUnknownVM
+	} else if targets, err = targetsOf(args.Targets); err != nil {
+		return
+	}
+	for _, t := range targets {
+		if err = mayManage(r, t.Name, t.Owner); err != nil {
+			return
 		}
 	}
+	for _, t := range targets {
+		reply.Stopped = append(reply.Stopped, t.Name)
+	}
 	if !args.Forced {
-		targets.gracefullyShutdown()
+		reply.Killed = targets.gracefullyShutdown(
+			time.Now().Add(ShutdownDeadline))
 	} else {
 		targets[0].kill()
 	}
@@ -380,40 +522,101 @@ func (s *RPCservice) StopVMs(r *http.Request,
 	return
 }
 
-func RPCQuery(f string, args *RPCquery) (reply *RPCreply, err error) {
-	var (
-		message []byte
-		req     *http.Request
-		resp    *http.Response
-		client  = new(http.Client)
-		server  = "http://" + session.Caller.ServerAddress + "/rpc"
-	)
-	if message, err =
-		json.EncodeClientRequest("RPCservice."+f, args); err != nil {
+func (s *RPCservice) CreateVM(r *http.Request,
+	args *api.CreateVMArgs, reply *api.VMReply) (err error) {
+	log.Debug("vm:create")
+	defer rpcGuard("vm:create", &err)
+
+	if err = rpcAdmit(args); err != nil {
 		return
 	}
-	if req, err =
-		http.NewRequest("POST", server, bytes.NewBuffer(message)); err != nil {
+
+	args.VM.Owner = ownerOf(r)
+	if args.Overwrite {
+		if old, e := Daemon.definition(args.VM.Name); e == nil {
+			if err = mayManage(r, old.Name, old.Owner); err != nil {
+				return
+			}
+		}
+	}
+	if err = Daemon.saveDefinition(args.VM, args.Overwrite); err != nil {
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if resp, err = client.Do(req); err != nil {
-		err = fmt.Errorf("Error in sending request to %s. %s", server, err)
+	log.Info("stored definition of '%v' (%v)", args.VM.Name, args.VM.UUID)
+	reply.VM = definitionOf(args.VM)
+	return
+}
+
+func (s *RPCservice) DefinedVMs(r *http.Request,
+	args *api.DefinedVMsArgs, reply *api.DefinedVMsReply) (err error) {
+	var vm *api.VMInfo
+	log.Debug("vm:definitions")
+	defer rpcGuard("vm:definitions", &err)
+
+	if err = rpcAdmit(args); err != nil {
+		return
+	}
+
+	if len(args.Names) == 0 {
+		var defs api.VMmap
+		if defs, err = Daemon.definitions(); err != nil {
+			return
+		}
+		reply.Defined = make(api.VMmap)
+		for name, vm := range defs {
+			if mayManage(r, name, vm.Owner) == nil {
+				reply.Defined[name] = vm
+			}
+		}
 		return
 	}
-	defer resp.Body.Close()
-	if err = json.DecodeClientResponse(resp.Body, &reply); err != nil {
-		err = fmt.Errorf("%s", err)
+	reply.Defined = make(api.VMmap)
+	for _, name := range args.Names {
+		if vm, err = Daemon.definition(name); err != nil {
+			return
+		}
+		if err = mayManage(r, name, vm.Owner); err != nil {
+			return
+		}
+		reply.Defined[name] = vm
 	}
 	return
 }
 
-func (cfg *ServerContext) Running() (i *release.Info, err error) {
-	reply := &RPCreply{}
-	if reply, err = RPCQuery("Echo", &RPCquery{}); err != nil {
-		err = session.ErrServerUnreachable
-	} else {
-		i = reply.Meta
+func (s *RPCservice) RemoveVM(r *http.Request,
+	args *api.RemoveVMArgs, reply *api.RemoveVMReply) (err error) {
+	var vm *api.VMInfo
+	log.Debug("vm:remove")
+	defer rpcGuard("vm:remove", &err)
+
+	if err = rpcAdmit(args); err != nil {
+		return
+	}
+
+	for _, name := range args.Names {
+		if vm, err = Daemon.definition(name); err != nil {
+			return
+		}
+		if err = mayManage(r, name, vm.Owner); err != nil {
+			return
+		}
+		Daemon.Lock()
+		active := Daemon.Active.bootedFrom(vm)
+		Daemon.Unlock()
+		if active != nil {
+			if err = mayManage(r, active.Name, active.Owner); err != nil {
+				return
+			}
+		}
+		if active != nil && !args.Forced {
+			return api.Errorf(api.ErrCodeConflict, "Request ignored as '%v' is "+
+				"still running, please stop it first", name)
+		}
+		if err = Daemon.removeDefinition(name); err != nil {
+			return
+		}
+		log.Info("removed definition of '%v' (%v)", vm.Name, vm.UUID)
+		reply.Removed = append(reply.Removed, name)
 	}
 	return
 }
@@ -0,0 +1,50 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"runtime/debug"
+
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/api"
+)
+
+// rpcGuard is to be deferred by every RPC method. It keeps a misbehaving
+// request from taking the daemon down with it and makes sure that whatever
+// error gets returned carries a code.
+func rpcGuard(op string, err *error) {
+	if r := recover(); r != nil {
+		log.Err("%v: recovered from panic: %v\n%s", op, r, debug.Stack())
+		*err = api.Errorf(api.ErrCodeInternal, "%v failed unexpectedly (%v)",
+			op, r)
+		return
+	}
+	if *err == nil {
+		return
+	}
+	if _, ok := (*err).(*api.RPCError); !ok {
+		*err = &api.RPCError{Code: api.ErrCodeInternal, Message: (*err).Error()}
+	}
+}
+
+// rpcAdmit rejects requests while shutting down, or whose arguments
+// don't make sense
+func rpcAdmit(args api.Args) error {
+	if !Daemon.AcceptingRequests {
+		return ErrServerShuttingDown
+	}
+	return args.Validate()
+}
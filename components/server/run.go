@@ -25,6 +25,7 @@ import (
 	"sort"
 	"syscall"
 
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -33,68 +34,50 @@ import (
 	"sync"
 	"time"
 
+	"github.com/genevera/corectl/components/api"
 	"github.com/genevera/corectl/components/host/session"
+	"github.com/genevera/corectl/components/server/connector"
+	"github.com/genevera/corectl/components/target"
 	"github.com/deis/pkg/log"
-	"github.com/dustin/go-humanize"
 	"golang.org/x/crypto/ssh"
 )
 
 type (
-	// VMInfo - per VM settings
+	// VMInfo - per VM settings, along with what corectld needs to keep
+	// track of it while running
 	VMInfo struct {
-		Name, Channel, Version, UUID            string
-		MacAddress, PublicIP                    string
-		InternalSSHkey, InternalSSHprivate      string
-		Cpus, Memory, Pid                       int
-		SSHkey, CloudConfig, CClocation         string `json:",omitempty"`
-		AddToHypervisor, AddToKernel            string `json:",omitempty"`
-		Ethernet                                []NetworkInterface
-		Storage                                 StorageAssets `json:",omitempty"`
-		SharedHomedir, OfflineMode, NotIsolated bool
-		FormatRoot, PersistentRoot              bool
-		CreationTime                            time.Time
+		api.VMInfo
 
 		publicIPCh               chan string
 		errCh                    chan error
 		done                     chan struct{}
+		halted, gone             chan struct{}
 		exec                     *exec.Cmd
+		process                  *os.Process
+		runner                   string
+		bootArgs                 []string
 		isolationCheck, callBack sync.Once
+		haltOnce                 sync.Once
 		cloudConfigContents      []byte
+		// pausing is set while the VM is being paused or resumed, which
+		// happens without holding Daemon's lock, and closed once done
+		pausing chan struct{}
 	}
 	//
 	VMmap map[string]*VMInfo
 	// Config ...
 	VMs []*VMInfo
-	// NetworkInterface ...
-	NetworkInterface struct {
-		Type int
-		// if/when tap...
-		Path string `json:",omitempty"`
-	}
-	// StorageDevice ...
-	StorageDevice struct {
-		Slot, Format int
-		Type, Path   string
-	}
-	// StorageAssets ...
-	StorageAssets struct {
-		CDDrives, HardDrives map[string]StorageDevice `json:",omitempty"`
-	}
 )
 
-const (
-	_ = iota
-	Raw
-	Qcow2
-	Tap
-	HDD    = "HDD"
-	CDROM  = "CDROM"
-	Local  = "localfs"
-	Remote = "URL"
+var (
+	ServerTimeout = 25 * time.Second
+	// DefaultStopTimeout is how long VMs that don't set their own are given
+	// to power off before being hard killed
+	DefaultStopTimeout = 30 * time.Second
+	// ShutdownDeadline bounds how long halting a batch of VMs may take
+	ShutdownDeadline = 2 * time.Minute
 )
 
-var ServerTimeout = 25 * time.Second
-
 // ValidateCDROM ...
 func (vm *VMInfo) ValidateCDROM(path string) (err error) {
 	if path == "" {
@@ -111,9 +94,9 @@ func (vm *VMInfo) ValidateCDROM(path string) (err error) {
 	if abs, err = filepath.Abs(path); err != nil {
 		return
 	}
-	vm.Storage.CDDrives = make(map[string]StorageDevice, 0)
-	vm.Storage.CDDrives["0"] = StorageDevice{
-		Type: CDROM, Slot: 0, Path: abs,
+	vm.Storage.CDDrives = make(map[string]api.StorageDevice, 0)
+	vm.Storage.CDDrives["0"] = api.StorageDevice{
+		Type: api.CDROM, Slot: 0, Path: abs,
 	}
 	return
 }
@@ -123,7 +106,7 @@ func (vm *VMInfo) ValidateVolumes(volumes []string, root bool) (err error) {
 	var (
 		abs    string
 		fh     *os.File
-		format = Qcow2
+		format = api.Qcow2
 	)
 
 	for _, j := range volumes {
@@ -145,35 +128,18 @@ func (vm *VMInfo) ValidateVolumes(volumes []string, root bool) (err error) {
 				log.Warn("using Raw formated volumes is a deprecated feature " +
 					"that may become unsupported in the future. Please " +
 					"consider moving to QCOW2 ones")
-				format = Raw
+				format = api.Raw
 				err = nil
 			}
-			if format == Raw {
+			if format == api.Raw {
 				// to be consistent with previous behaviour
 				if !strings.HasSuffix(j, ".img") {
 					return fmt.Errorf("Aborting: --volume payload MUST end"+
 						" in '.img' ('%s' doesn't)", j)
 				}
 			}
-			// check atomicity
-			reply := &RPCreply{}
-
-			if reply, err = RPCQuery("ActiveVMs", &RPCquery{}); err != nil {
-				return
-			}
-
-			for _, d := range reply.Running {
-				for _, vv := range d.Storage.HardDrives {
-					if abs == vv.Path {
-						return fmt.Errorf("Aborting: %s %s (%s)", abs,
-							"already being used as a volume by another VM.",
-							vv.Path)
-					}
-				}
-			}
-
 			if vm.Storage.HardDrives == nil {
-				vm.Storage.HardDrives = make(map[string]StorageDevice, 0)
+				vm.Storage.HardDrives = make(map[string]api.StorageDevice, 0)
 			}
 
 			slot := len(vm.Storage.HardDrives)
@@ -184,7 +150,8 @@ func (vm *VMInfo) ValidateVolumes(volumes []string, root bool) (err error) {
 				}
 			}
 			vm.Storage.HardDrives[strconv.Itoa(slot)] =
-				StorageDevice{Type: HDD, Format: format, Slot: slot, Path: abs}
+				api.StorageDevice{Type: api.HDD, Format: format, Slot: slot,
+					Path: abs}
 			if root {
 				vm.PersistentRoot = root
 			}
@@ -206,7 +173,7 @@ func (vm *VMInfo) ValidateCloudConfig(config string) (err error) {
 
 		if err == nil && (response.StatusCode == http.StatusOK ||
 			response.StatusCode == http.StatusNoContent) {
-			vm.CClocation = Remote
+			vm.CClocation = api.Remote
 			return
 		}
 
@@ -216,7 +183,7 @@ func (vm *VMInfo) ValidateCloudConfig(config string) (err error) {
 		if _, err = os.Stat(vm.CloudConfig); err != nil {
 			return
 		}
-		vm.CClocation = Local
+		vm.CClocation = api.Local
 	}
 	return
 }
@@ -246,37 +213,41 @@ func (vm *VMInfo) SSHkeyGen() (err error) {
 	return
 }
 
-func (vm *VMInfo) assembleBootPayload() (xArgs []string, err error) {
-	var (
-		cmdline = "earlyprintk=serial console=ttyS0 coreos.autologin coreos.first_boot=1"
-		prefix  = "coreos_production_pxe"
-		vmlinuz = fmt.Sprintf("%s/%s/%s/%s.vmlinuz",
-			session.Caller.ImageStore(), vm.Channel, vm.Version,
-			prefix)
-		initrd = fmt.Sprintf("%s/%s/%s/%s_image.cpio.gz",
-			session.Caller.ImageStore(), vm.Channel, vm.Version,
-			prefix)
-		instr = []string{
-			"-s", "0:0,hostbridge",
-			"-l", "com1,autopty=" + vm.TTY() + ",log=" + vm.Log(),
-			"-s", "5,virtio-rnd",
-			"-s", "31,lpc",
-			"-U", vm.UUID,
-			"-m", fmt.Sprintf("%vM", vm.Memory),
-			"-c", fmt.Sprintf("%v", vm.Cpus),
-			"-A",
-			"-u",
-		}
-	)
+// target returns the OS distribution the VM runs
+func (vm *VMInfo) target() target.Target {
+	t, err := target.Get(vm.Target)
+	if err != nil {
+		log.Err(err.Error())
+		t, _ = target.Get(target.Default)
+	}
+	return t
+}
+
+// bootImages returns the location of the kernel and initrd the VM boots
+// from, the later derived from the image's own if the VM has an overlay
+func (vm *VMInfo) bootImages() (vmlinuz, initrd string, err error) {
+	t := vm.target()
+	kernel, ramdisk, _ := t.Artifacts()
+	dir := imageDir(t, vm.Channel, vm.Version)
+	vmlinuz, initrd = filepath.Join(dir, kernel), filepath.Join(dir, ramdisk)
+	if vm.InitrdOverlay != "" {
+		initrd, err = overlaidInitrd(t, vm.Channel, vm.Version,
+			vm.InitrdOverlay)
+	}
+	return
+}
+
+func (vm *VMInfo) kernelCmdline() string {
+	cmdline := vm.target().Cmdline(vm.endpoint() + "/ignition")
+
 	if vm.PersistentRoot {
 		cmdline = fmt.Sprintf("%s root=LABEL=ROOT", cmdline)
 	}
 
-	cmdline = fmt.Sprintf("%s corectl.hostname=%s  coreos.config.url=%s",
-		cmdline, vm.Name, vm.endpoint()+"/ignition")
+	cmdline = fmt.Sprintf("%s corectl.hostname=%s", cmdline, vm.Name)
 
 	if vm.CloudConfig != "" {
-		if vm.CClocation == Local {
+		if vm.CClocation == api.Local {
 			cmdline = fmt.Sprintf("%s cloud-config-url=%s",
 				cmdline, vm.endpoint()+"/cloud-config")
 		} else {
@@ -285,90 +256,156 @@ func (vm *VMInfo) assembleBootPayload() (xArgs []string, err error) {
 		}
 	}
 
-	if vm.AddToHypervisor != "" {
-		instr = append(instr, vm.AddToHypervisor)
-	}
-
 	if vm.AddToKernel != "" {
 		cmdline = fmt.Sprintf("%s %s", cmdline, vm.AddToKernel)
 	}
+	return cmdline
+}
 
-	for v, vv := range vm.Ethernet {
-		if vv.Type == Tap {
-			instr = append(instr, "-s",
-				fmt.Sprintf("2:%d,virtio-tap,%v", v, vv.Path))
-		} else {
-			instr = append(instr, "-s",
-				fmt.Sprintf("2:%d,virtio-net", v))
-		}
-	}
-
-	for _, v := range vm.Storage.CDDrives {
-		instr = append(instr, "-s", fmt.Sprintf("3:%d,ahci-cd,%s",
-			v.Slot, v.Path))
-	}
-
-	for _, v := range vm.Storage.HardDrives {
-		switch v.Format {
-		case Raw:
-			instr = append(instr, "-s", fmt.Sprintf("4:%d,virtio-blk,%s",
-				v.Slot, v.Path))
-		case Qcow2:
-			instr = append(instr, "-s",
-				fmt.Sprintf("4:%d,virtio-blk,file://%s,format=qcow",
-					v.Slot, v.Path))
-		}
+// hypervisor returns the backend the VM runs on
+func (vm *VMInfo) hypervisor() Hypervisor {
+	h, err := LookupHypervisor(vm.Hypervisor)
+	if err != nil {
+		log.Err(err.Error())
+		h, _ = LookupHypervisor(DefaultHypervisor)
 	}
-
-	return []string{strings.Join(instr, " "),
-			fmt.Sprintf("kexec,%s,%s,", vmlinuz, initrd),
-			fmt.Sprintf("%v", cmdline)},
-		err
+	return h
 }
 
-func (list VMs) gracefullyShutdown() {
+// gracefullyShutdown halts, in parallel, all listed VMs, each only after
+// the listed ones depending on it are gone, returning which ones had to be
+// hard killed. Past the deadline nothing is waited for anymore.
+func (list VMs) gracefullyShutdown(deadline time.Time) (killed []string) {
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
 	sort.Sort(sort.Reverse(VMs(list)))
 
 	for _, v := range list {
-		log.Info("shutting down %v...", v.Name)
-		Daemon.Active[v.UUID].gracefullyShutdown()
-		for {
-			Daemon.Lock()
-			_, stillAlive := Daemon.Active[v.UUID]
-			Daemon.Unlock()
-			if !stillAlive {
-				break
+		var dependents []*VMInfo
+		for _, d := range list {
+			for _, name := range d.DependsOn {
+				if name == v.Name && d != v {
+					dependents = append(dependents, d)
+				}
 			}
-			time.Sleep(100 * time.Millisecond)
 		}
+		wg.Add(1)
+		go func(vm *VMInfo, dependents []*VMInfo) {
+			defer wg.Done()
+			for _, d := range dependents {
+				select {
+				case <-d.gone:
+				case <-time.After(deadline.Sub(time.Now())):
+				}
+			}
+			timeout := vm.stopTimeout()
+			if left := deadline.Sub(time.Now()); left < timeout {
+				timeout = left
+			}
+			log.Info("shutting down %v...", vm.Name)
+			if vm.gracefullyShutdown(timeout) {
+				mu.Lock()
+				killed = append(killed, vm.Name)
+				mu.Unlock()
+			}
+		}(v, dependents)
 	}
+	wg.Wait()
+	sort.Strings(killed)
 	return
 }
 
+func (vm *VMInfo) stopTimeout() time.Duration {
+	if vm.StopTimeout > 0 {
+		return time.Duration(vm.StopTimeout) * time.Second
+	}
+	return DefaultStopTimeout
+}
+
 func (vm *VMInfo) kill() {
 	log.Debug("hard killing %v", vm.Name)
-	if err := vm.exec.Process.Kill(); err != nil {
+	vm.halt()
+	if err := vm.hypervisor().Signal(vm, os.Kill); err != nil {
 		log.Err(err.Error())
+		return
 	}
+	vm.emit(api.EventKilled, "")
 }
 
-func (vm *VMInfo) gracefullyShutdown() {
-	// Try to gracefully terminate the process.
-	if err := vm.exec.Process.Signal(syscall.SIGTERM); err != nil {
-		log.Err(err.Error())
+// powerOff asks the guest, over the internal ssh channel, to power itself
+// off cleanly (so that whatever it's got buffered reaches its volumes)
+func (vm *VMInfo) powerOff(timeout time.Duration) (err error) {
+	var conn net.Conn
+
+	if vm.PublicIP == "" || vm.InternalSSHprivate == "" {
+		return fmt.Errorf("not reachable over ssh")
+	}
+	if conn, err = net.DialTimeout("tcp", net.JoinHostPort(vm.PublicIP,
+		"22"), timeout); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	return connector.Execute(conn, vm.InternalSSHprivate,
+		"sudo systemctl poweroff --no-block")
+}
+
+// gracefullyShutdown asks the VM to power off, first over ssh and, failing
+// that, via its runner, hard killing it if it's still around once timeout
+// expires. It returns whether it had to be hard killed.
+func (vm *VMInfo) gracefullyShutdown(timeout time.Duration) (killed bool) {
+	vm.halt()
+	vm.emit(api.EventStopping, "")
+	expired := time.After(timeout)
+
+	// a paused guest can't power itself off. As halted VMs don't get
+	// paused anymore, only an ongoing pause (or resume) is to be waited for.
+	Daemon.Lock()
+	pausing, paused := vm.pausing, vm.Paused
+	Daemon.Unlock()
+	if pausing != nil {
+		select {
+		case <-pausing:
+		case <-expired:
+		}
+		Daemon.Lock()
+		paused = vm.Paused
+		Daemon.Unlock()
+	}
+	if paused {
+		if err := vm.resume(); err != nil {
+			log.Warn(err.Error())
+		}
+	}
+
+	// leaves room for the guest to actually power off
+	if err := vm.powerOff(timeout / 3); err != nil {
+		log.Debug("unable to ask %v to power off over ssh (%v)", vm.Name, err)
+		if err = vm.hypervisor().Signal(vm, syscall.SIGTERM); err != nil {
+			log.Err(err.Error())
+		}
+	}
+	select {
+	case <-vm.gone:
+		return false
+	case <-expired:
 	}
+	log.Err("%v (%v) didn't halt within %v, SIGKILLing it now.", vm.Name,
+		vm.Pid, timeout)
+	vm.kill()
 	select {
+	case <-vm.gone:
 	case <-time.After(ServerTimeout):
-		log.Err("Attempting to halt %v (%v) with SIGTERM timed out, "+
-			"SIGKILLing it now.", vm.UUID, vm.exec.Process.Pid)
-		vm.kill()
-	case <-vm.done:
+		log.Err("%v (%v) still around after being SIGKILLed", vm.Name,
+			vm.Pid)
 	}
+	return true
 }
 
+// lookup tells whether a VM with the same name or UUID is already around.
+// Daemon's lock is to be held by the caller.
 func (vm *VMInfo) lookup() bool {
-	Daemon.Lock()
-	defer Daemon.Unlock()
 	// handles UUIDs
 	if _, ok := Daemon.Active[vm.UUID]; ok {
 		return true
@@ -381,6 +418,8 @@ func (vm *VMInfo) lookup() bool {
 	return false
 }
 
+// register makes the VM known to corectld. Daemon's lock is to be held by
+// the caller.
 func (vm *VMInfo) register() (err error) {
 	if vm.Name == "corectld" {
 		return fmt.Errorf("attempting to name a VM with the (only) " +
@@ -393,11 +432,12 @@ func (vm *VMInfo) register() (err error) {
 	}
 
 	if vm.lookup() {
-		err = fmt.Errorf("Aborted: Another VM is "+
+		err = api.Errorf(api.ErrCodeConflict, "Aborted: Another VM is "+
 			"already running with the same name or UUID (%s)", str)
 	} else {
 		Daemon.Active[vm.UUID] = vm
 		log.Info("registered %s", str)
+		vm.emit(api.EventRegistered, "")
 
 	}
 	return
@@ -411,6 +451,7 @@ func (vm *VMInfo) deregister() {
 	Daemon.DNSServer.rmRecord(vm.Name, vm.PublicIP)
 	log.Info("unregistered %s as it's gone", str)
 	delete(Daemon.Active, vm.UUID)
+	vm.emit(api.EventDeregistered, "")
 
 }
 
@@ -441,42 +482,10 @@ func (vm *VMInfo) TTY() string {
 	return filepath.Join(vm.RunDir(), "tty")
 }
 
-func (vm *VMInfo) PrettyPrint() {
-	fmt.Printf("\n UUID:\t\t%v\n  Name:\t\t%v\n  Version:\t%v\n  "+
-		"Channel:\t%v\n  vCPUs:\t%v\n  Memory (MB):\t%v\n",
-		vm.UUID, vm.Name, vm.Version, vm.Channel, vm.Cpus, vm.Memory)
-	fmt.Printf("  Pid:\t\t%v\n  Uptime:\t%v\n",
-		vm.Pid, humanize.Time(vm.CreationTime))
-	fmt.Printf("  Sees World:\t%v\n", vm.NotIsolated)
-	if vm.CloudConfig != "" {
-		fmt.Printf("  cloud-config:\t%v\n", vm.CloudConfig)
-	}
-	fmt.Println("  Network:")
-	fmt.Printf("    eth0:\t%v\n", vm.PublicIP)
-	vm.Storage.PrettyPrint(vm.PersistentRoot)
-}
-
-func (volumes *StorageAssets) PrettyPrint(root bool) {
-	if len(volumes.CDDrives)+len(volumes.HardDrives) > 0 {
-		fmt.Println("  Volumes:")
-		for a, b := range volumes.CDDrives {
-			fmt.Printf("   /dev/cdrom%v\t%s\n", a, b.Path)
-		}
-		for a, b := range volumes.HardDrives {
-			format := "raw"
-			i, _ := strconv.Atoi(a)
-			if b.Format == Qcow2 {
-				format = "qcow2"
-			}
-			if root && i == 0 {
-				fmt.Printf("   /,/dev/vd%v\t%s,format=%s\n", string(i+'a'),
-					b.Path, format)
-			} else {
-				fmt.Printf("   /dev/vd%v\t%s,format=%s\n", string(i+'a'),
-					b.Path, format)
-			}
-		}
-	}
+// Monitor is where the hypervisor's monitor socket lives, for the backends
+// which have one
+func (vm *VMInfo) Monitor() string {
+	return filepath.Join(vm.RunDir(), "monitor")
 }
 
 func (run VMs) Len() int {
@@ -489,6 +498,31 @@ func (run VMs) Less(i, j int) bool {
 	return run[i].CreationTime.Before(run[j].CreationTime)
 }
 
+// wire returns the VMs as clients see them
+func (in VMmap) wire() (out api.VMmap) {
+	out = make(api.VMmap, len(in))
+	for k, v := range in {
+		out[k] = &v.VMInfo
+	}
+	return
+}
+
+// public returns the VMs as they're to leave corectld, that is without the
+// private key corectld logs into them with
+func (vms VMs) public() (out api.VMmap) {
+	out = make(api.VMmap, len(vms))
+	for _, vm := range vms {
+		out[vm.UUID] = vm.public()
+	}
+	return
+}
+
+func (vm *VMInfo) public() *api.VMInfo {
+	info := vm.VMInfo
+	info.InternalSSHprivate = ""
+	return &info
+}
+
 func (in VMmap) array() (out VMs) {
 	for _, r := range in {
 		out = append(out, r)
@@ -17,11 +17,13 @@ package server
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -29,23 +31,27 @@ import (
 	"github.com/braintree/manners"
 	"github.com/coreos/etcd/client"
 	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/host/platform"
 	"github.com/genevera/corectl/components/host/session"
 	"github.com/genevera/corectl/release"
 )
 
 type (
-	// MediaAssets ...
+	// MediaAssets indexes, by channel, a target's locally available images
 	MediaAssets map[string]semver.Versions
 
 	// Config ...
 	ServerContext struct {
 		Meta              *release.Info
-		Media             MediaAssets
+		Media             map[string]MediaAssets
 		Active            VMmap
 		APIserver         *manners.GracefulServer
+		ControlServer     *manners.GracefulServer
+		RemoteServer      *manners.GracefulServer
 		EtcdServer        *EtcdServer
 		EtcdClient        client.KeysAPI
 		DNSServer         *DNSServer
+		Events            *EventBus
 		Jobs              sync.WaitGroup
 		AcceptingRequests bool
 		WorkingNFS        bool
@@ -65,17 +71,24 @@ func New() (cfg *ServerContext) {
 		WorkingNFS:        false,
 		Oops:              make(chan error, 1),
 		Active:            make(VMmap),
+		Events:            newEventBus(),
 	}
 }
 
 // Start server...
 func Start() (err error) {
+	var control, remote net.Listener
 	// var  closeVPNhooks func()
 	if !session.Caller.Privileged {
 		return fmt.Errorf("not enough previleges to start server. " +
 			"please use 'sudo'")
 	}
 
+	if _, err = LookupHypervisor(DefaultHypervisor); err != nil {
+		return
+	}
+	log.Info("VMs will run on %v by default", DefaultHypervisor)
+
 	if err = Daemon.NewEtcd(EtcdClientURLs, EtcdPeerURLs,
 		"corectld."+LocalDomainName, session.Caller.EtcDir()); err != nil {
 		return
@@ -98,8 +111,16 @@ func Start() (err error) {
 	}
 	defer Daemon.DNSServer.Stop()
 
+	log.Info("looking for VMs left behind by a previous session")
+	if err = Daemon.reattach(); err != nil {
+		log.Warn("unable to reconcile previously running VMs (%v)", err)
+		err = nil
+	}
+	pruneLogs()
+
 	log.Info("checking nfs host settings")
-	if err = nfsSetup(); err != nil {
+	if err = platform.Host.ExportShare(session.Caller.HomeDir,
+		session.Caller.Network.Base(), session.Caller.Network.Mask); err != nil {
 		log.Warn("Unable to setup NFS. " +
 			"No NFS facilities will be exposed to the VMs")
 		log.Warn("%v", err)
@@ -114,7 +135,7 @@ func Start() (err error) {
 	// defer closeVPNhooks()
 
 	log.Info("registering locally available images")
-	if Daemon.Media, err = localImages(); err != nil {
+	if Daemon.Media, err = allImages(); err != nil {
 		return
 	}
 	hades := make(chan os.Signal, 1)
@@ -126,13 +147,47 @@ func Start() (err error) {
 		log.Info("Got '%v' signal, stopping server...", s)
 		signal.Stop(hades)
 		Daemon.Oops <- nil
-		Daemon.Active.array().gracefullyShutdown()
+		if killed := Daemon.Active.array().gracefullyShutdown(
+			time.Now().Add(ShutdownDeadline)); len(killed) > 0 {
+			log.Warn("%v had to be hard killed", killed)
+		}
 	}()
 
 	log.Info("server starting...")
 
 	httpServiceSetup()
 	rpcServiceSetup()
+	restServiceSetup()
+
+	if err = setupPKI(); err != nil {
+		return fmt.Errorf("unable to setup TLS certificates (%v)", err)
+	}
+
+	if control, err = listenControl(); err != nil {
+		return
+	}
+	defer os.Remove(session.Caller.ControlSocket())
+	Daemon.ControlServer = manners.NewWithServer(&http.Server{
+		Handler: controlServices})
+	go func() {
+		if err := Daemon.ControlServer.Serve(control); err != nil {
+			Daemon.Oops <- err
+		}
+	}()
+	if RemoteAccess {
+		if remote, err = listenRemote(); err != nil {
+			return
+		}
+		log.Info("accepting remote clients, over mutual TLS, on :%v",
+			RemotePort)
+		Daemon.RemoteServer = manners.NewWithServer(&http.Server{
+			Handler: controlServices})
+		go func() {
+			if err := Daemon.RemoteServer.Serve(remote); err != nil {
+				Daemon.Oops <- err
+			}
+		}()
+	}
 
 	go func() {
 		Daemon.Lock()
@@ -158,6 +213,11 @@ func Start() (err error) {
 	}
 
 	Daemon.Jobs.Wait()
+	Daemon.Events.Close()
+	Daemon.ControlServer.Close()
+	if Daemon.RemoteServer != nil {
+		Daemon.RemoteServer.Close()
+	}
 	Daemon.APIserver.Close()
 	log.Info("gone!")
 	return
@@ -0,0 +1,174 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/api"
+)
+
+var (
+	// RestartBackoffCap is the longest corectld waits before restarting a
+	// VM whose runner went away
+	RestartBackoffCap = 5 * time.Minute
+	// a run that lasted this long resets the restart backoff
+	stableRun = 10 * time.Minute
+)
+
+// halt tells the VM's supervisor that the VM was deliberately stopped, and
+// so that it isn't to be restarted whatever its restart policy
+func (vm *VMInfo) halt() {
+	vm.haltOnce.Do(func() { close(vm.halted) })
+}
+
+func (vm *VMInfo) halting() bool {
+	select {
+	case <-vm.halted:
+		return true
+	default:
+		return false
+	}
+}
+
+// launch (re)starts the VM's runner
+func (vm *VMInfo) launch(hv Hypervisor) (err error) {
+	Daemon.Lock()
+	if err = hv.Start(vm, vm.bootArgs); err == nil {
+		vm.Pid = vm.process.Pid
+		// restarted, so that a corectld restart finds it under its new pid
+		if vm.PublicIP != "" {
+			if perr := vm.persistState(); perr != nil {
+				log.Warn("unable to persist %v's state (%v)", vm.Name, perr)
+			}
+		}
+	}
+	Daemon.Unlock()
+	if err != nil {
+		return
+	}
+	vm.emit(api.EventStarted, fmt.Sprintf("pid %v", vm.Pid))
+	return
+}
+
+// restartDue tells, according to the VM's restart policy, if it should be
+// brought back after its runner exited (with the given error)
+func (vm *VMInfo) restartDue(exit error, failures int) bool {
+	Daemon.Lock()
+	accepting := Daemon.AcceptingRequests
+	Daemon.Unlock()
+	if vm.halting() || !accepting {
+		return false
+	}
+	if vm.MaxRestarts > 0 && failures >= vm.MaxRestarts {
+		log.Warn("'%v' was restarted %v times in a row, giving up",
+			vm.Name, failures)
+		return false
+	}
+	switch vm.Restart {
+	case api.RestartAlways:
+		return true
+	case api.RestartOnFailure:
+		return exit != nil
+	}
+	return false
+}
+
+func backoff(failures int) (d time.Duration) {
+	if d = time.Second << uint(failures); d > RestartBackoffCap || d <= 0 {
+		d = RestartBackoffCap
+	}
+	return
+}
+
+func exitReason(err error) string {
+	if err == nil {
+		return "exited"
+	}
+	return err.Error()
+}
+
+// supervise runs the VM for as long as its restart policy asks for it, and
+// then cleans up after it. Runners adopted from a previous corectld
+// session are already up, so they're only waited upon at first.
+func (vm *VMInfo) supervise(hv Hypervisor, adopted bool) {
+	defer Daemon.Jobs.Done()
+
+	var (
+		failures  int
+		stopWatch = make(chan struct{})
+		watched   = make(chan struct{})
+	)
+	go vm.watchLog(stopWatch, watched)
+	for first := true; ; first = false {
+		if !first || !adopted {
+			if err := vm.launch(hv); err != nil {
+				if first {
+					vm.errCh <- err
+				} else {
+					log.Err("unable to restart '%v' (%v)", vm.Name, err)
+				}
+				break
+			}
+		}
+		began := time.Now()
+		exit := hv.Wait(vm)
+
+		Daemon.Lock()
+		vm.LastExit = exitReason(exit)
+		Daemon.Unlock()
+		vm.emit(api.EventExited, exitReason(exit))
+
+		if time.Since(began) > stableRun {
+			failures = 0
+		}
+		if !vm.restartDue(exit, failures) {
+			break
+		}
+		delay := backoff(failures)
+		failures++
+		log.Warn("'%v' went away (%v), restarting it in %v", vm.Name,
+			exitReason(exit), delay)
+		vm.emit(api.EventRestarting, fmt.Sprintf("in %v", delay))
+		select {
+		case <-time.After(delay):
+		case <-vm.halted:
+		}
+		if vm.halting() {
+			break
+		}
+		Daemon.Lock()
+		vm.Restarts++
+		// whatever was on the root volume is to be kept
+		vm.FormatRoot = false
+		Daemon.Unlock()
+		os.Remove(vm.TTY())
+	}
+
+	close(stopWatch)
+	<-watched
+
+	Daemon.Lock()
+	vm.deregister()
+	Daemon.Unlock()
+	os.Remove(vm.TTY())
+	os.Remove(vm.stateFile())
+	retainLog(vm.RunDir(), vm.Name, vm.UUID)
+	close(vm.gone)
+}
@@ -16,6 +16,8 @@
 package coreos
 
 import (
+	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/genevera/corectl/components/common/assets"
@@ -23,12 +25,11 @@ import (
 
 const (
 	latestImageBreackage = "2016-07-06T00:00:00WET"
-	defaultChannel       = "alpha"
-	defaultVersion       = "latest"
+	prefix               = "coreos_production_pxe"
 )
 
 var (
-	// CoreOS default Channels
+	// CoreOS Channels, the first being the default one
 	Channels = []string{"alpha", "beta", "stable"}
 
 	GPGLongID          = "50E0885593D2DCB4"
@@ -41,3 +42,32 @@ func LatestImageBreackage() (t time.Time) {
 	t, _ = time.Parse("2006-01-02T15:04:05MST", latestImageBreackage)
 	return
 }
+
+// Target is CoreOS Container Linux
+var Target = coreOS{}
+
+type coreOS struct{}
+
+func (coreOS) Name() string         { return "coreos" }
+func (coreOS) Channels() []string   { return Channels }
+func (coreOS) TrustKey() string     { return GPGKey }
+func (coreOS) Provisioning() string { return CoreOSIgnitionTmpl }
+func (coreOS) Breakage() time.Time  { return LatestImageBreackage() }
+
+func (coreOS) Upstream() string {
+	return "http://{channel}.release.core-os.net"
+}
+
+func (coreOS) Artifacts() (kernel, initrd, digests string) {
+	return prefix + ".vmlinuz", prefix + "_image.cpio.gz",
+		prefix + "_image.cpio.gz.DIGESTS.asc"
+}
+
+func (coreOS) LatestUpstream(c *http.Client, root string) (string, error) {
+	return LatestUpstream(c, root, "COREOS_VERSION")
+}
+
+func (coreOS) Cmdline(ignition string) string {
+	return fmt.Sprintf("earlyprintk=serial console=ttyS0 coreos.autologin "+
+		"coreos.first_boot=1 coreos.config.url=%s", ignition)
+}
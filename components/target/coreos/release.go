@@ -0,0 +1,67 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package coreos
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// LatestUpstream returns the version currently shipping in the given
+// channel's root, as told by the key set in its version.txt
+func LatestUpstream(c *http.Client, root, key string) (string, error) {
+	url := root + "/current/version.txt"
+
+	release, err := ReleaseInfo(c, url)
+	// if err we're probably offline
+	if err != nil {
+		return "", err
+	}
+	if v := release[key]; len(v) > 0 {
+		return v, nil
+	}
+	return "", fmt.Errorf("unable to grab '%s' from %s (!)", key, url)
+}
+
+// ReleaseInfo returns the fields set in the given version.txt, which
+// describes a release (its version, build and SDK, among others)
+func ReleaseInfo(c *http.Client, url string) (map[string]string, error) {
+	response, err := c.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	default:
+		return nil, fmt.Errorf("failed fetching %s: HTTP status: %s",
+			url, response.Status)
+	}
+
+	release := make(map[string]string)
+	s := bufio.NewScanner(response.Body)
+	s.Split(bufio.ScanLines)
+	for s.Scan() {
+		if kv := strings.SplitN(strings.TrimSpace(s.Text()), "=",
+			2); len(kv) == 2 {
+			release[kv[0]] = kv[1]
+		}
+	}
+	return release, s.Err()
+}
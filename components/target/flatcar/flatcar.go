@@ -0,0 +1,65 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package flatcar describes Flatcar Container Linux, CoreOS' drop-in
+// successor, as a corectl target
+package flatcar
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/genevera/corectl/components/target/coreos"
+)
+
+const prefix = "flatcar_production_pxe"
+
+// Channels are Flatcar's release channels, the first being the default one
+var Channels = []string{"stable", "beta", "alpha", "lts"}
+
+// Target is Flatcar Container Linux
+var Target = flatcar{}
+
+type flatcar struct{}
+
+func (flatcar) Name() string        { return "flatcar" }
+func (flatcar) Channels() []string  { return Channels }
+func (flatcar) Breakage() time.Time { return time.Time{} }
+
+// Flatcar's signing key isn't bundled, so it has to be provided by the user
+// (see https://www.flatcar.org/security/image-signing-key)
+func (flatcar) TrustKey() string { return "" }
+
+// Flatcar still honours the ignition configs CoreOS did
+func (flatcar) Provisioning() string { return coreos.CoreOSIgnitionTmpl }
+
+func (flatcar) Upstream() string {
+	return "https://{channel}.release.flatcar-linux.net"
+}
+
+func (flatcar) Artifacts() (kernel, initrd, digests string) {
+	return prefix + ".vmlinuz", prefix + "_image.cpio.gz",
+		prefix + "_image.cpio.gz.DIGESTS.asc"
+}
+
+func (flatcar) LatestUpstream(c *http.Client, root string) (string, error) {
+	return coreos.LatestUpstream(c, root, "FLATCAR_VERSION")
+}
+
+func (flatcar) Cmdline(ignition string) string {
+	return fmt.Sprintf("earlyprintk=serial console=ttyS0 flatcar.autologin "+
+		"flatcar.first_boot=1 ignition.config.url=%s", ignition)
+}
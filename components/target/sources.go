@@ -0,0 +1,149 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package target
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Source is somewhere images can be fetched from, laid out like the
+// targets' own release servers (<URL>/amd64-usr/<version>/<file>). Its URL
+// may be either http(s):// or file://, and hold a '{channel}' placeholder.
+type Source struct {
+	// Target and Channel, if set, restrict the source to the given ones
+	Target, Channel string
+	URL             string
+}
+
+// ParseSource parses a source given as '[target/][channel=]URL', or as
+// 'target=URL'
+func ParseSource(s string) (src Source, err error) {
+	var u *url.URL
+
+	if eq := strings.Index(s, "="); eq > 0 && !strings.Contains(s[:eq], ":") {
+		if src.Target, src.Channel, err = parseScope(s[:eq]); err != nil {
+			return
+		}
+		s = s[eq+1:]
+	}
+	src.URL = strings.TrimRight(s, "/")
+	if u, err = url.Parse(strings.Replace(src.URL,
+		"{channel}", "stable", -1)); err != nil {
+		return src, fmt.Errorf("'%s' is not a valid image source (%v)", s, err)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return src, fmt.Errorf("'%s' can't have a query or fragment", s)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			err = fmt.Errorf("'%s' has no host", s)
+		}
+	case "file":
+		if u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+			err = fmt.Errorf("'%s' isn't an absolute file:/// URL", s)
+		}
+	default:
+		err = fmt.Errorf("'%s' is neither a http(s):// nor a file:// URL", s)
+	}
+	return
+}
+
+// parseScope splits a source's 'target/channel', 'target' or 'channel'
+// restriction
+func parseScope(scope string) (target, channel string, err error) {
+	var t Target
+
+	if slash := strings.Index(scope, "/"); slash >= 0 {
+		target, channel = scope[:slash], scope[slash+1:]
+	} else if _, e := Get(scope); e == nil {
+		return scope, "", nil
+	} else {
+		channel = scope
+	}
+	if target != "" {
+		if t, err = Get(target); err != nil {
+			return
+		}
+		if !HasChannel(t, channel) {
+			err = fmt.Errorf("'%s' is not a known %s channel", channel, target)
+		}
+		return
+	}
+	for _, t = range targets {
+		if HasChannel(t, channel) {
+			return
+		}
+	}
+	return "", "", fmt.Errorf("'%s' is neither a known target nor channel",
+		scope)
+}
+
+// ParseSources parses, in order, all the given sources
+func ParseSources(all []string) (sources []Source, err error) {
+	for _, s := range all {
+		var src Source
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if src, err = ParseSource(s); err != nil {
+			return
+		}
+		sources = append(sources, src)
+	}
+	return
+}
+
+func (s Source) String() string {
+	switch {
+	case s.Target != "" && s.Channel != "":
+		return s.Target + "/" + s.Channel + "=" + s.URL
+	case s.Target != "":
+		return s.Target + "=" + s.URL
+	case s.Channel != "":
+		return s.Channel + "=" + s.URL
+	}
+	return s.URL
+}
+
+// IsLocal tells whether the source lives in the host's filesystem
+func (s Source) IsLocal() bool {
+	return strings.HasPrefix(s.URL, "file:")
+}
+
+// Root returns where the given channel's images live in the source
+func (s Source) Root(channel string) string {
+	return strings.Replace(s.URL, "{channel}", channel, -1) + "/amd64-usr"
+}
+
+// SourcesFor returns, in the order they should be tried, the sources
+// serving the given target's channel. The ones specific to the channel
+// come first, so that they override the generic ones, which are kept as
+// fallbacks.
+func SourcesFor(t Target, channel string, all []Source) (sources []Source) {
+	for _, specific := range []bool{true, false} {
+		for _, s := range all {
+			if (s.Target == "" || s.Target == t.Name()) &&
+				(s.Channel == channel) == specific &&
+				(specific || s.Channel == "") {
+				sources = append(sources, s)
+			}
+		}
+	}
+	return
+}
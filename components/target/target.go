@@ -0,0 +1,160 @@
+// Copyright (c) 2016 by António Meireles  <antonio.meireles@reformi.st>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package target abstracts the OS distributions corectl is able to boot,
+// all of which are expected to be CoreOS alike (PXE bootable out of a kernel
+// and an initrd, and provisioned via ignition)
+package target
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/deis/pkg/log"
+	"github.com/genevera/corectl/components/target/coreos"
+	"github.com/genevera/corectl/components/target/flatcar"
+)
+
+// Default is the target used when none is asked for
+const Default = "coreos"
+
+const defaultVersion = "latest"
+
+// custom channels hold images built, or imported, locally
+var customChannel = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// Target is an OS distribution, as far as corectl is concerned
+type Target interface {
+	// Name identifies the target, namespacing its images in the image store
+	Name() string
+	// Channels are the release channels, the first being the default one
+	Channels() []string
+	// Upstream is the target's own release server, as a Source URL
+	Upstream() string
+	// LatestUpstream returns the version currently shipping in the given
+	// channel's root (see Source.Root)
+	LatestUpstream(c *http.Client, root string) (string, error)
+	// Artifacts are the names of the kernel and initrd that make an image,
+	// and of the signed DIGESTS they are verified against
+	Artifacts() (kernel, initrd, digests string)
+	// TrustKey is the armored PGP public key releases are signed with, if
+	// bundled at all
+	TrustKey() string
+	// Cmdline is the kernel command line a VM boots with, given where its
+	// ignition config is served
+	Cmdline(ignition string) string
+	// Provisioning is the ignition template VMs get rendered
+	Provisioning() string
+	// Breakage is when local images last stopped being bootable, forcing
+	// them to be refetched
+	Breakage() time.Time
+}
+
+var targets = []Target{coreos.Target, flatcar.Target}
+
+// All returns all known targets
+func All() []Target {
+	return targets
+}
+
+// Names returns the names of all known targets
+func Names() (names []string) {
+	for _, t := range targets {
+		names = append(names, t.Name())
+	}
+	return
+}
+
+// Get returns the target with the given name, an empty one meaning the
+// Default one
+func Get(name string) (Target, error) {
+	if name == "" {
+		name = Default
+	}
+	for _, t := range targets {
+		if t.Name() == name {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("'%s' is not a known target (one of %s)",
+		name, strings.Join(Names(), ", "))
+}
+
+// HasChannel tells whether name is one of t's upstream channels
+func HasChannel(t Target, name string) bool {
+	for _, c := range t.Channels() {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidChannel checks that name is either one of t's upstream channels, or
+// usable as a custom one
+func ValidChannel(t Target, name string) error {
+	if HasChannel(t, name) || customChannel.MatchString(name) {
+		return nil
+	}
+	return fmt.Errorf("'%s' is neither a %s channel nor a valid custom one",
+		name, t.Name())
+}
+
+// Channel returns the given channel if valid for t, or its default one
+func Channel(t Target, name string) string {
+	if ValidChannel(t, name) == nil {
+		return name
+	}
+	return defaultChannel(t, name)
+}
+
+// ExistingChannel is Channel for operations that may reach upstream, which
+// only knows about t's own channels: custom ones are only taken if among
+// the existing ones (i.e. already holding local images), so that typos
+// still fall back to the default channel
+func ExistingChannel(t Target, name string, existing []string) string {
+	if HasChannel(t, name) {
+		return name
+	}
+	for _, c := range existing {
+		if c == name && customChannel.MatchString(name) {
+			return name
+		}
+	}
+	return defaultChannel(t, name)
+}
+
+func defaultChannel(t Target, name string) string {
+	log.Warn("'%s' is not a recognizable %s image channel. "+
+		"Using default ('%s')", name, t.Name(), t.Channels()[0])
+	return t.Channels()[0]
+}
+
+// Version returns the given version, if it is one, or 'latest'
+func Version(version string) string {
+	if version == defaultVersion {
+		return version
+	}
+	if _, err := semver.Make(version); err != nil {
+		log.Warn("'%s' is not in a recognizable version format. "+
+			"Using default ('%s') instead", version, defaultVersion)
+		return defaultVersion
+	}
+	return version
+}